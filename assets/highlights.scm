@@ -1,4 +1,4 @@
-(string) @string
+((string) @string (#set! priority 10))
 
 [
   "var"
@@ -27,6 +27,9 @@
 
 ((command_name) @function.builtin
   (#any-of? @function.builtin "echo" "type" "shopt" "json" "write" "assert" "fork" "forkwait"))
+[
+  "shvar"
+] @function.builtin
 ((command_name) @keyword.import
   (#eq? @keyword.import "use"))
 ((command_name) "=" @keyword.debug)
@@ -35,6 +38,7 @@
 [
   "func"
   "proc"
+  "typed"
 ] @keyword.function
 
 "return" @keyword.return
@@ -47,17 +51,27 @@
   "/"
   "**"
   "<"
+  "<>"
   ">"
+  ">&"
+  ">|"
   "|"
   "^"
   "&"
   ">>"
   "<<"
+  "<<<"
+  ">>&"
+  "&>>"
+  "<("
   "%"
   "<="
   ">="
   "="
-  "=="
+  "+="
+  "-="
+  "*="
+  "/="
   "==="
   "~=="
   "!=="
@@ -70,6 +84,10 @@
   "=>"
   "."
   "->"
+  ":"
+  "..."
+  "&&"
+  "||"
   (range_operator)
 ] @operator
 
@@ -77,33 +95,36 @@
   "or"
   "and"
   "not"
+  "is"
 ] @keyword.operator
 
 (boolean) @boolean
+(null) @constant.builtin
 
 variable: (variable_name) @variable
+constant: (variable_name) @constant
 ((variable_name) @variable
-             (#set! priority 10))
+             (#set! priority 20))
 (variable_assignment key: (variable_name) @property)
 
-(command_call (word) @variable.parameter)
-
 ; (dollar_token) @punctuation.special
 
 member: (variable_name) @variable.member
 key: (variable_name) @variable.member
-             (#set! priority 10)
+             (#set! priority 20)
 
 ((variable_name) @variable.builtin
                  (#eq? @variable.builtin "_error"))
 
-(function_definition (function_name) @function.method)
-(proc_definition (function_name) @function.method)
-(function_call call: (function_name) @function.call
+(function_definition (function_name) @function)
+(proc_definition (proc_name) @function)
+(function_call (function_name) @function.call
              (#set! priority 90))
 (method_call method: (function_name) @function.method.call)
-(function_parameter) @variable.parameter
+parameter: (variable_name) @variable.parameter
 (rest_of_arguments) @variable.parameter
+(parameter_list (named_parameter (variable_name) @variable.parameter))
+(proc_parameter_list (named_parameter (variable_name) @variable.parameter))
 
 [
   (escape_sequence)
@@ -118,8 +139,6 @@ key: (variable_name) @variable.member
 ; (function_call) @function.call
 ; ((function_name) @function.builtin (#any-of? @function.builtin "echo" "cat"))
 
-; (constant) @constant
-
 [
   "("
   ")"
@@ -140,4 +159,4 @@ key: (variable_name) @variable.member
  "@"
 ] @punctuation.special
 
-(ERROR) @error
+redirection_value: (word) @variable.parameter
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"io"
 	"log"
+	"runtime"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"charm.land/bubbles/v2/textarea"
 	"charm.land/lipgloss/v2"
+	"github.com/charmbracelet/x/ansi"
 
 	tea "charm.land/bubbletea/v2"
 
+	"github.com/Melkor333/oils-readline/history"
 	"github.com/Melkor333/oils-readline/shell"
 	"github.com/Melkor333/oils-readline/tiling"
 )
@@ -16,29 +22,98 @@ import (
 var (
 	promptStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("2")) // green
 	waitingStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("3")) // yellow
+	cursorStyle  = lipgloss.NewStyle().Reverse(true)
+	ghostStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("8")) // grey
 )
 
 type basicPrompt struct {
-	input    *textarea.Model
-	shell    shell.Shell
-	focussed bool
-	waiting  bool
+	shellBinding
+	input *textarea.Model
+	// The shell's prompt, drawn in front of the input
+	prompt string
+	width  int
+	hl     *Highlighter
+	menu   completionMenu
+	// Suggests how to go on from what's typed, nil for no suggestions
+	history *history.History
+	// The command line the input is the start of, see suggest. Update looks
+	// it up again once the input changes or suggested is reset.
+	suggestion, suggestedFor string
+	suggested                bool
+	focussed                 bool
+	waiting                  bool
+	// Shown instead of the placeholder until the next command, e.g. after a restart
+	notice string
 }
 
-type CommandEnteredMsg struct{ Text string }
+// CommandEnteredMsg runs Text in Shell, or the focused shell if it's nil.
+type CommandEnteredMsg struct {
+	Text  string
+	Shell shell.Shell
+	// Dir to change to before, if any
+	Dir string
+	// RerunOf links the command to the history entry it runs again
+	RerunOf string
+	// PipeFrom is an entry whose stdout, or stderr with PipeStderr, the
+	// command reads. A command line like `!12| grep x` sets it too.
+	PipeFrom   shell.Command
+	PipeStderr bool
+	// Input is read instead of what PipeFrom printed so far, e.g. to follow
+	// it while it runs. It's closed if the command doesn't run.
+	Input io.Reader
+}
+
+// SetPromptMsg replaces the input of the prompt of Shell, or all prompts if it's nil.
+type SetPromptMsg struct {
+	Text  string
+	Shell shell.Shell
+}
 
-func newBasicPrompt(s shell.Shell) *basicPrompt {
+func newBasicPrompt(s shell.Shell, h *history.History) *basicPrompt {
 	ti := textarea.New()
 	ti.SetVirtualCursor(true)
 	ti.Placeholder = "Enter command"
 	ti.Focus()
-	ti.CharLimit = 156
 	ti.Prompt = ""
-	//ti.Prompt = promptStyle.Render(s.GetPrompt())
+	ti.ShowLineNumbers = false
+	// Scripts get pasted, the input scrolls
+	ti.MaxHeight = 0
+
+	bp := &basicPrompt{
+		shellBinding: shellBinding{shell: s},
+		input:        &ti,
+		history:      h,
+	}
+	hl, err := NewHighlighter()
+	if err != nil {
+		log.Printf("No syntax highlighting: %v", err)
+	} else {
+		bp.hl = hl
+		// Its trees live in C
+		runtime.AddCleanup(bp, (*Highlighter).Close, hl)
+	}
+	bp.updatePrompt()
+	return bp
+}
+
+// updatePrompt renders the shell's prompt, prefixed with the shell if there are several.
+// Cheap, the shell caches its state
+func (bp *basicPrompt) updatePrompt() {
+	prompt := "$ "
+	if bp.shell != nil {
+		prompt = bp.shell.GetPrompt()
+		if label := bp.Title(); label != "" {
+			prompt = "[" + label + "] " + prompt
+		}
+	}
+	bp.prompt = promptStyle.Render(prompt)
+	bp.resize()
+}
 
-	return &basicPrompt{
-		input: &ti,
-		shell: s,
+// resize fits the input next to the prompt.
+func (bp *basicPrompt) resize() {
+	if bp.width > 0 {
+		bp.input.SetWidth(bp.width - lipgloss.Width(bp.prompt))
 	}
 }
 
@@ -47,15 +122,39 @@ func (bp *basicPrompt) Init() tea.Cmd {
 }
 
 func (bp *basicPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m, cmd := bp.update(msg)
+	// View only shows them
+	if bp.hl != nil {
+		// Cheap if nothing changed
+		bp.hl.Parse(bp.input.Value())
+	}
+	if !bp.suggested || bp.suggestedFor != bp.input.Value() {
+		bp.suggest()
+	}
+	return m, cmd
+}
+
+func (bp *basicPrompt) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyPressMsg:
 		// don't handle keyboard inputs if we're not focussed!
 		if !bp.input.Focused() {
 			return bp, nil
 		}
+		if bp.menu.open && bp.completionKey(msg) {
+			return bp, nil
+		}
 		switch msg.String() {
+		case "tab":
+			return bp, bp.complete()
+		case "right", "alt+f":
+			// Otherwise they move the cursor
+			if bp.acceptSuggestion(msg.String() == "alt+f") {
+				return bp, nil
+			}
 		case "ctrl+c":
 			bp.input.Reset()
+			bp.closeCompletions()
 			return bp, nil
 		case "ctrl+d":
 			if bp.input.Value() == "" {
@@ -63,24 +162,38 @@ func (bp *basicPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return bp, nil
 		case "enter":
-			command := bp.input.Value()
-			bp.input.Reset()
-			bp.input.Blur()
-			if len(command) == 0 {
+			if bp.incomplete() {
+				bp.newline()
 				return bp, nil
 			}
-			log.Print("Sending CommandEntered")
-			return bp, func() tea.Msg { return CommandEnteredMsg{Text: command} }
+			return bp, bp.submit()
+		case "alt+enter":
+			return bp, bp.submit()
+		}
+		// Whatever the keyboard needs to type them
+		switch msg.Text {
+		case "}", ")", "]":
+			bp.dedent()
 		}
 
 	case tea.WindowSizeMsg:
-		bp.input.SetWidth(msg.Width)
+		bp.width = msg.Width
+		bp.resize()
 		bp.input.SetHeight(msg.Height)
 		_, cmd := bp.input.Update(msg)
 		return bp, cmd
 
+	case ShellsMsg:
+		bp.updateShells(msg)
+		bp.updatePrompt()
+		return bp, nil
+
 	case shell.CommandMsg:
-		if msg.Cmd.State() == shell.Queued || msg.Cmd.State() == shell.Started {
+		if !bp.follows(msg.Shell) {
+			return bp, nil
+		}
+		// A new command might not have been picked up by the shell yet
+		if msg.Cmd.State() != shell.Stopped {
 			bp.waiting = true
 			bp.input.Placeholder = "busy..."
 			//bp.input.Prompt = waitingStyle.Render("busy... ")
@@ -88,9 +201,45 @@ func (bp *basicPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return bp, nil
 
+	case SetPromptMsg:
+		if bp.follows(msg.Shell) {
+			bp.input.SetValue(msg.Text)
+			bp.closeCompletions()
+		}
+		return bp, nil
+
+	case completionMsg:
+		if msg.prompt == bp {
+			bp.completed(msg)
+		}
+		return bp, nil
+
+	case shell.RestartedMsg:
+		if msg.Shell == bp.shell {
+			bp.notice = "shell restarted (" + msg.Err.Error() + ")"
+			if !bp.waiting {
+				bp.input.Placeholder = bp.notice
+			}
+		}
+		return bp, nil
+
+	case shell.StateMsg:
+		if msg.Shell == bp.shell {
+			bp.updatePrompt()
+			// Another directory has other suggestions
+			bp.suggested = false
+		}
+		return bp, nil
+
 	case shell.CommandDoneMsg:
+		if !bp.follows(msg.Shell) {
+			return bp, nil
+		}
 		bp.waiting = false
 		bp.input.Placeholder = "Enter command"
+		if bp.notice != "" {
+			bp.input.Placeholder = bp.notice
+		}
 		//bp.input.Prompt = promptStyle.Render("")
 		if bp.focussed {
 			return bp, bp.input.Focus()
@@ -100,6 +249,7 @@ func (bp *basicPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.BlurMsg:
 		bp.focussed = false
 		bp.input.Blur()
+		bp.closeCompletions()
 
 	case tea.FocusMsg:
 		bp.focussed = true
@@ -112,9 +262,191 @@ func (bp *basicPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	input, cmd := bp.input.Update(msg)
 	bp.input = &input
+	if _, ok := msg.(tea.KeyPressMsg); ok {
+		bp.filterCompletions()
+	}
 	return bp, cmd
 }
 
+// submit runs the input, complete or not.
+func (bp *basicPrompt) submit() tea.Cmd {
+	command := bp.input.Value()
+	bp.input.Reset()
+	bp.closeCompletions()
+	bp.notice = ""
+	bp.input.Blur()
+	if len(command) == 0 {
+		return nil
+	}
+	log.Print("Sending CommandEntered")
+	return func() tea.Msg { return CommandEnteredMsg{Text: command, Shell: bp.shell} }
+}
+
 func (bp *basicPrompt) View() tea.View {
-	return tea.NewView(strings.Trim(bp.input.View(), "\r\n"))
+	var lines []string
+	if bp.input.Value() == "" {
+		// Nothing to highlight, the textarea shows the placeholder
+		lines = strings.Split(strings.Trim(bp.input.View(), "\r\n"), "\n")
+	} else {
+		lines = bp.render()
+	}
+	lines = append(lines, bp.completionView()...)
+	indent := strings.Repeat(" ", lipgloss.Width(bp.prompt))
+	for i := range lines {
+		if i == 0 {
+			lines[i] = bp.prompt + lines[i]
+		} else {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return tea.NewView(strings.Join(lines, "\n"))
+}
+
+// render draws the input highlighted. It wraps like the textarea, so its
+// LineInfo says where the cursor is and moving up and down matches what we see.
+func (bp *basicPrompt) render() []string {
+	value := bp.input.Value()
+	var colors []string
+	if bp.hl != nil {
+		colors = bp.hl.Colors()
+	}
+	info := bp.input.LineInfo()
+	width := bp.input.Width()
+	// The cursor is at the end then, ghostRows draws it
+	ghost := bp.ghost()
+
+	var rows []string
+	cursorRow := 0
+	// Of the current rune in value
+	offset := 0
+	for l, line := range strings.Split(value, "\n") {
+		runes := []rune(line)
+		k := 0
+		for wl, wrapped := range wrapInput(runes, width) {
+			cursor := -1
+			if l == bp.input.Line() && wl == info.RowOffset && bp.input.Focused() {
+				cursor = info.ColumnOffset
+				cursorRow = len(rows)
+			}
+			// The space it wrapped at doesn't fit
+			shown := len(wrapped)
+			if ansi.StringWidth(string(wrapped)) > width {
+				shown--
+			}
+
+			var row strings.Builder
+			color, run := "", []rune{}
+			flush := func() {
+				writeColored(&row, string(run), color)
+				run = run[:0]
+			}
+			for j, r := range wrapped {
+				c := ""
+				if k < len(runes) {
+					if offset < len(colors) {
+						c = colors[offset]
+					}
+					offset += utf8.RuneLen(runes[k])
+					k++
+				}
+				if j >= shown && j != cursor {
+					continue
+				}
+				if j == cursor {
+					flush()
+					if ghost == "" {
+						row.WriteString(cursorStyle.Render(string(r)))
+					}
+					continue
+				}
+				if c != color {
+					flush()
+					color = c
+				}
+				run = append(run, r)
+			}
+			flush()
+			if cursor >= len(wrapped) && ghost == "" {
+				row.WriteString(cursorStyle.Render(" "))
+			}
+			if cursor >= 0 && ghost != "" {
+				rows = append(rows, ghostRows(row.String(), ghost, width)...)
+				continue
+			}
+			rows = append(rows, row.String())
+		}
+		// The newline
+		offset++
+	}
+
+	// Scroll to the cursor
+	if height := bp.input.Height(); len(rows) > height {
+		start := max(0, min(cursorRow-height+1, len(rows)-height))
+		rows = rows[start : start+height]
+	}
+	return rows
+}
+
+func writeColored(s *strings.Builder, text, color string) {
+	if text == "" {
+		return
+	}
+	if color == "" {
+		s.WriteString(text)
+		return
+	}
+	s.WriteString(color)
+	s.WriteString(text)
+	s.WriteString(colorMap["black"])
+}
+
+// wrapInput is the word wrap of the textarea. Each rune stays in place, whitespace
+// turns into spaces and a space is added at the end for the cursor.
+func wrapInput(runes []rune, width int) [][]rune {
+	var (
+		lines  = [][]rune{{}}
+		word   = []rune{}
+		row    int
+		spaces int
+	)
+
+	for _, r := range runes {
+		if unicode.IsSpace(r) {
+			spaces++
+		} else {
+			word = append(word, r)
+		}
+
+		if spaces > 0 {
+			if ansi.StringWidth(string(lines[row]))+ansi.StringWidth(string(word))+spaces > width {
+				row++
+				lines = append(lines, []rune{})
+			}
+			lines[row] = append(lines[row], word...)
+			lines[row] = append(lines[row], []rune(strings.Repeat(" ", spaces))...)
+			spaces = 0
+			word = nil
+		} else {
+			// A double-width rune at the end might not fit
+			lastCharLen := ansi.StringWidth(string(word[len(word)-1]))
+			if ansi.StringWidth(string(word))+lastCharLen > width {
+				// The word fills up a line on its own
+				if len(lines[row]) > 0 {
+					row++
+					lines = append(lines, []rune{})
+				}
+				lines[row] = append(lines[row], word...)
+				word = nil
+			}
+		}
+	}
+
+	spaces++
+	if ansi.StringWidth(string(lines[row]))+ansi.StringWidth(string(word))+spaces-1 >= width {
+		row++
+		lines = append(lines, []rune{})
+	}
+	lines[row] = append(lines[row], word...)
+	lines[row] = append(lines[row], []rune(strings.Repeat(" ", spaces))...)
+	return lines
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/execsh"
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/assert"
+)
+
+type promptShell struct {
+	MockShell
+	prompt string
+}
+
+func (s *promptShell) GetPrompt() string { return s.prompt }
+
+func updatePrompt(t *testing.T, bp tea.Model, msg tea.Msg) *basicPrompt {
+	t.Helper()
+	result, _ := bp.Update(msg)
+	return result.(*basicPrompt)
+}
+
+func TestBasicPromptFollowsState(t *testing.T) {
+	s := &promptShell{prompt: "~ $ "}
+	bp := newBasicPrompt(s, nil)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 40, Height: 3})
+	assert.Contains(t, bp.View().Content, "~ $ ")
+
+	s.prompt = "~/src $ "
+	bp = updatePrompt(t, bp, shell.StateMsg{Shell: &promptShell{prompt: "/other $ "}})
+	assert.NotContains(t, bp.View().Content, "/other", "state of other shells is ignored")
+	bp = updatePrompt(t, bp, shell.StateMsg{Shell: s})
+	assert.Contains(t, bp.View().Content, "~/src $ ")
+}
+
+func TestBasicPromptRestartNotice(t *testing.T) {
+	s := &MockShell{}
+	bp := newBasicPrompt(s, nil)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 80, Height: 3})
+
+	bp = updatePrompt(t, bp, shell.RestartedMsg{Shell: s, Err: errors.New("boom")})
+	assert.Equal(t, "shell restarted (boom)", bp.input.Placeholder)
+
+	// Still there after the command that crashed is done
+	bp = updatePrompt(t, bp, shell.CommandDoneMsg{Cmd: &MockCommand{}})
+	assert.Equal(t, "shell restarted (boom)", bp.input.Placeholder)
+
+	// Gone after the next command
+	bp.input.SetValue("true")
+	bp = updatePrompt(t, bp, tea.KeyPressMsg{Code: tea.KeyEnter})
+	bp = updatePrompt(t, bp, shell.CommandDoneMsg{Cmd: &MockCommand{}})
+	assert.Equal(t, "Enter command", bp.input.Placeholder)
+}
+
+func TestBasicPromptExecShell(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	os.Mkdir(filepath.Join(dir, "sub"), 0700)
+	s, err := execsh.New(execsh.WithDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cancel()
+	bp := newBasicPrompt(s, nil)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 80, Height: 3})
+	assert.Contains(t, bp.View().Content, dir+" $ ")
+
+	c, err := s.Command("cd sub", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Run()
+	bp = updatePrompt(t, bp, shell.StateMsg{Shell: s, State: s.State()})
+	assert.Contains(t, bp.View().Content, filepath.Join(dir, "sub")+" $ ")
+}
+
+func TestBasicPromptHighlights(t *testing.T) {
+	bp := newBasicPrompt(&promptShell{prompt: "~ $ "}, nil)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 24, Height: 4})
+	bp = updatePrompt(t, bp, SetPromptMsg{Text: "echo 'a long argument' | grep -v argument"})
+	assert.Contains(t, bp.View().Content, colorMap["string"]+"'a long ")
+
+	// Wraps and puts the cursor where the textarea would
+	plain := func(s string) []string {
+		lines := strings.Split(ansi.Strip(s), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " ")
+		}
+		// The textarea fills its height
+		for len(lines) > 0 && lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		return lines
+	}
+	for col, cursor := range map[int]struct {
+		row  int
+		char string
+	}{0: {0, "e"}, 7: {0, " "}, 25: {1, "g"}, 41: {2, " "}} {
+		bp.input.SetCursorColumn(col)
+		rows := strings.Split(bp.View().Content, "\n")
+		assert.Contains(t, rows[cursor.row], cursorStyle.Render(cursor.char), col)
+
+		// Without the prompt
+		want := plain(bp.input.View())
+		got := plain(bp.View().Content)
+		if assert.Len(t, got, len(want), col) {
+			for i := range got {
+				assert.Equal(t, want[i], got[i][4:], col)
+			}
+		}
+		assert.Contains(t, got[0], "~ $ ")
+	}
+
+	// Parsed in Update, View only shows it
+	bp.input.SetValue("echo 'other'")
+	bp.View()
+	assert.Equal(t, "echo 'a long argument' | grep -v argument", string(bp.hl.source))
+	bp = updatePrompt(t, bp, tea.KeyPressMsg{Code: tea.KeyEnd})
+	assert.Equal(t, "echo 'other'", string(bp.hl.source))
+}
+
+// completingShell completes the words it knows, like the shells complete files.
+type completingShell struct {
+	MockShell
+	dirs, files []string
+	calls       int
+}
+
+func (s *completingShell) Complete(req shell.CompletionReq) (shell.CompletionResult, error) {
+	s.calls++
+	start := shell.WordStart(req.Text, req.Pos)
+	word := req.Text[start:req.Pos]
+	match := func(words []string) (m []string) {
+		for _, w := range words {
+			if strings.HasPrefix(w, word) {
+				m = append(m, w)
+			}
+		}
+		return m
+	}
+	return shell.FileCompletions(match(s.dirs), match(s.files), start, req.Pos), nil
+}
+
+func typePrompt(bp *basicPrompt, keys ...string) tea.Cmd {
+	var cmd tea.Cmd
+	for _, k := range keys {
+		switch k {
+		case "tab":
+			_, cmd = bp.Update(tea.KeyPressMsg{Code: tea.KeyTab})
+		case "shift+tab":
+			_, cmd = bp.Update(tea.KeyPressMsg{Code: tea.KeyTab, Mod: tea.ModShift})
+		case "enter":
+			_, cmd = bp.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+		default:
+			for _, r := range k {
+				_, cmd = bp.Update(tea.KeyPressMsg{Code: r, Text: string(r)})
+			}
+		}
+	}
+	return cmd
+}
+
+func TestBasicPromptCompletion(t *testing.T) {
+	s := &completingShell{dirs: []string{"src/", "scripts/"}, files: []string{"setup.py", "my file.txt"}}
+	bp := newBasicPrompt(s, nil)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 80, Height: 10})
+
+	cmd := typePrompt(bp, "cat s", "tab")
+	assert.Equal(t, 0, s.calls, "the shell is asked off the UI goroutine")
+	assert.Nil(t, typePrompt(bp, "tab"), "one request at a time")
+	// Typing goes on meanwhile
+	typePrompt(bp, "c")
+	bp.Update(cmd())
+	view := ansi.Strip(bp.View().Content)
+	assert.Contains(t, view, " scripts/  dir")
+	assert.NotContains(t, view, "src/", "filtered by what was typed meanwhile")
+
+	typePrompt(bp, "r", "i")
+	assert.Contains(t, ansi.Strip(bp.View().Content), "scripts/")
+	typePrompt(bp, "enter")
+	assert.Equal(t, "cat scripts/", bp.input.Value(), "enter accepts, it doesn't run")
+	assert.False(t, bp.menu.open)
+
+	// Tab and shift+tab cycle
+	bp.input.SetValue("cat s")
+	bp.Update(typePrompt(bp, "tab")())
+	typePrompt(bp, "tab", "tab", "shift+tab")
+	assert.Contains(t, ansi.Strip(bp.View().Content), " setup.py  file")
+	typePrompt(bp, "enter")
+	assert.Equal(t, "cat scripts/", bp.input.Value())
+
+	// Quoted, and the only one is taken right away
+	bp.input.SetValue("cat my")
+	bp.Update(typePrompt(bp, "tab")())
+	assert.Equal(t, "cat 'my file.txt'", bp.input.Value())
+	assert.False(t, bp.menu.open)
+
+	// Too late
+	bp.input.SetValue("cat s")
+	cmd = typePrompt(bp, "tab")
+	typePrompt(bp, "enter")
+	bp.Update(cmd())
+	assert.False(t, bp.menu.open)
+	assert.Empty(t, bp.input.Value())
+}
+
+func TestBasicPromptSuggestion(t *testing.T) {
+	h := history.New()
+	h.Seed([]history.Entry{
+		{Command: "git status", Status: 0},
+		{Command: "git push origin main", Status: 0},
+		{Command: "git pull", Status: 1},
+	})
+	bp := newBasicPrompt(&promptShell{prompt: "~ $ "}, h)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 40, Height: 4})
+
+	typePrompt(bp, "git p")
+	view := bp.View().Content
+	assert.Contains(t, view, cursorStyle.Render("u")+ghostStyle.Render("sh origin main"), "the one which succeeded")
+	assert.Equal(t, "git p", bp.input.Value(), "only shown")
+
+	bp = updatePrompt(t, bp, tea.KeyPressMsg{Code: 'f', Mod: tea.ModAlt})
+	assert.Equal(t, "git push", bp.input.Value(), "alt+f takes a word")
+	bp = updatePrompt(t, bp, tea.KeyPressMsg{Code: tea.KeyRight})
+	assert.Equal(t, "git push origin main", bp.input.Value(), "right takes the rest")
+	assert.NotContains(t, bp.View().Content, ghostStyle.Render(""))
+
+	// Only at the end
+	bp.input.SetValue("git s")
+	bp = updatePrompt(t, bp, tea.KeyPressMsg{Code: tea.KeyLeft})
+	assert.NotContains(t, ansi.Strip(bp.View().Content), "tatus")
+	bp = updatePrompt(t, bp, tea.KeyPressMsg{Code: tea.KeyRight})
+	assert.Equal(t, "git s", bp.input.Value(), "right moves the cursor")
+	assert.Contains(t, ansi.Strip(bp.View().Content), "git status")
+
+	// Wraps like the input
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 12, Height: 4})
+	bp.input.SetValue("")
+	typePrompt(bp, "git pu")
+	rows := strings.Split(ansi.Strip(bp.View().Content), "\n")
+	assert.Equal(t, []string{"~ $ git push", "    origin", "    main"}, rows[:3])
+
+	// Looked up in Update, View only shows it
+	bp.input.SetValue("git st")
+	assert.NotContains(t, ansi.Strip(bp.View().Content), "git status")
+	assert.Equal(t, "git pu", bp.suggestedFor)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 40, Height: 4})
+	assert.Contains(t, ansi.Strip(bp.View().Content), "git status")
+}
+
+func TestBasicPromptMultiline(t *testing.T) {
+	bp := newBasicPrompt(&promptShell{prompt: "~ $ "}, nil)
+	bp = updatePrompt(t, bp, tea.WindowSizeMsg{Width: 40, Height: 6})
+
+	assert.Nil(t, typePrompt(bp, "proc foo {", "enter"), "not done yet")
+	typePrompt(bp, "if (x) {", "enter", "echo hi", "enter", "}", "enter", "}")
+	assert.Equal(t, "proc foo {\n  if (x) {\n    echo hi\n  }\n}", bp.input.Value(), "indented and dedented")
+	assert.Contains(t, ansi.Strip(bp.View().Content), "\n        echo hi")
+
+	cmd := typePrompt(bp, "enter")
+	if assert.NotNil(t, cmd) {
+		assert.Equal(t, "proc foo {\n  if (x) {\n    echo hi\n  }\n}", cmd().(CommandEnteredMsg).Text)
+	}
+
+	// An unterminated quote, run anyway
+	bp.input.Focus()
+	typePrompt(bp, "echo 'it", "enter", "works")
+	_, cmd = bp.Update(tea.KeyPressMsg{Code: tea.KeyEnter, Mod: tea.ModAlt})
+	if assert.NotNil(t, cmd, "alt+enter runs it") {
+		assert.Equal(t, "echo 'it\nworks", cmd().(CommandEnteredMsg).Text)
+	}
+
+	// Longer than the textarea lets one be by default, and still grows
+	bp.input.Focus()
+	script := strings.Repeat("echo 'a long line of a script'\n", 200)
+	bp = updatePrompt(t, bp, tea.PasteMsg{Content: script})
+	assert.Equal(t, script, bp.input.Value())
+	typePrompt(bp, "if (x) {", "enter", "echo hi", "enter", "}")
+	assert.Equal(t, script+"if (x) {\n  echo hi\n}", bp.input.Value())
+	bp = updatePrompt(t, bp, tea.KeyPressMsg{Code: 'm', Mod: tea.ModCtrl})
+	assert.Equal(t, script+"if (x) {\n  echo hi\n}\n", bp.input.Value(), "the textarea's own newline")
+}
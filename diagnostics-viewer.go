@@ -0,0 +1,69 @@
+package main
+
+import (
+	"charm.land/bubbles/v2/viewport"
+	tea "charm.land/bubbletea/v2"
+	"github.com/muesli/reflow/wrap"
+
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/Melkor333/oils-readline/tiling"
+)
+
+// DiagnosticsViewer shows what the interpreter itself printed to stderr,
+// e.g. errors in its rc file or why it crashed.
+type DiagnosticsViewer struct {
+	shellBinding
+	view   viewport.Model
+	Width  int
+	Height int
+}
+
+func newDiagnosticsViewer(s shell.Shell) *DiagnosticsViewer {
+	return &DiagnosticsViewer{shellBinding: shellBinding{shell: s}}
+}
+
+func (d *DiagnosticsViewer) Init() tea.Cmd {
+	return tiling.DisplaySelf(100)
+}
+
+func (d *DiagnosticsViewer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		d.Width = msg.Width
+		d.Height = msg.Height
+		d.view.SetWidth(msg.Width)
+		d.view.SetHeight(max(0, msg.Height-1))
+		d.updateContent()
+		return d, nil
+
+	case ShellsMsg:
+		d.updateShells(msg)
+		return d, nil
+
+	case shell.DiagnosticsMsg:
+		if msg.Shell == d.shell {
+			d.updateContent()
+		}
+		return d, nil
+
+	case tea.KeyPressMsg:
+		var cmd tea.Cmd
+		d.view, cmd = d.view.Update(msg)
+		return d, cmd
+	}
+	return d, nil
+}
+
+func (d *DiagnosticsViewer) updateContent() {
+	s, ok := d.shell.(shell.Supervised)
+	if !ok {
+		d.view.SetContent("shell has no diagnostics")
+		return
+	}
+	d.view.SetContent(wrap.String(s.Diagnostics(), d.Width))
+	d.view.GotoBottom()
+}
+
+func (d *DiagnosticsViewer) View() tea.View {
+	return tea.NewView(inactiveColor.Render("diagnostics") + "\n" + d.view.View())
+}
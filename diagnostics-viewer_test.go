@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+type supervisedShell struct {
+	MockShell
+	diagnostics string
+}
+
+func (s *supervisedShell) Diagnostics() string         { return s.diagnostics }
+func (s *supervisedShell) SetOnDiagnostics(fn func())  {}
+func (s *supervisedShell) SetOnRestart(fn func(error)) {}
+
+func updateDiagnosticsViewer(t *testing.T, d tea.Model, msg tea.Msg) *DiagnosticsViewer {
+	t.Helper()
+	result, _ := d.Update(msg)
+	return result.(*DiagnosticsViewer)
+}
+
+func TestDiagnosticsViewerShowsDiagnostics(t *testing.T) {
+	s := &supervisedShell{}
+	d := newDiagnosticsViewer(s)
+	d = updateDiagnosticsViewer(t, d, tea.WindowSizeMsg{Width: 80, Height: 10})
+	assert.NotContains(t, d.View().Content, "rc file")
+
+	s.diagnostics = "error in rc file\n"
+	d = updateDiagnosticsViewer(t, d, shell.DiagnosticsMsg{Shell: s})
+	assert.Contains(t, d.View().Content, "error in rc file")
+
+	// Diagnostics of other shells are ignored
+	other := &supervisedShell{diagnostics: "other shell\n"}
+	d = updateDiagnosticsViewer(t, d, shell.DiagnosticsMsg{Shell: other})
+	assert.NotContains(t, d.View().Content, "other shell")
+}
+
+func TestDiagnosticsViewerUnsupervisedShell(t *testing.T) {
+	d := newDiagnosticsViewer(&MockShell{})
+	d = updateDiagnosticsViewer(t, d, tea.WindowSizeMsg{Width: 80, Height: 10})
+	assert.Contains(t, d.View().Content, "no diagnostics")
+}
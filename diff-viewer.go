@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"charm.land/bubbles/v2/viewport"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/aymanbagabas/go-udiff"
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/Melkor333/oils-readline/tiling"
+)
+
+var hunkColor = lipgloss.NewStyle().Foreground(lipgloss.Color("6")) // cyan
+
+// diffRefresh is how often the diff of a running command is done again. Each
+// diff goes over all of the output, doing it for every bit of it is too slow.
+const diffRefresh = 100 * time.Millisecond
+
+type diffRefreshMsg struct{ d *DiffViewer }
+
+// DiffViewer compares the output of two commands of the history.
+// By default these are the last command and the run before it,
+// see history.PreviousRun.
+type DiffViewer struct {
+	shellBinding
+	history *history.History
+	// Indexes of the compared entries, newIndex -1 follows the last command
+	oldIndex, newIndex int
+	old, new           shell.Command
+	showStderr         bool
+	sideBySide         bool
+	// Where the hunks start in the content, for n and N
+	hunks []int
+	// The new command printed something since the last diff, and a refresh
+	// is on its way
+	stale, refreshing bool
+	view              viewport.Model
+	Width             int
+	Height            int
+}
+
+func newDiffViewer(s shell.Shell, h *history.History) *DiffViewer {
+	return &DiffViewer{shellBinding: shellBinding{shell: s}, history: h, oldIndex: -1, newIndex: -1}
+}
+
+func (d *DiffViewer) Init() tea.Cmd {
+	d.pick()
+	return tiling.DisplaySelf(100)
+}
+
+// pick looks up the compared commands again. Without an old entry it's the
+// run before the new one.
+func (d *DiffViewer) pick() {
+	newIndex := d.newIndex
+	if newIndex < 0 {
+		newIndex = d.history.Count() - 1
+	}
+	d.new, _ = d.history.AtIndex(newIndex)
+	oldIndex := d.oldIndex
+	if oldIndex < 0 {
+		oldIndex, _ = d.history.PreviousRun(newIndex)
+	}
+	d.old, _ = d.history.AtIndex(oldIndex)
+	d.updateContent()
+}
+
+// indexes returns the indexes of the compared commands, -1 if there's none.
+func (d *DiffViewer) indexes() (oldIndex, newIndex int) {
+	oldIndex, newIndex = -1, -1
+	if d.old != nil {
+		oldIndex, _ = d.history.GetIndexOf(d.old)
+	}
+	if d.new != nil {
+		newIndex, _ = d.history.GetIndexOf(d.new)
+	}
+	return oldIndex, newIndex
+}
+
+func (d *DiffViewer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		oldIndex, newIndex := d.indexes()
+		switch msg.String() {
+		case "h", "l":
+			// Another new entry, compared with the run before it
+			if newIndex < 0 {
+				return d, nil
+			}
+			if msg.String() == "h" {
+				newIndex--
+			} else {
+				newIndex++
+			}
+			if newIndex < 0 || newIndex >= d.history.Count() {
+				return d, nil
+			}
+			d.newIndex, d.oldIndex = newIndex, -1
+			d.pick()
+			return d, nil
+		case "H", "L":
+			// Compare with another old entry
+			if oldIndex < 0 {
+				oldIndex = newIndex
+			}
+			if msg.String() == "H" {
+				oldIndex--
+			} else {
+				oldIndex++
+			}
+			if oldIndex < 0 || oldIndex >= d.history.Count() {
+				return d, nil
+			}
+			d.newIndex, d.oldIndex = newIndex, oldIndex
+			d.pick()
+			return d, nil
+		case "s":
+			// Stick to the compared entries, or follow the last command again
+			if d.newIndex < 0 {
+				d.newIndex, d.oldIndex = newIndex, oldIndex
+			} else {
+				d.newIndex, d.oldIndex = -1, -1
+				d.pick()
+			}
+			return d, nil
+		case "n":
+			for _, line := range d.hunks {
+				if line > d.view.YOffset() {
+					d.view.SetYOffset(line)
+					break
+				}
+			}
+			return d, nil
+		case "N":
+			for i := len(d.hunks) - 1; i >= 0; i-- {
+				if d.hunks[i] < d.view.YOffset() {
+					d.view.SetYOffset(d.hunks[i])
+					break
+				}
+			}
+			return d, nil
+		case "e":
+			d.showStderr = !d.showStderr
+			d.updateContent()
+			return d, nil
+		case "v":
+			d.sideBySide = !d.sideBySide
+			d.updateContent()
+			return d, nil
+		case "r", "R", "i":
+			return d, rerunKeys(msg.String(), d.new, d.shell)
+		}
+		var cmd tea.Cmd
+		d.view, cmd = d.view.Update(msg)
+		return d, cmd
+
+	case ShellsMsg:
+		d.updateShells(msg)
+		return d, nil
+
+	case shell.CommandMsg:
+		if d.newIndex < 0 && d.follows(msg.Shell) {
+			d.pick()
+		}
+		return d, nil
+
+	case shell.StdoutMsg:
+		if msg.Cmd == d.new && !d.showStderr {
+			return d, d.refreshLater()
+		}
+		return d, nil
+
+	case shell.StderrMsg:
+		if msg.Cmd == d.new && d.showStderr {
+			return d, d.refreshLater()
+		}
+		return d, nil
+
+	case diffRefreshMsg:
+		if msg.d == d {
+			d.refreshing = false
+			if d.stale {
+				d.updateContent()
+			}
+		}
+		return d, nil
+
+	case shell.CommandDoneMsg:
+		if msg.Cmd == d.new {
+			d.updateContent()
+		}
+		return d, nil
+
+	case tea.WindowSizeMsg:
+		d.Width = msg.Width
+		d.Height = msg.Height
+		d.view.SetWidth(msg.Width)
+		d.view.SetHeight(max(0, msg.Height-1))
+		d.updateContent()
+		return d, nil
+	}
+	return d, nil
+}
+
+// output is what's compared of c, without colors and carriage returns.
+func (d *DiffViewer) output(c shell.Command) string {
+	out := c.Stdout()
+	if d.showStderr {
+		out = c.Stderr()
+	}
+	out = strings.ReplaceAll(ansi.Strip(out), "\r", "")
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out
+}
+
+// refreshLater does the diff again in a bit, with whatever was printed until
+// then.
+func (d *DiffViewer) refreshLater() tea.Cmd {
+	d.stale = true
+	if d.refreshing {
+		return nil
+	}
+	d.refreshing = true
+	return tea.Tick(diffRefresh, func(time.Time) tea.Msg { return diffRefreshMsg{d} })
+}
+
+func (d *DiffViewer) updateContent() {
+	d.stale = false
+	d.hunks = nil
+	if d.old == nil || d.new == nil {
+		d.view.SetContent("")
+		return
+	}
+	before, after := d.output(d.old), d.output(d.new)
+	diff, err := udiff.ToUnifiedDiff("old", "new", before, udiff.Lines(before, after), udiff.DefaultContextLines)
+	if err != nil {
+		d.view.SetContent(highlightColor.Render(err.Error()))
+		return
+	}
+	if len(diff.Hunks) == 0 {
+		d.view.SetContent(inactiveColor.Render("same output"))
+		return
+	}
+	var lines []string
+	for _, h := range diff.Hunks {
+		d.hunks = append(d.hunks, len(lines))
+		lines = append(lines, hunkColor.Render(hunkHeader(h)))
+		if d.sideBySide {
+			lines = append(lines, sideBySide(h, d.Width)...)
+		} else {
+			lines = append(lines, unified(h)...)
+		}
+	}
+	d.view.SetContentLines(lines)
+}
+
+// hunkHeader is the @@ line of h, like diff -u.
+func hunkHeader(h *udiff.Hunk) string {
+	var from, to int
+	for _, l := range h.Lines {
+		if l.Kind != udiff.Insert {
+			from++
+		}
+		if l.Kind != udiff.Delete {
+			to++
+		}
+	}
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.FromLine, from, h.ToLine, to)
+}
+
+func unified(h *udiff.Hunk) []string {
+	var lines []string
+	for _, l := range h.Lines {
+		content := strings.TrimSuffix(l.Content, "\n")
+		switch l.Kind {
+		case udiff.Delete:
+			lines = append(lines, highlightColor.Render("-"+content))
+		case udiff.Insert:
+			lines = append(lines, activeColor.Render("+"+content))
+		default:
+			lines = append(lines, " "+content)
+		}
+	}
+	return lines
+}
+
+// sideBySide puts the old lines of h left and the new ones right, changed lines
+// next to what they replaced.
+func sideBySide(h *udiff.Hunk, width int) []string {
+	column := max(1, (width-1)/2)
+	cell := func(s string, style *lipgloss.Style) string {
+		s = strings.ReplaceAll(strings.TrimSuffix(s, "\n"), "\t", "    ")
+		s = ansi.Truncate(s, column, "…")
+		padding := strings.Repeat(" ", column-ansi.StringWidth(s))
+		if style != nil {
+			s = style.Render(s)
+		}
+		return s + padding
+	}
+	var lines, deleted, inserted []string
+	flush := func() {
+		for i := range max(len(deleted), len(inserted)) {
+			left, right := cell("", nil), ""
+			if i < len(deleted) {
+				left = cell(deleted[i], &highlightColor)
+			}
+			if i < len(inserted) {
+				right = cell(inserted[i], &activeColor)
+			}
+			lines = append(lines, left+"│"+right)
+		}
+		deleted, inserted = nil, nil
+	}
+	for _, l := range h.Lines {
+		switch l.Kind {
+		case udiff.Delete:
+			deleted = append(deleted, l.Content)
+		case udiff.Insert:
+			inserted = append(inserted, l.Content)
+		default:
+			flush()
+			lines = append(lines, cell(l.Content, nil)+"│"+cell(l.Content, nil))
+		}
+	}
+	flush()
+	return lines
+}
+
+func (d *DiffViewer) View() tea.View {
+	title := "diff"
+	if d.showStderr {
+		title = highlightColor.Render("diff stderr")
+	}
+	if d.newIndex >= 0 {
+		title = activeColor.Render("[s] ") + title
+	}
+	oldIndex, newIndex := d.indexes()
+	switch {
+	case d.new == nil:
+		return tea.NewView(title + " " + inactiveColor.Render("no command yet"))
+	case d.old == nil:
+		return tea.NewView(fmt.Sprintf("%s [%d] %s %s", title, newIndex, d.new.CommandLine(), inactiveColor.Render("didn't run before")))
+	}
+	header := fmt.Sprintf("%s [%d] %s → [%d] %s", title, oldIndex, d.old.CommandLine(), newIndex, d.new.CommandLine())
+	if n := len(d.hunks); n > 0 {
+		current := 0
+		for i, line := range d.hunks {
+			if line <= d.view.YOffset() {
+				current = i
+			}
+		}
+		header += inactiveColor.Render(fmt.Sprintf(" %d/%d", current+1, n))
+	}
+	return tea.NewView(header + "\n" + d.view.View())
+}
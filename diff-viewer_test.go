@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDiffHistory(cc ...shell.Command) *history.History {
+	h := history.New()
+	for _, c := range cc {
+		h.Add(c, history.Entry{})
+	}
+	return h
+}
+
+func TestDiffViewerComparesRuns(t *testing.T) {
+	first := newFakeCmd("ls", "a\nb\nc\n")
+	other := newFakeCmd("date", "today\n")
+	second := newFakeCmd("ls", "a\nB\nc\nd\n")
+	d := newDiffViewer(nil, newDiffHistory(first, other, second))
+	d.Update(tea.WindowSizeMsg{Width: 40, Height: 10})
+	d.Init()
+
+	assert.Same(t, first, d.old, "compares with the last run of the same command")
+	assert.Same(t, second, d.new)
+	view := ansi.Strip(d.View().Content)
+	assert.Contains(t, view, "[0] ls → [2] ls")
+	assert.Contains(t, view, "@@ -1,3 +1,4 @@")
+	assert.Contains(t, view, "-b")
+	assert.Contains(t, view, "+B")
+	assert.Contains(t, view, "+d")
+	assert.Contains(t, view, " a")
+
+	d.Update(tea.KeyPressMsg{Code: 'v', Text: "v"})
+	view = ansi.Strip(d.View().Content)
+	assert.Contains(t, view, "b                  │B")
+	assert.Contains(t, view, "                   │d")
+	assert.Contains(t, view, "a                  │a")
+
+	// date never ran before
+	d.Update(tea.KeyPressMsg{Code: 'h', Text: "h"})
+	assert.Same(t, other, d.new)
+	assert.Nil(t, d.old)
+	assert.Contains(t, d.View().Content, "didn't run before")
+
+	// Compare it with something else
+	d.Update(tea.KeyPressMsg{Code: 'H', Text: "H"})
+	assert.Same(t, first, d.old)
+}
+
+func TestDiffViewerFollows(t *testing.T) {
+	h := newDiffHistory(newFakeCmd("make", "ok\n"))
+	d := newDiffViewer(nil, h)
+	d.Update(tea.WindowSizeMsg{Width: 40, Height: 10})
+	d.Init()
+	assert.Contains(t, d.View().Content, "didn't run before")
+
+	again := newFakeCmd("make", "ok\n")
+	h.Add(again, history.Entry{})
+	d.Update(shell.CommandMsg{Cmd: again})
+	assert.Same(t, again, d.new)
+	assert.Contains(t, d.View().Content, "same output")
+
+	again.(*fakeCommand).stdout = "ok\nwarning\n"
+	_, cmd := d.Update(shell.StdoutMsg{Cmd: again})
+	d.Update(cmd())
+	assert.Contains(t, ansi.Strip(d.View().Content), "+warning")
+
+	// Sticky entries don't change
+	d.Update(tea.KeyPressMsg{Code: 's', Text: "s"})
+	h.Add(newFakeCmd("make", ""), history.Entry{})
+	d.Update(shell.CommandMsg{})
+	assert.Same(t, again, d.new)
+}
+
+func TestDiffViewerHunks(t *testing.T) {
+	var before, after strings.Builder
+	for i := range 40 {
+		line := strings.Repeat("x", i) + "\n"
+		before.WriteString(line)
+		if i == 5 || i == 30 {
+			line = "changed\n"
+		}
+		after.WriteString(line)
+	}
+	d := newDiffViewer(nil, newDiffHistory(newFakeCmd("gen", before.String()), newFakeCmd("gen", after.String())))
+	d.Update(tea.WindowSizeMsg{Width: 80, Height: 5})
+	d.Init()
+	assert.Len(t, d.hunks, 2)
+
+	d.Update(tea.KeyPressMsg{Code: 'n', Text: "n"})
+	assert.Equal(t, d.hunks[1], d.view.YOffset())
+	assert.Contains(t, d.View().Content, "2/2")
+	d.Update(tea.KeyPressMsg{Code: 'N', Text: "N"})
+	assert.Equal(t, 0, d.view.YOffset())
+}
+
+func TestDiffViewerManyChunks(t *testing.T) {
+	h := newDiffHistory(newFakeCmd("make", "ok\n"))
+	running := newFakeCmd("make", "")
+	h.Add(running, history.Entry{})
+	d := newDiffViewer(nil, h)
+	d.Update(tea.WindowSizeMsg{Width: 40, Height: 10})
+	d.Init()
+
+	var refresh tea.Cmd
+	for i := range 1000 {
+		running.(*fakeCommand).stdout += fmt.Sprintf("line %d\n", i)
+		_, cmd := d.Update(shell.StdoutMsg{Cmd: running})
+		if cmd != nil {
+			assert.Nil(t, refresh, "one refresh at a time, at chunk %d", i)
+			refresh = cmd
+		}
+	}
+	assert.NotContains(t, ansi.Strip(d.View().Content), "+1,1000", "not diffed for every chunk")
+
+	d.Update(refresh())
+	assert.Contains(t, ansi.Strip(d.View().Content), "@@ -1,1 +1,1000 @@")
+
+	// The last bit shows right away once it's done
+	running.(*fakeCommand).stdout += "done\n"
+	_, cmd := d.Update(shell.StdoutMsg{Cmd: running})
+	assert.NotNil(t, cmd, "another refresh")
+	d.Update(shell.CommandDoneMsg{Cmd: running})
+	d.view.GotoBottom()
+	assert.Contains(t, ansi.Strip(d.View().Content), "+done")
+}
@@ -0,0 +1,256 @@
+package execsh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/creack/pty"
+)
+
+// Command is a command line run on its own pty.
+type Command struct {
+	shell       *Shell
+	commandline string
+	err         error
+	exitCode    int
+	ctx         context.Context
+	Cancel      context.CancelFunc
+
+	ptmx, tty            *os.File
+	stderr, stderrIn     *os.File
+	stdoutBuf, stderrBuf strings.Builder
+	stdoutMu, stderrMu   sync.Mutex
+	onStdout, onStderr   func()
+	// Where else the output goes, see Subscribe
+	stdoutSubs, stderrSubs shell.Subscribers
+	// Read instead of the tty, see SetInput
+	input io.Reader
+
+	// The process group to signal, 0 until it started
+	pgid  atomic.Int64
+	wg    sync.WaitGroup
+	state atomic.Int32
+}
+
+func (s *Shell) Command(commandLine string, size *pty.Winsize) (shell.Command, error) {
+	c := &Command{
+		shell:       s,
+		commandline: commandLine,
+		exitCode:    -1,
+	}
+	c.SetState(shell.Ready)
+	c.ctx, c.Cancel = context.WithCancel(context.Background())
+
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	pty.Setsize(ptmx, size)
+	c.ptmx, c.tty = ptmx, tty
+
+	c.stderr, c.stderrIn, err = os.Pipe()
+	if err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, err
+	}
+
+	// Will be done when the command was executed
+	c.wg.Add(1)
+	c.wg.Go(func() {
+		c.copy(c.ptmx, &c.stdoutBuf, &c.stdoutMu, &c.onStdout, &c.stdoutSubs)
+		c.ptmx.Close()
+	})
+	c.wg.Go(func() { c.copy(c.stderr, &c.stderrBuf, &c.stderrMu, &c.onStderr, &c.stderrSubs) })
+	return c, nil
+}
+
+// copy collects the output of r until it's closed, and passes it on to subs.
+// A pty says EIO instead of EOF once the command is gone.
+func (c *Command) copy(r io.Reader, buf *strings.Builder, mu *sync.Mutex, notify *func(), subs *shell.Subscribers) {
+	defer subs.Close()
+	b := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			mu.Lock()
+			buf.Write(b[:n])
+			mu.Unlock()
+			subs.Write(b[:n])
+			if *notify != nil {
+				(*notify)()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Run waits for the previous command to finish and runs this one.
+// The state only becomes Queued if another command is running.
+// Calling Cancel while the command is still queued drops it,
+// afterwards it interrupts the running command.
+func (c *Command) Run() {
+	stop := func() bool { return false }
+	stdin, err := c.stdinFile()
+	if err != nil {
+		// Like run, so the output ends
+		c.tty.Close()
+		c.stderrIn.Close()
+	} else {
+		err = c.shell.run(c.ctx, c.commandline, true, stdin, c.tty, c.stderrIn,
+			func() { c.SetState(shell.Queued) },
+			func(p *os.Process) {
+				// It's the session leader, see run
+				c.pgid.Store(int64(p.Pid))
+				c.SetState(shell.Started)
+				stop = context.AfterFunc(c.ctx, func() { c.Interrupt() })
+			},
+		)
+	}
+	stop()
+
+	c.err = err
+	var exitErr *shell.ExitError
+	switch {
+	case err == nil:
+		c.exitCode = 0
+	case errors.As(err, &exitErr):
+		c.exitCode = exitErr.Status
+	}
+	// Publishing the state makes err and exitCode visible to other goroutines
+	c.SetState(shell.Stopped)
+	c.wg.Done()
+}
+
+// Signal sends sig to the process group of the command.
+// A command which is still queued is dropped from the queue instead.
+func (c *Command) Signal(sig os.Signal) error {
+	switch c.State() {
+	case shell.Ready, shell.Stopped:
+		return shell.ErrNotRunning
+	case shell.Queued:
+		c.Cancel()
+		return nil
+	}
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", sig)
+	}
+	return syscall.Kill(-int(c.pgid.Load()), s)
+}
+
+func (c *Command) Interrupt() error {
+	return c.Signal(syscall.SIGINT)
+}
+
+func (c *Command) Kill() error {
+	return c.Signal(syscall.SIGKILL)
+}
+
+func (c *Command) Wait() {
+	c.wg.Wait()
+}
+
+func (c *Command) CommandLine() string {
+	return c.commandline
+}
+
+func (c *Command) Stdin() io.Writer {
+	return c.ptmx
+}
+
+func (c *Command) Stdout() string {
+	c.stdoutMu.Lock()
+	defer c.stdoutMu.Unlock()
+	return c.stdoutBuf.String()
+}
+
+func (c *Command) Stderr() string {
+	c.stderrMu.Lock()
+	defer c.stderrMu.Unlock()
+	return c.stderrBuf.String()
+}
+
+// SetStdout isn't supported, the output always comes from the pty.
+func (c *Command) SetStdout(stdout io.Reader) {}
+
+// SetStdin isn't supported, the input always goes to the pty.
+func (c *Command) SetStdin(stdin io.Writer) {}
+
+// SetInput makes the command read r on stdin instead of the terminal.
+// r is closed once the command stops reading, if it's an io.Closer.
+func (c *Command) SetInput(r io.Reader) {
+	c.input = r
+}
+
+// stdinFile returns what the command reads: the tty, or a pipe fed from input.
+func (c *Command) stdinFile() (*os.File, error) {
+	if c.input == nil {
+		return c.tty, nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	// Ends with EPIPE if the command doesn't read everything
+	go func() {
+		io.Copy(w, c.input)
+		w.Close()
+		if closer, ok := c.input.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+	return r, nil
+}
+
+// Subscribe writes the output of stream to w as well, while the command runs.
+func (c *Command) Subscribe(stream shell.Stream, w io.Writer) (stop func() error) {
+	if stream == shell.Stderr {
+		return c.stderrSubs.Subscribe(w)
+	}
+	return c.stdoutSubs.Subscribe(w)
+}
+
+func (c *Command) SetOnStdout(fn func()) {
+	c.onStdout = fn
+}
+
+func (c *Command) SetOnStderr(fn func()) {
+	c.onStderr = fn
+}
+
+func (c *Command) State() shell.CommandState {
+	return shell.CommandState(c.state.Load())
+}
+
+func (c *Command) SetState(s shell.CommandState) {
+	c.state.Store(int32(s))
+}
+
+func (c *Command) Resize(size *pty.Winsize) error {
+	return pty.Setsize(c.ptmx, size)
+}
+
+func (c *Command) ExitCode() int {
+	if c.State() != shell.Stopped {
+		return -1
+	}
+	return c.exitCode
+}
+
+func (c *Command) Err() error {
+	if c.State() != shell.Stopped {
+		return nil
+	}
+	return c.err
+}
@@ -0,0 +1,358 @@
+// Package execsh is a shell.Shell without oils.
+//
+// Every command line is run by a fresh `sh -c` (or bash, see -exec_shell)
+// on its own pty. There's no shell process in between, so the working
+// directory and exported variables are captured when a command exits and
+// handed to the next one. Everything else (variables which aren't exported,
+// functions, aliases, jobs) is lost after each command.
+package execsh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+var (
+	shellPath = flag.String("exec_shell", "sh", "Shell which runs the commands of the exec backend")
+)
+
+var ErrClosed = errors.New("shell closed")
+
+// wrapper runs the command line in $1 and reports the status, working
+// directory and environment on fd 3 when the shell exits, even with `exit`.
+// The environment comes from `export -p`, env -0 isn't POSIX.
+// bash runs the EXIT trap with a status of 0 when it's interrupted, so the
+// signals get their own traps.
+const wrapper = `__oils_readline_state() {
+  __oils_readline_status=${1:-$?}
+  trap - EXIT
+  printf '%s\0%s\0%s\0' "$__oils_readline_status" "$(pwd -P)" "$PWD" >&3
+  export -p >&3
+  exit "$__oils_readline_status"
+}
+trap __oils_readline_state EXIT
+trap '__oils_readline_state 129' HUP
+trap '__oils_readline_state 130' INT
+trap '__oils_readline_state 143' TERM
+eval "$1"`
+
+type Shell struct {
+	path   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Holds a value while a command runs, blocked senders queue up in order
+	turn chan struct{}
+
+	mu    sync.Mutex
+	state shell.State
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// An Option configures a Shell created by New.
+type Option func(*Shell)
+
+// WithShell runs the commands with name (sh, bash, ...) instead of -exec_shell.
+// An empty name keeps the default.
+func WithShell(name string) Option {
+	return func(s *Shell) {
+		if name != "" {
+			s.path = name
+		}
+	}
+}
+
+// WithDir starts in dir instead of the current directory.
+func WithDir(dir string) Option {
+	return func(s *Shell) { s.state.Cwd = dir }
+}
+
+func New(opts ...Option) (*Shell, error) {
+	s := &Shell{
+		path: *shellPath,
+		turn: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	path, err := exec.LookPath(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.path = path
+	if s.state.Cwd == "" {
+		if s.state.Cwd, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	// So the first prompt already knows where it is
+	if _, err := s.RefreshState(); err != nil {
+		log.Printf("Can't get the shell state: %v", err)
+	}
+	return s, nil
+}
+
+// Interpreter is the name of the shell running the commands, e.g. bash.
+func (s *Shell) Interpreter() string {
+	return filepath.Base(s.path)
+}
+
+func (s *Shell) Cancel() {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		close(s.done)
+	})
+}
+
+// Wait blocks until the shell is canceled.
+func (s *Shell) Wait() {
+	<-s.done
+}
+
+// Run runs command with the given files, which are closed afterwards.
+// A non-zero status is returned as *shell.ExitError.
+// Concurrent calls are queued and run one after another.
+func (s *Shell) Run(command string, stdin, stdout, stderr *os.File) error {
+	return s.run(context.Background(), command, false, stdin, stdout, stderr, nil, nil)
+}
+
+// run waits until no other command runs and starts command in the last
+// known state. With tty, stdin becomes the controlling terminal.
+// onQueued is called if it has to wait, onStart with the started process.
+// A request that is still waiting when ctx is done is dropped.
+func (s *Shell) run(ctx context.Context, command string, tty bool, stdin, stdout, stderr *os.File, onQueued func(), onStart func(*os.Process)) error {
+	defer func() {
+		stdin.Close()
+		stdout.Close()
+		stderr.Close()
+	}()
+	if err := s.acquire(ctx, onQueued); err != nil {
+		return err
+	}
+	defer func() { <-s.turn }()
+
+	// Not a pipe, background jobs would keep it open and we'd never see EOF
+	report, err := os.CreateTemp("", "oils-readline-state-")
+	if err != nil {
+		return err
+	}
+	os.Remove(report.Name())
+	defer report.Close()
+
+	cmd := exec.Command(s.path, "-c", wrapper, "oils-readline", command)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.ExtraFiles = []*os.File{report}
+	s.mu.Lock()
+	cmd.Dir = s.state.Cwd
+	cmd.Env = environ(s.state.Env)
+	s.mu.Unlock()
+	if tty {
+		// stdout, stdin may be a pipe, see Command.SetInput
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+	} else {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Hang up like a closed terminal when the shell goes away
+	stop := context.AfterFunc(s.ctx, func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGHUP) })
+	defer stop()
+	if onStart != nil {
+		onStart(cmd.Process)
+	}
+
+	cmd.Wait()
+
+	// The wrapper shares our offset
+	report.Seek(0, io.SeekStart)
+	reported, _ := io.ReadAll(report)
+	status, ok := s.update(reported)
+	if !ok {
+		// Died before it could report, e.g. killed
+		status = exitStatus(cmd.ProcessState)
+	}
+	if status != 0 {
+		return &shell.ExitError{Status: status}
+	}
+	return nil
+}
+
+// acquire waits for the turn to start a command.
+func (s *Shell) acquire(ctx context.Context, onQueued func()) error {
+	select {
+	case s.turn <- struct{}{}:
+		return nil
+	default:
+	}
+	if onQueued != nil {
+		onQueued()
+	}
+	select {
+	case s.turn <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// update takes the state from what the wrapper reported and returns the
+// exit status of the command. ok is false if the report is incomplete.
+func (s *Shell) update(report []byte) (status int, ok bool) {
+	fields := bytes.SplitN(report, []byte{0}, 4)
+	if len(fields) < 4 {
+		return 0, false
+	}
+	if _, err := fmt.Sscan(string(fields[0]), &status); err != nil {
+		return 0, false
+	}
+	env, err := parseExports(string(fields[3]))
+	if err != nil {
+		log.Printf("Can't read the environment from export -p: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Status = status
+	s.state.Cwd = string(fields[1])
+	s.state.PWD = string(fields[2])
+	// Nothing exported at all is more likely an export -p we can't read,
+	// keep what we had
+	if len(env) > 0 {
+		s.state.Env = env
+	}
+	return status, true
+}
+
+func exitStatus(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ps.ExitCode()
+}
+
+// environ turns env into what exec.Cmd expects.
+// Without a captured environment the commands inherit ours.
+func environ(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	var list []string
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// State returns the state captured after the last command.
+func (s *Shell) State() shell.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state
+	st.Env = maps.Clone(st.Env)
+	return st
+}
+
+// RefreshState runs an empty command to capture the state.
+// Status still belongs to the last real command.
+func (s *Shell) RefreshState() (shell.State, error) {
+	status := s.State().Status
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		return shell.State{}, err
+	}
+	stdout, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		stdin.Close()
+		return shell.State{}, err
+	}
+	stderr, stderrIn, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return shell.State{}, err
+	}
+	defer stderr.Close()
+	var errOut strings.Builder
+	var wg sync.WaitGroup
+	wg.Go(func() { io.Copy(&errOut, stderr) })
+	err = s.Run("", stdin, stdout, stderrIn)
+	wg.Wait()
+	if err == nil && errOut.Len() > 0 {
+		err = fmt.Errorf("%s: %s", s.path, strings.TrimSpace(errOut.String()))
+	}
+
+	s.mu.Lock()
+	s.state.Status = status
+	s.mu.Unlock()
+	return s.State(), err
+}
+
+func (s *Shell) Dir() string {
+	return s.State().Cwd
+}
+
+func (s *Shell) GetPrompt() string {
+	return shell.DefaultPrompt(s.State())
+}
+
+// Complete completes file names relative to the working directory.
+func (s *Shell) Complete(req shell.CompletionReq) (shell.CompletionResult, error) {
+	from := shell.WordStart(req.Text, req.Pos)
+	to := req.Pos
+	for to < len(req.Text) && !strings.ContainsRune(" \t\n", rune(req.Text[to])) {
+		to++
+	}
+	word := req.Text[from:req.Pos]
+	dir, prefix := filepath.Split(word)
+	lookup := dir
+	if !filepath.IsAbs(lookup) {
+		lookup = filepath.Join(s.Dir(), dir)
+	}
+	entries, err := os.ReadDir(lookup)
+	if err != nil {
+		return shell.CompletionResult{}, err
+	}
+	var dirs, files []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		if e.IsDir() {
+			dirs = append(dirs, dir+name+"/")
+		} else {
+			files = append(files, dir+name)
+		}
+	}
+	// Sort case insensitive
+	for _, names := range [][]string{dirs, files} {
+		sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	}
+	return shell.FileCompletions(dirs, files, from, to), nil
+}
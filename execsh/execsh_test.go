@@ -0,0 +1,299 @@
+package execsh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/creack/pty"
+)
+
+func newTestShell(t *testing.T, opts ...Option) *Shell {
+	t.Helper()
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Cancel)
+	return s
+}
+
+// run runs command without a terminal and returns its output.
+func run(t *testing.T, s *Shell, command string) (stdout, stderr string, err error) {
+	t.Helper()
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdoutReader.Close()
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderrReader.Close()
+
+	var out, errOut strings.Builder
+	var wg sync.WaitGroup
+	wg.Go(func() { io.Copy(&out, stdoutReader) })
+	wg.Go(func() { io.Copy(&errOut, stderrReader) })
+	err = s.Run(command, stdin, stdoutWriter, stderrWriter)
+	wg.Wait()
+	return out.String(), errOut.String(), err
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShell_Run(t *testing.T) {
+	s := newTestShell(t)
+
+	stdout, stderr, err := run(t, s, "echo hello; echo oops >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "hello\n" || stderr != "oops\n" {
+		t.Errorf("got %q and %q", stdout, stderr)
+	}
+
+	_, _, err = run(t, s, "exit 3")
+	var exitErr *shell.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Status != 3 {
+		t.Errorf("got %v, wanted exit status 3", err)
+	}
+	if s.State().Status != 3 {
+		t.Errorf("got status %d, wanted 3", s.State().Status)
+	}
+}
+
+func TestShell_State(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ = filepath.EvalSymlinks(dir)
+	s := newTestShell(t, WithDir(dir))
+	if s.Dir() != dir {
+		t.Errorf("got dir %q, wanted %q", s.Dir(), dir)
+	}
+
+	if _, _, err := run(t, s, "cd sub && export OILS_READLINE_TEST=kept; NOT_EXPORTED=lost"); err != nil {
+		t.Fatal(err)
+	}
+	state := s.State()
+	if want := filepath.Join(dir, "sub"); state.Cwd != want || state.PWD != want {
+		t.Errorf("got cwd %q and pwd %q, wanted %q", state.Cwd, state.PWD, want)
+	}
+	if state.Env["OILS_READLINE_TEST"] != "kept" {
+		t.Errorf("exported variable is missing: %v", state.Env)
+	}
+
+	stdout, _, err := run(t, s, `echo "$OILS_READLINE_TEST $NOT_EXPORTED"; pwd`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "kept \n" + filepath.Join(dir, "sub") + "\n"; stdout != want {
+		t.Errorf("got %q, wanted %q", stdout, want)
+	}
+
+	state, err = s.RefreshState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Env["OILS_READLINE_TEST"] != "kept" {
+		t.Errorf("refreshing lost the environment: %v", state.Env)
+	}
+}
+
+func TestCommand_ExitCode(t *testing.T) {
+	s := newTestShell(t)
+
+	c, err := s.Command("printf hi; tty >/dev/null && exit 2", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ExitCode() != -1 {
+		t.Errorf("exit code before running should be -1, got %d", c.ExitCode())
+	}
+	c.Run()
+	c.Wait()
+	if c.ExitCode() != 2 {
+		t.Errorf("got exit code %d, wanted 2 (is stdin a tty?)", c.ExitCode())
+	}
+	if c.Stdout() != "hi" {
+		t.Errorf("got stdout %q", c.Stdout())
+	}
+}
+
+func TestCommand_Signal(t *testing.T) {
+	tests := []struct {
+		name   string
+		signal func(c *Command) error
+		want   int
+	}{
+		{"Interrupt", (*Command).Interrupt, 130},
+		{"Kill", (*Command).Kill, 137},
+		{"Cancel", func(c *Command) error { c.Cancel(); return nil }, 130},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestShell(t)
+			sc, err := s.Command("echo ready; sleep 10", &pty.Winsize{Rows: 10, Cols: 80})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := sc.(*Command)
+			go c.Run()
+			// A signal before the shell is ready would only hit the wrapper
+			waitFor(t, func() bool { return strings.Contains(c.Stdout(), "ready") })
+
+			if err := tt.signal(c); err != nil {
+				t.Fatal(err)
+			}
+			c.Wait()
+			if c.ExitCode() != tt.want {
+				t.Errorf("got exit code %d, wanted %d", c.ExitCode(), tt.want)
+			}
+			if err := c.Interrupt(); !errors.Is(err, shell.ErrNotRunning) {
+				t.Errorf("got %v, wanted ErrNotRunning", err)
+			}
+		})
+	}
+}
+
+func TestCommand_CancelQueued(t *testing.T) {
+	s := newTestShell(t)
+
+	slow, err := s.Command("sleep 0.2", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := s.Command("echo never", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(slow.Run)
+	waitFor(t, func() bool { return slow.State() == shell.Started })
+	wg.Go(queued.Run)
+	waitFor(t, func() bool { return queued.State() == shell.Queued })
+
+	queued.(*Command).Cancel()
+	wg.Wait()
+	if !errors.Is(queued.Err(), context.Canceled) {
+		t.Errorf("got error %v, wanted context.Canceled", queued.Err())
+	}
+	if slow.ExitCode() != 0 {
+		t.Errorf("slow command should still succeed, got %d", slow.ExitCode())
+	}
+}
+
+func TestShell_Complete(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "Makefile", ".hidden"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+	os.Mkdir(filepath.Join(dir, "magic"), 0700)
+	s := newTestShell(t, WithDir(dir))
+
+	comps, err := s.Complete(shell.CompletionReq{Text: "cat ma", Pos: 6})
+	if err != nil || comps.From != 4 || comps.To != 6 {
+		t.Fatalf("got %v, %v", comps, err)
+	}
+	var got []string
+	for _, c := range comps.Options {
+		got = append(got, c.Type+":"+c.Label)
+	}
+	if strings.Join(got, " ") != "dir:magic/ file:main.go" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestCommand_SetInput(t *testing.T) {
+	s := newTestShell(t)
+
+	c, err := s.Command("tr a-z A-Z; test -t 1 || exit 2", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.(shell.Redirectable).SetInput(strings.NewReader("earlier output"))
+	c.Run()
+	c.Wait()
+	if err := c.Err(); err != nil {
+		t.Fatalf("%v (is stdout still a tty?)", err)
+	}
+	if c.Stdout() != "EARLIER OUTPUT" {
+		t.Errorf("got stdout %q", c.Stdout())
+	}
+}
+
+func TestParseExports(t *testing.T) {
+	for _, out := range []string{
+		// dash
+		"export A='it'\"'\"'s'\nexport B='two\nlines'\nexport C='x\\y'\nexport UNSET\n",
+		// bash
+		"declare -x A=\"it's\"\ndeclare -x B=$'two\\nlines'\ndeclare -x C=\"x\\\\y\"\ndeclare -x UNSET\n" +
+			"declare -ax ARR=([0]=\"a b\")\n",
+		// bash --posix
+		"export A=\"it's\"\nexport B=$'two\\012lines'\nexport C=x\\\\y\nexport UNSET\n",
+	} {
+		env, err := parseExports(out)
+		if err != nil {
+			t.Errorf("%q: %v", out, err)
+			continue
+		}
+		want := map[string]string{"A": "it's", "B": "two\nlines", "C": `x\y`}
+		if len(env) != len(want) {
+			t.Errorf("%q: got %q", out, env)
+		}
+		for k, v := range want {
+			if env[k] != v {
+				t.Errorf("%q: got %s=%q, wanted %q", out, k, env[k], v)
+			}
+		}
+	}
+	if _, err := parseExports("export A='open\n"); err == nil {
+		t.Error("an unterminated quote isn't an error")
+	}
+}
+
+func TestShell_StateShells(t *testing.T) {
+	for _, name := range []string{"sh", "dash", "bash"} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := exec.LookPath(name); err != nil {
+				t.Skip(err)
+			}
+			s := newTestShell(t, WithShell(name))
+			value := "it's \"$odd\"\n\ttwo lines \\ \x01"
+			if _, _, err := run(t, s, "export OILS_READLINE_TEST=\"$(printf 'it'\\''s \"$odd\"\\n\\ttwo lines \\\\ \\001')\""); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.State().Env["OILS_READLINE_TEST"]; got != value {
+				t.Errorf("got %q, wanted %q", got, value)
+			}
+			if s.State().Env["PATH"] == "" {
+				t.Errorf("PATH is missing: %v", s.State().Env)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package execsh
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errUnterminated = errors.New("unterminated quote")
+
+// parseExports reads what `export -p` printed, which POSIX only promises to
+// be valid input for the same shell: `export NAME='value'` in dash,
+// `declare -x NAME="value"` in bash, with $'...' for control characters.
+// Variables which are exported but unset have no value and are left out.
+func parseExports(out string) (map[string]string, error) {
+	env := map[string]string{}
+	for len(out) > 0 {
+		words, rest, err := splitCommand(out)
+		if err != nil {
+			return nil, err
+		}
+		out = rest
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "export", "declare", "typeset":
+		default:
+			continue
+		}
+		args := words[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			// bash marks arrays as exported, but they aren't
+			if strings.ContainsAny(args[0], "aA") {
+				args = nil
+			} else {
+				args = args[1:]
+			}
+		}
+		for _, arg := range args {
+			if name, value, ok := strings.Cut(arg, "="); ok {
+				env[name] = value
+			}
+		}
+	}
+	return env, nil
+}
+
+// splitCommand splits the first command of s into words and unquotes them.
+func splitCommand(s string) (words []string, rest string, err error) {
+	var word strings.Builder
+	inWord := false
+	end := func() {
+		if inWord {
+			words = append(words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n' || c == ';':
+			end()
+			return words, s[i+1:], nil
+		case c == ' ' || c == '\t':
+			end()
+		case c == '\\':
+			inWord = true
+			if i+1 < len(s) && s[i+1] != '\n' {
+				word.WriteByte(s[i+1])
+			}
+			i++
+		case c == '\'':
+			inWord = true
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				return nil, "", errUnterminated
+			}
+			word.WriteString(s[i+1 : i+1+j])
+			i += j + 1
+		case c == '"':
+			inWord = true
+			n, err := unquoteDouble(s[i+1:], &word)
+			if err != nil {
+				return nil, "", err
+			}
+			i += n + 1
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			inWord = true
+			n, err := unquoteANSIC(s[i+2:], &word)
+			if err != nil {
+				return nil, "", err
+			}
+			i += n + 2
+		default:
+			inWord = true
+			word.WriteByte(c)
+		}
+	}
+	end()
+	return words, "", nil
+}
+
+// unquoteDouble writes the inside of "..." to w, s starts after the opening
+// quote. It returns the length up to the closing one.
+func unquoteDouble(s string, w *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return i, nil
+		case '\\':
+			if i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+				if s[i+1] != '\n' {
+					w.WriteByte(s[i+1])
+				}
+				i++
+				continue
+			}
+		}
+		w.WriteByte(s[i])
+	}
+	return 0, errUnterminated
+}
+
+// unquoteANSIC writes the inside of $'...' to w, s starts after the opening
+// quote. It returns the length up to the closing one.
+func unquoteANSIC(s string, w *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			return i, nil
+		}
+		if c != '\\' || i+1 >= len(s) {
+			w.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case 'a':
+			w.WriteByte('\a')
+		case 'b':
+			w.WriteByte('\b')
+		case 'e', 'E':
+			w.WriteByte(0x1b)
+		case 'f':
+			w.WriteByte('\f')
+		case 'n':
+			w.WriteByte('\n')
+		case 'r':
+			w.WriteByte('\r')
+		case 't':
+			w.WriteByte('\t')
+		case 'v':
+			w.WriteByte('\v')
+		case 'c':
+			if i+1 < len(s) {
+				i++
+				w.WriteByte(s[i] & 0x1f)
+			}
+		case 'x', 'u', 'U', '0', '1', '2', '3', '4', '5', '6', '7':
+			base, digits, start := 16, map[byte]int{'x': 2, 'u': 4, 'U': 8}[c], i+1
+			if digits == 0 {
+				base, digits, start = 8, 3, i
+			}
+			end := start
+			for end < len(s) && end-start < digits && isDigit(s[end], base) {
+				end++
+			}
+			n, err := strconv.ParseUint(s[start:end], base, 32)
+			if err != nil {
+				// Not an escape after all
+				w.WriteByte('\\')
+				w.WriteByte(c)
+				continue
+			}
+			if c == 'u' || c == 'U' {
+				w.WriteRune(rune(n))
+			} else {
+				w.WriteByte(byte(n))
+			}
+			i = end - 1
+		case '\\', '\'', '"', '?':
+			w.WriteByte(c)
+		default:
+			w.WriteByte('\\')
+			w.WriteByte(c)
+		}
+	}
+	return 0, errUnterminated
+}
+
+func isDigit(c byte, base int) bool {
+	if base == 8 {
+		return '0' <= c && c <= '7'
+	}
+	return strings.IndexByte("0123456789abcdefABCDEF", c) >= 0
+}
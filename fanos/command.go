@@ -2,6 +2,7 @@ package fanos
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"strings"
@@ -19,13 +20,19 @@ type Command struct {
 	shell                 *Shell
 	commandline           string
 	err                   error
+	exitCode              int
 	ctx                   context.Context
 	Cancel                context.CancelFunc
 	stdin, stdout, stderr *os.File
 	stdoutBuf, stderrBuf  *strings.Builder
 	stdoutMu, stderrMu    sync.Mutex
 	onStdout, onStderr    func()
+	// Where else the output goes, see Subscribe
+	stdoutSubs, stderrSubs shell.Subscribers
+	// Read instead of the tty, see SetInput
+	input io.Reader
 	// For the Client
+	ptmx     *os.File
 	tty      *os.File
 	stderrIn *os.File
 	wg       *sync.WaitGroup
@@ -61,6 +68,40 @@ func (c *Command) SetStdin(stdin io.Writer) {
 	c.stdin = stdin.(*os.File) // this will panic if it's not an *os.File, maybe add error handling or make c.stdin an io.Writer instead?
 }
 
+// SetInput makes the command read r on stdin instead of the terminal.
+// r is closed once the command stops reading, if it's an io.Closer.
+func (c *Command) SetInput(r io.Reader) {
+	c.input = r
+}
+
+// stdinFile returns what the command reads: the tty, or a pipe fed from input.
+func (c *Command) stdinFile() (*os.File, error) {
+	if c.input == nil {
+		return c.tty, nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	// Ends with EPIPE if the command doesn't read everything
+	go func() {
+		io.Copy(w, c.input)
+		w.Close()
+		if closer, ok := c.input.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+	return r, nil
+}
+
+// Subscribe writes the output of stream to w as well, while the command runs.
+func (c *Command) Subscribe(stream shell.Stream, w io.Writer) (stop func() error) {
+	if stream == shell.Stderr {
+		return c.stderrSubs.Subscribe(w)
+	}
+	return c.stdoutSubs.Subscribe(w)
+}
+
 func (c *Command) SetOnStdout(fn func()) {
 	c.onStdout = fn
 }
@@ -75,6 +116,7 @@ func (sh *Shell) Command(commandLine string, size *pty.Winsize) (shell.Command,
 	// check errors
 	c.SetState(shell.Ready)
 	c.commandline = commandLine
+	c.exitCode = -1
 	c.shell = sh
 	c.stdoutBuf = new(strings.Builder)
 	c.stderrBuf = new(strings.Builder)
@@ -89,6 +131,7 @@ func (sh *Shell) Command(commandLine string, size *pty.Winsize) (shell.Command,
 		return nil, err
 	}
 
+	c.ptmx = ptmx
 	c.tty = tty
 	pty.Setsize(ptmx, size)
 
@@ -98,6 +141,7 @@ func (sh *Shell) Command(commandLine string, size *pty.Winsize) (shell.Command,
 	// Read from stdout/stderr into our buffer
 	// TODO: the stdoutBuf.Write might require a lock?!
 	c.wg.Go(func() {
+		defer c.stdoutSubs.Close()
 		buf := make([]byte, 1024*1024) // large buffer
 		for {
 			count, err := c.stdout.Read(buf)
@@ -109,6 +153,7 @@ func (sh *Shell) Command(commandLine string, size *pty.Winsize) (shell.Command,
 			c.stdoutMu.Lock()
 			c.stdoutBuf.Write(buf[:count])
 			c.stdoutMu.Unlock()
+			c.stdoutSubs.Write(buf[:count])
 			if c.onStdout != nil {
 				c.onStdout()
 			}
@@ -120,6 +165,7 @@ func (sh *Shell) Command(commandLine string, size *pty.Winsize) (shell.Command,
 		return nil, err
 	}
 	c.wg.Go(func() {
+		defer c.stderrSubs.Close()
 		buf := make([]byte, 100) // large buffer
 		for {
 			count, err := c.stderr.Read(buf)
@@ -131,6 +177,7 @@ func (sh *Shell) Command(commandLine string, size *pty.Winsize) (shell.Command,
 			c.stderrMu.Lock()
 			c.stderrBuf.Write(buf[:count])
 			c.stderrMu.Unlock()
+			c.stderrSubs.Write(buf[:count])
 			if c.onStderr != nil {
 				c.onStderr()
 			}
@@ -144,15 +191,39 @@ func (c *Command) Wait() {
 	c.wg.Wait()
 }
 
+// Run waits for the shell to be free and runs the command.
+// The state only becomes Queued if another EVAL is in flight.
+// Calling Cancel while the command is still queued drops it,
+// afterwards it interrupts the running command.
 func (c *Command) Run() {
-	c.SetState(shell.Started)
-	err := c.shell.Run(c.commandline, c.tty, c.tty, c.stderrIn)
-
-	c.wg.Done()
+	stop := func() bool { return false }
+	stdin, err := c.stdinFile()
 	if err != nil {
-		c.err = err
+		// Like run, so the output ends
+		c.tty.Close()
+		c.stderrIn.Close()
+	} else {
+		err = c.shell.run(c.ctx, c.commandline, stdin, c.tty, c.stderrIn,
+			func() { c.SetState(shell.Queued) },
+			func() {
+				c.SetState(shell.Started)
+				stop = context.AfterFunc(c.ctx, func() { c.Interrupt() })
+			},
+		)
+	}
+	stop()
+
+	c.err = err
+	var exitErr *shell.ExitError
+	switch {
+	case err == nil:
+		c.exitCode = 0
+	case errors.As(err, &exitErr):
+		c.exitCode = exitErr.Status
 	}
+	// Publishing the state makes err and exitCode visible to other goroutines
 	c.SetState(shell.Stopped)
+	c.wg.Done()
 }
 
 func (c *Command) State() shell.CommandState {
@@ -167,6 +238,16 @@ func (c *Command) Resize(size *pty.Winsize) error {
 	return pty.Setsize(c.stdin, size)
 }
 
-func (c *Command) Error() string {
-	return "Capturing Exit code is not yet implemented"
+func (c *Command) ExitCode() int {
+	if c.State() != shell.Stopped {
+		return -1
+	}
+	return c.exitCode
+}
+
+func (c *Command) Err() error {
+	if c.State() != shell.Stopped {
+		return nil
+	}
+	return c.err
 }
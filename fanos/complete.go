@@ -0,0 +1,105 @@
+package fanos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// The line to complete comes on stdin, so it doesn't need any quoting
+const completeScript = `compexport -c "$(cat)"`
+
+// Complete asks oils what it would complete at the cursor, like on tab in an
+// interactive osh: the specs registered with `complete`, commands, builtins,
+// procs, variables and files. It waits for a running command.
+func (s *Shell) Complete(req shell.CompletionReq) (shell.CompletionResult, error) {
+	if req.Pos < 0 || req.Pos > len(req.Text) {
+		return shell.CompletionResult{}, fmt.Errorf("completion at %d of %d bytes", req.Pos, len(req.Text))
+	}
+	// compexport completes a single line, up to the cursor
+	lineStart := strings.LastIndexByte(req.Text[:req.Pos], '\n') + 1
+	line := req.Text[lineStart:req.Pos]
+
+	if err := s.queue.acquire(s.ctx, nil); err != nil {
+		return shell.CompletionResult{}, err
+	}
+	defer s.queue.release()
+	out, err := s.query(completeScript, line)
+	if errors.Is(err, ErrCrashed) {
+		s.restart(err)
+	}
+	if err != nil {
+		return shell.CompletionResult{}, err
+	}
+	result := parseCompletions(out, line, s.Dir())
+	result.From += lineStart
+	result.To = req.Pos
+	return result, nil
+}
+
+// parseCompletions reads what compexport printed: a JSON string per match with
+// the whole line completed, e.g. "echo $HOME " for "echo $HO". The options
+// are what differs from line, starting at the word which was completed.
+func parseCompletions(out, line, dir string) shell.CompletionResult {
+	var matches []string
+	for _, l := range strings.Split(out, "\n") {
+		if l == "" {
+			continue
+		}
+		var m string
+		// Not UTF-8 comes as b'...', we can't insert that anyway
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			log.Printf("Ignoring completion %s: %v", l, err)
+			continue
+		}
+		matches = append(matches, m)
+	}
+
+	from := len(line)
+	for _, m := range matches {
+		common := 0
+		for common < len(line) && common < len(m) && line[common] == m[common] {
+			common++
+		}
+		from = min(from, shell.WordStart(line, common))
+	}
+	result := shell.CompletionResult{From: from, To: len(line)}
+	for _, m := range matches {
+		c := shell.Completion{Label: strings.TrimRight(m[from:], " "), Apply: m[from:]}
+		if c.Apply == c.Label {
+			c.Apply = ""
+		}
+		c.Type = completionType(line[:from], c.Label, dir)
+		result.Options = append(result.Options, c)
+	}
+	return result
+}
+
+// completionType guesses what a completion is, compexport doesn't say.
+func completionType(before, label, dir string) string {
+	before = strings.TrimRight(before, " \t")
+	switch {
+	case strings.HasSuffix(label, "/"):
+		return "dir"
+	case strings.HasPrefix(label, "$"):
+		return "variable"
+	case strings.HasPrefix(label, "-"):
+		return "option"
+	case before == "" || strings.ContainsAny(before[len(before)-1:], "|&;("):
+		return "command"
+	}
+	path := strings.Trim(label, `'"`)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "file"
+	}
+	return ""
+}
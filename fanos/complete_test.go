@@ -0,0 +1,93 @@
+package fanos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Melkor333/oils-readline/fanos/fanostest"
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+func TestParseCompletions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "my file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		line, out string
+		from      int
+		want      []shell.Completion
+	}{
+		{"ech", `"echo "` + "\n" + `"echo-test "`, 0, []shell.Completion{
+			{Label: "echo", Apply: "echo ", Type: "command"},
+			{Label: "echo-test", Apply: "echo-test ", Type: "command"},
+		}},
+		{"ls | echo $HO", `"ls | echo $HOME "` + "\n", 10, []shell.Completion{
+			{Label: "$HOME", Apply: "$HOME ", Type: "variable"},
+		}},
+		{"cat sr", `"cat src/"`, 4, []shell.Completion{{Label: "src/", Type: "dir"}}},
+		{"cat my\\ f", `"cat my\\ file "`, 4, []shell.Completion{
+			{Label: `my\ file`, Apply: `my\ file `},
+		}},
+		{"cat 'my f", `"cat 'my file' "`, 4, []shell.Completion{
+			{Label: "'my file'", Apply: "'my file' ", Type: "file"},
+		}},
+		{"git --v", `"git --version "` + "\n" + `b'\yff'`, 4, []shell.Completion{
+			{Label: "--version", Apply: "--version ", Type: "option"},
+		}},
+		{"cat x", "", 5, nil},
+	} {
+		got := parseCompletions(tt.out, tt.line, dir)
+		if got.From != tt.from || got.To != len(tt.line) {
+			t.Errorf("%q: got %d to %d", tt.line, got.From, got.To)
+		}
+		if fmt.Sprint(got.Options) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: got %+v, wanted %+v", tt.line, got.Options, tt.want)
+		}
+	}
+}
+
+func TestShell_Complete(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("needs the fanostest server")
+	}
+	srv := fanostest.NewServer()
+	t.Cleanup(srv.Close)
+	var lines []string
+	// Like oils, it completes the line it reads
+	srv.Builtins["compexport"] = func(c *fanostest.Call) (int, error) {
+		line, err := io.ReadAll(c.Stdin)
+		if err != nil {
+			return 1, err
+		}
+		lines = append(lines, string(line))
+		completed, _ := json.Marshal(string(line) + "lo ")
+		_, err = fmt.Fprintf(c.Stdout, "%s\n", completed)
+		return 0, err
+	}
+	s, err := New(WithDialer(srv.Dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Cancel)
+
+	text := "echo a\nech  # more"
+	got, err := s.Complete(shell.CompletionReq{Text: text, Pos: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0] != "ech" {
+		t.Errorf("oils should get the line up to the cursor, got %q", lines)
+	}
+	if got.From != 7 || got.To != 10 || len(got.Options) != 1 || got.Options[0].Text() != "echlo " {
+		t.Errorf("got %+v", got)
+	}
+
+	if _, err := s.Complete(shell.CompletionReq{Text: text, Pos: 30}); err == nil {
+		t.Error("completing past the end should fail")
+	}
+}
@@ -0,0 +1,140 @@
+package fanos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+var ErrNoInterpreter = errors.New("no oils interpreter found")
+
+// interpreter returns the oils to start and the arguments that make it
+// name (osh or ysh), or ysh if name is empty.
+// That's -oil_path if given, else the embedded binary, else name from $PATH.
+// Without a name osh is fine too.
+func interpreter(name string) (path string, args []string, err error) {
+	if path := *fanosShellPath; path != "" {
+		switch base := filepath.Base(path); {
+		case name == "" || base == name:
+			return path, nil, nil
+		case base == "oils-for-unix":
+			return path, []string{name}, nil
+		}
+	}
+	if len(embeddedOils) > 0 {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", nil, err
+		}
+		path, err := extract(embeddedOils, filepath.Join(cache, "oils-readline"))
+		if err != nil {
+			return "", nil, fmt.Errorf("can't extract embedded oils: %w", err)
+		}
+		// It's the multi-call binary, the first argument picks the shell
+		if name == "" {
+			name = "ysh"
+		}
+		return path, []string{name}, nil
+	}
+	names := []string{"ysh", "osh"}
+	if name != "" {
+		names = []string{name}
+	}
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil, nil
+		}
+	}
+	if name != "" {
+		return "", nil, fmt.Errorf("%w: %s", ErrNoInterpreter, name)
+	}
+	return "", nil, ErrNoInterpreter
+}
+
+// extract makes sure dir/<sha256 of data>/oils-for-unix contains data and
+// returns its path. The directories are private to the current user, the
+// binary is written to a temporary file and renamed into place, so
+// concurrent instances never see a partial file.
+// An existing binary is verified before it's returned.
+func extract(data []byte, dir string) (string, error) {
+	sum := sha256.Sum256(data)
+	binDir := filepath.Join(dir, hex.EncodeToString(sum[:]))
+	if err := os.MkdirAll(binDir, 0700); err != nil {
+		return "", err
+	}
+	for _, d := range []string{dir, binDir} {
+		if err := checkPrivate(d); err != nil {
+			return "", err
+		}
+	}
+
+	path := filepath.Join(binDir, "oils-for-unix")
+	if verify(path, sum) == nil {
+		return path, nil
+	}
+
+	tmp, err := os.CreateTemp(binDir, ".oils-for-unix-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0700)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, verify(path, sum)
+}
+
+// verify checks that the file at path has the given hash.
+func verify(path string, sum [sha256.Size]byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum[:]) {
+		return fmt.Errorf("%s: checksum mismatch", path)
+	}
+	return nil
+}
+
+// checkPrivate makes sure nobody but us can put a binary into dir.
+func checkPrivate(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s: not a directory", dir)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s: owned by uid %d", dir, st.Uid)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s: permissions %v are too open", dir, fi.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package fanos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	data := []byte("#!/bin/sh\necho oils\n")
+
+	path, err := extract(data, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if want := filepath.Join(dir, hex.EncodeToString(sum[:]), "oils-for-unix"); path != want {
+		t.Errorf("got path %q, wanted %q", path, want)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("extracted binary differs: %q", got)
+	}
+	for _, p := range []string{dir, filepath.Dir(path), path} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0700 {
+			t.Errorf("%s has permissions %v, wanted 0700", p, fi.Mode().Perm())
+		}
+	}
+
+	// Leftovers of a previous run (or somebody else) get replaced
+	if err := os.WriteFile(path, []byte("evil"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extract(data, dir); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+		t.Errorf("tampered binary wasn't replaced: %q", got)
+	}
+}
+
+func TestExtractConcurrent(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	data := bytes.Repeat([]byte("oils"), 1<<16)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			path, err := extract(data, dir)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+				t.Error("got a partial binary")
+			}
+		})
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("wanted a single binary directory, got %v", entries)
+	}
+}
+
+func TestExtractRefusesSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extract([]byte("oils"), dir); err == nil {
+		t.Error("extracting into a world writable directory should fail")
+	}
+}
+
+func TestInterpreterFromPath(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("-oil_path takes precedence")
+	}
+	embedded := embeddedOils
+	embeddedOils = nil
+	defer func() { embeddedOils = embedded }()
+
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	if _, _, err := interpreter(""); !errors.Is(err, ErrNoInterpreter) {
+		t.Errorf("got %v, wanted ErrNoInterpreter", err)
+	}
+
+	osh := filepath.Join(dir, "osh")
+	if err := os.WriteFile(osh, []byte("#!/bin/sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	path, args, err := interpreter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != osh || len(args) != 0 {
+		t.Errorf("got %q %q, wanted %q", path, args, osh)
+	}
+
+	ysh := filepath.Join(dir, "ysh")
+	if err := os.WriteFile(ysh, []byte("#!/bin/sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if path, _, _ := interpreter(""); path != ysh {
+		t.Errorf("ysh should be preferred, got %q", path)
+	}
+	if path, _, _ := interpreter("osh"); path != osh {
+		t.Errorf("asked for osh, got %q", path)
+	}
+	if _, _, err := interpreter("bash"); !errors.Is(err, ErrNoInterpreter) {
+		t.Errorf("got %v, wanted ErrNoInterpreter", err)
+	}
+}
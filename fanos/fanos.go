@@ -1,26 +1,23 @@
 package fanos
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
-	"path"
-	"slices"
-	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
-	"github.com/chalk-ai/bubbline/computil"
-	"github.com/chalk-ai/bubbline/editline"
-	"github.com/creack/pty"
+	"github.com/Melkor333/oils-readline/fanos/netstring"
+	"github.com/Melkor333/oils-readline/shell"
 )
 
 //go:generate ./static-oils.sh
@@ -31,230 +28,348 @@ var (
 	fanosShellPath = flag.String("oil_path", "", "Path to Oil shell interpreter")
 )
 
+var (
+	ErrProtocol = errors.New("fanos protocol error")
+	ErrEval     = errors.New("shell refused EVAL")
+	// ErrCrashed is returned for commands the shell died on (or the connection to it).
+	ErrCrashed = errors.New("shell crashed")
+)
+
 type Shell struct {
-	cmd    *exec.Cmd
+	ctx    context.Context
 	cancel context.CancelFunc
-	socket *os.File
+	// Only one EVAL may be in flight at a time.
+	// Whoever holds the turn may also restart the shell.
+	queue evalQueue
+	enc   *netstring.Encoder
+	dec   *netstring.Decoder
+
+	// mu guards the current connection/process and the supervisor state
+	mu         sync.Mutex
+	socket     *net.UnixConn
+	cmd        *exec.Cmd
+	stopProc   context.CancelFunc
+	exited     chan struct{}
+	generation int
+	restarts   []time.Time
+	state      shell.State
+	stateVars  []string
+	// interp is the requested interpreter, osh is what we got
+	interp    string
+	osh       bool
+	onRestart func(error)
+	diag      diagnostics
+
+	dial      func() (*net.UnixConn, error)
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// An Option configures a Shell created by New.
+type Option func(*Shell)
+
+// WithDialer connects to an already running FANOS server instead of
+// starting oils, e.g. fanostest.Server.Dial.
+func WithDialer(dial func() (*net.UnixConn, error)) Option {
+	return func(s *Shell) { s.dial = dial }
+}
+
+// WithInterpreter starts osh or ysh instead of the default.
+func WithInterpreter(name string) Option {
+	return func(s *Shell) { s.interp = name }
+}
+
+// Interpreter tells whether the shell is osh or ysh.
+func (s *Shell) Interpreter() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.osh {
+		return "osh"
+	}
+	return "ysh"
+}
 
-	in, out, err *os.File
+// WithDir starts the shell in dir instead of the current directory.
+func WithDir(dir string) Option {
+	return func(s *Shell) { s.state.Cwd = dir }
 }
 
 func (s *Shell) Cancel() {
-	s.cancel()
-	s.cmd.Cancel()
-	s.in.Close()
-	s.out.Close()
-	s.err.Close()
-	s.cmd.Wait()
+	s.closeOnce.Do(func() {
+		s.queue.close()
+		s.cancel()
+		s.mu.Lock()
+		socket, exited := s.socket, s.exited
+		s.mu.Unlock()
+		socket.Close()
+		// There's no process to wait for when we dialed
+		if exited != nil {
+			<-exited
+		}
+		close(s.done)
+	})
+	<-s.done
 }
 
+// Wait blocks until the shell is canceled or couldn't be restarted.
 func (s *Shell) Wait() {
-	s.cmd.Wait()
+	<-s.done
 	s.Cancel()
 }
 
-func New() (*Shell, error) {
-	shell := &Shell{}
-	var ctx context.Context
-	ctx, shell.cancel = context.WithCancel(context.Background())
-	// If we don't have a shell path, we use the 'builtin shell'
-	// TODO: We could test for `ysh` on the path and use that.
-	if *fanosShellPath == "" {
-		tempDir := os.TempDir()
-		filePath := path.Join(tempDir, "ysh")
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			// Write the embedded binary to a temporary file
-			if err := os.WriteFile(filePath, embeddedOils, 0700); err != nil {
-				return nil, fmt.Errorf("failed to write embedded binary: %w", err)
-			}
-			defer os.Remove(filePath)
-			// Set permissions to make it executable
-			syscall.Chmod(filePath, 0700)
+func New(opts ...Option) (*Shell, error) {
+	shell := &Shell{done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(shell)
+	}
+	shell.ctx, shell.cancel = context.WithCancel(context.Background())
+	if err := shell.start(); err != nil {
+		shell.cancel()
+		return nil, err
+	}
+	// So the first prompt already knows where it is
+	if _, err := shell.RefreshState(); err != nil {
+		log.Printf("Can't get the shell state: %v", err)
+	}
+	return shell, nil
+}
+
+// start connects to a fresh shell. A started oils inherits the working
+// directory and environment of the last known State.
+func (s *Shell) start() error {
+	if s.dial != nil {
+		conn, err := s.dial()
+		if err != nil {
+			return fmt.Errorf("can't connect to FANOS server: %w", err)
 		}
-		shell.cmd = exec.CommandContext(ctx, filePath, "--headless")
+		_, err = s.publish(conn, nil, nil, nil)
+		return err
+	}
 
-	} else {
-		shell.cmd = exec.CommandContext(ctx, *fanosShellPath, "--headless")
+	path, args, err := interpreter(s.interp)
+	if err != nil {
+		return err
 	}
-	// Make the shell a new process group
-	shell.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: 0}
+	s.osh = isOsh(path, args)
+	ctx, stop := context.WithCancel(s.ctx)
+	cmd := s.oilsCommand(ctx, path, args...)
 
 	// Using a socket for communication with the shell
 	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
 	if err != nil {
-		return nil, fmt.Errorf("can't create socketpair: %w", err)
+		stop()
+		return fmt.Errorf("can't create socketpair: %w", err)
 	}
-	shell.socket = os.NewFile(uintptr(fds[0]), "fanos_client")
+	client := os.NewFile(uintptr(fds[0]), "fanos_client")
+	conn, err := net.FileConn(client)
+	client.Close()
 	server := os.NewFile(uintptr(fds[1]), "fanos_server")
-	shell.cmd.Stdin = server
-	shell.cmd.Stdout = server
-
-	// TODO: We should catch it's stderr
-	shell.cmd.Stderr = io.Discard
-	//shell.cmd.Stderr = os.Stderr
+	// Only the shell may keep its end open, otherwise we'd never see EOF when it dies
+	defer server.Close()
+	if err != nil {
+		stop()
+		return fmt.Errorf("can't use socketpair: %w", err)
+	}
+	cmd.Stdin = server
+	cmd.Stdout = server
+	cmd.Stderr = &s.diag
 
-	o := shell.cmd.Start()
-	// TODO: Make sure this exit is *really* graceful
+	if err := cmd.Start(); err != nil {
+		stop()
+		conn.Close()
+		return err
+	}
+	exited := make(chan struct{})
+	generation, err := s.publish(conn.(*net.UnixConn), cmd, stop, exited)
 	go func() {
-		shell.cmd.Wait()
-		//os.Exit(0)
+		cmd.Wait()
+		stop()
+		close(exited)
+		if err == nil {
+			s.processExited(generation, cmd.ProcessState)
+		}
 	}()
-	return shell, o
+	return err
 }
 
-// Run calls the FANOS EVAL method
+// oilsCommand prepares a headless oils, where the last one left off.
+func (s *Shell) oilsCommand(ctx context.Context, path string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, path, append(args, "--headless")...)
+	// Make the shell a new process group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: 0}
+	s.mu.Lock()
+	cmd.Dir = s.state.Cwd
+	cmd.Env = environ(s.state.Env)
+	s.mu.Unlock()
+	return cmd
+}
+
+// publish makes a new connection current, unless the shell was canceled meanwhile.
+func (s *Shell) publish(conn *net.UnixConn, cmd *exec.Cmd, stop context.CancelFunc, exited chan struct{}) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		conn.Close()
+		if stop != nil {
+			stop()
+		}
+		return 0, ErrClosed
+	}
+	s.socket, s.cmd, s.stopProc, s.exited = conn, cmd, stop, exited
+	s.enc = netstring.NewEncoder(conn)
+	s.dec = netstring.NewDecoder(conn)
+	s.generation++
+	return s.generation, nil
+}
+
+// pgid returns the process group of the oils process, or 0 if we didn't start it.
+func (s *Shell) pgid() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil || s.cmd.Process == nil {
+		return 0
+	}
+	// It's the group leader, see Setpgid in oilsCommand
+	return s.cmd.Process.Pid
+}
+
+// Run calls the FANOS EVAL method and asks the shell for the resulting exit status.
+// A non-zero status is returned as *shell.ExitError.
+// Concurrent calls are queued and run one after another.
 func (s *Shell) Run(command string, stdin, stdout, stderr *os.File) error {
-	var err error
-	defer func() {
+	return s.run(context.Background(), command, stdin, stdout, stderr, nil, nil)
+}
+
+// run waits until no other EVAL is in flight and runs command.
+// onQueued is called if it has to wait, onStart right before the EVAL is sent.
+// A request that is still waiting when ctx is done is dropped.
+func (s *Shell) run(ctx context.Context, command string, stdin, stdout, stderr *os.File, onQueued, onStart func()) error {
+	if err := s.queue.acquire(ctx, onQueued); err != nil {
 		stdin.Close()
 		stdout.Close()
 		stderr.Close()
-	}()
-
-	// ------------------
-	// Send command and FDs via FANOS
-	// ------------------
-	rights := syscall.UnixRights(int(stdin.Fd()), int(stdout.Fd()), int(stderr.Fd()))
-	var buf bytes.Buffer
-	buf.WriteString("EVAL ")
-	buf.WriteString(command)
-
-	// Send command per Netstring
-	_, err = s.socket.Write([]byte(strconv.Itoa(buf.Len()) + ":"))
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	err = syscall.Sendmsg(int(s.socket.Fd()), buf.Bytes(), rights, nil, 0)
-	if err != nil {
-		log.Println(err)
 		return err
 	}
-	_, err = s.socket.Write([]byte(","))
-	if err != nil {
-		return err
+	defer s.queue.release()
+	if onStart != nil {
+		onStart()
 	}
 
-	// TODO: Actually read netstring instead of reading until ','
-	// Wait for FANOS Answer
-	sockReader := bufio.NewReader(s.socket)
-	_, err = sockReader.ReadString(',')
+	err := s.eval(command, stdin, stdout, stderr)
+	var status int
+	if err == nil {
+		state, stateErr := s.refreshState()
+		status = state.Status
+		// The command ran, a broken state query isn't its fault
+		if errors.Is(stateErr, ErrCrashed) {
+			err = stateErr
+		} else if stateErr != nil {
+			log.Printf("Can't get the shell state: %v", stateErr)
+		}
+	}
+	if errors.Is(err, ErrCrashed) {
+		s.restart(err)
+	}
 	if err != nil {
 		return err
 	}
+	if status != 0 {
+		return &shell.ExitError{Status: status}
+	}
 	return nil
 }
 
-// TODO: The required command should be "delivered" by the chosen shell
-func (s *Shell) Dir() string {
-	return ""
-}
-
-// TODO: This should be a plugin at some point
-func (s *Shell) Complete(input [][]rune, line, col int) (string, editline.Completions) {
-	// TODO: Only file completions for now
-	// TODO: escape?
-	word, start, end := computil.FindWord(input, line, col)
+// eval sends a single EVAL request and waits for the reply.
+// The passed files are closed once they were handed over to the shell.
+func (s *Shell) eval(command string, stdin, stdout, stderr *os.File) error {
+	var err error
+	defer func() {
+		stdin.Close()
+		stdout.Close()
+		stderr.Close()
+	}()
 
-	// All files
-	// TODO: A way to skip directories
-	stdout, stdoutIn, err := os.Pipe()
-	if err != nil {
-		log.Println(err)
-		return "", nil
-	}
-	_, stdin, err := os.Pipe()
+	// Send command and FDs via FANOS
+	request := []byte("EVAL " + command)
+	err = s.enc.Encode(request, int(stdin.Fd()), int(stdout.Fd()), int(stderr.Fd()))
 	if err != nil {
 		log.Println(err)
-		return "", nil
+		return fmt.Errorf("%w: %w", ErrCrashed, err)
 	}
-	stderr, stderrIn, err := os.Pipe()
 
-	if err != nil {
-		log.Println(err)
-		return "", nil
+	// Wait for FANOS Answer
+	reply, fds, err := s.dec.Decode()
+	// Replies never carry descriptors, don't leak them if they do
+	for _, fd := range fds {
+		syscall.Close(fd)
 	}
-	buf := new(strings.Builder)
-	var files []string
-	errbu := new(strings.Builder)
-	err = s.Run("compgen -f '"+word+"'", stdin, stdoutIn, stderrIn)
 	if err != nil {
-		log.Println(err)
-		files = []string{}
-	} else {
-		io.Copy(errbu, stderr)
-		io.Copy(buf, stdout)
-		if len(errbu.String()) > 0 {
-			files = []string{}
-
-		} else {
-			files = strings.Split(strings.TrimSpace(buf.String()), "\n")
-		}
+		return fmt.Errorf("%w: %w", ErrCrashed, err)
 	}
+	_, err = parseReply(reply)
+	return err
+}
 
-	// dirs
-	stdout, stdoutIn, err = os.Pipe()
+// query runs a script without a terminal and returns what it printed. It reads
+// input on stdin. Anything written to stderr is treated as an error, what was
+// printed until then is still returned.
+func (s *Shell) query(script, input string) (string, error) {
+	stdin, stdinIn, err := os.Pipe()
 	if err != nil {
-		log.Println(err)
-		return "", nil
+		return "", err
 	}
-	_, stdin, err = os.Pipe()
+	go func() {
+		stdinIn.WriteString(input)
+		stdinIn.Close()
+	}()
+	stdout, stdoutIn, err := os.Pipe()
 	if err != nil {
-		log.Println(err)
-		return "", nil
+		stdin.Close()
+		return "", err
 	}
-	stderr, stderrIn, err = os.Pipe()
-
+	defer stdout.Close()
+	stderr, stderrIn, err := os.Pipe()
 	if err != nil {
-		log.Println(err)
-		return "", nil
+		stdin.Close()
+		stdoutIn.Close()
+		return "", err
 	}
+	defer stderr.Close()
 
-	var dirs []string
-	buf = new(strings.Builder)
-	errbu = new(strings.Builder)
-	err = s.Run("compgen -d '"+word+"'", stdin, stdoutIn, stderrIn)
+	var out, errOut strings.Builder
+	var wg sync.WaitGroup
+	wg.Go(func() { io.Copy(&out, stdout) })
+	wg.Go(func() { io.Copy(&errOut, stderr) })
+	err = s.eval(script, stdin, stdoutIn, stderrIn)
+	wg.Wait()
 	if err != nil {
-		log.Println(err)
-		dirs = []string{}
-	} else {
-		io.Copy(errbu, stderr)
-		io.Copy(buf, stdout)
-		if len(errbu.String()) > 0 {
-			dirs = []string{}
-		} else {
-			dirs = strings.Split(strings.TrimSpace(buf.String()), "\n")
-		}
+		return "", err
 	}
-	for i, d := range dirs {
-		// remove dirs from files
-		if len(files) > 0 {
-			if i := slices.Index(files, d); i >= 0 {
-				files[i] = files[len(files)-1]
-				files = files[:len(files)-1]
-			}
-		}
-		dirs[i] = d + "/"
+	if errOut.Len() > 0 {
+		return out.String(), fmt.Errorf("%w: %q failed: %s", ErrEval, script, strings.TrimSpace(errOut.String()))
 	}
-	if len(files) > 0 {
-		dirs = append(dirs, files...)
+	return out.String(), nil
+}
+
+// parseReply interprets the answer to an EVAL.
+// Oils answers "OK" (optionally followed by a payload) or "ERROR <message>".
+func parseReply(reply []byte) (string, error) {
+	verb, rest, _ := strings.Cut(string(reply), " ")
+	switch verb {
+	case "OK":
+		return rest, nil
+	case "ERROR":
+		return "", fmt.Errorf("%w: %s", ErrEval, rest)
 	}
-	// Sort case insensitive
-	sort.Slice(dirs, func(i, j int) bool { return strings.ToLower(dirs[i]) < strings.ToLower(dirs[j]) })
+	return "", fmt.Errorf("%w: unexpected reply %q", ErrProtocol, reply)
+}
 
-	return "", editline.SimpleWordsCompletion(dirs, "file", col, start, end)
+// Dir returns the working directory after the last command.
+func (s *Shell) Dir() string {
+	return s.State().Cwd
 }
 
 // TODO: Make this a somehow composable plugin?
-func (shell *Shell) GetPrompt() string {
-	log.Print("Getting prompt")
-	command, err := shell.Command("pwd | sed \"s|$[ENV.HOME]|~|\"", &pty.Winsize{1, 100, 5, 5})
-	if err != nil {
-		return ""
-	}
-	command.Run() // we don't care about the message
-	command.Wait()
-	log.Print("Got prompt")
-
-	return strings.ReplaceAll(command.Stdout(), "\n", "") + " $ "
+func (s *Shell) GetPrompt() string {
+	return shell.DefaultPrompt(s.State())
 }
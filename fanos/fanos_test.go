@@ -1,11 +1,19 @@
 package fanos
 
 import (
+	"context"
 	_ "embed"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+
+	"github.com/Melkor333/oils-readline/fanos/fanostest"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/creack/pty"
 )
 
 type TestArgs struct {
@@ -53,23 +61,37 @@ var (
 			"", "",
 			false,
 		},
-		// requires shell returning last code
-		//{
-		//	"Error",
-		//	TestArgs{"return 2", ""},
-		//	"", "",
-		//	true,
-		//},
+		{
+			"Error",
+			TestArgs{"return 2", ""},
+			"", "",
+			true,
+		},
 	}
 )
 
+// newTestShell connects to a fanostest server, or starts a real oils when
+// the tests run with -oil_path.
+func newTestShell(t *testing.T) *Shell {
+	t.Helper()
+	var opts []Option
+	if *fanosShellPath == "" {
+		srv := fanostest.NewServer()
+		t.Cleanup(srv.Close)
+		opts = append(opts, WithDialer(srv.Dial))
+	}
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Cancel)
+	return s
+}
+
 func TestShell_Run(t *testing.T) {
 	for _, tt := range command_tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s, err := New()
-			if err != nil {
-				t.Fatal(err)
-			}
+			s := newTestShell(t)
 			stdinReader, stdinWriter, err := os.Pipe()
 			if err != nil {
 				t.Fatal(err)
@@ -108,3 +130,206 @@ func TestShell_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestParseReply(t *testing.T) {
+	if _, err := parseReply([]byte("OK")); err != nil {
+		t.Errorf("OK should succeed, got %v", err)
+	}
+	if _, err := parseReply([]byte("OK ")); err != nil {
+		t.Errorf("OK with empty payload should succeed, got %v", err)
+	}
+	if _, err := parseReply([]byte("ERROR no such command")); !errors.Is(err, ErrEval) {
+		t.Errorf("ERROR should return ErrEval, got %v", err)
+	}
+	if _, err := parseReply([]byte("WAT")); !errors.Is(err, ErrProtocol) {
+		t.Errorf("unknown replies should return ErrProtocol, got %v", err)
+	}
+}
+
+func TestShell_RunConcurrent(t *testing.T) {
+	s := newTestShell(t)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			stdin, err := os.Open(os.DevNull)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			stdoutReader, stdoutWriter, err := os.Pipe()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer stdoutReader.Close()
+			_, stderrWriter, err := os.Pipe()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			var stdout strings.Builder
+			var copied sync.WaitGroup
+			copied.Go(func() { io.Copy(&stdout, stdoutReader) })
+			err = s.Run(fmt.Sprintf("write %d", i), stdin, stdoutWriter, stderrWriter)
+			copied.Wait()
+			if err != nil {
+				t.Errorf("command %d failed: %v", i, err)
+			}
+			if want := fmt.Sprintf("%d\n", i); stdout.String() != want {
+				t.Errorf("got %q, wanted %q", stdout.String(), want)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestCommand_ExitCode(t *testing.T) {
+	s := newTestShell(t)
+
+	c, err := s.Command("write hi\nreturn 3", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ExitCode() != -1 {
+		t.Errorf("exit code before running should be -1, got %d", c.ExitCode())
+	}
+	c.Run()
+	if c.State() != shell.Stopped {
+		t.Errorf("got state %v, wanted Stopped", c.State())
+	}
+	if c.ExitCode() != 3 {
+		t.Errorf("got exit code %d, wanted 3", c.ExitCode())
+	}
+	var exitErr *shell.ExitError
+	if !errors.As(c.Err(), &exitErr) {
+		t.Errorf("got error %v, wanted *shell.ExitError", c.Err())
+	}
+}
+
+func TestCommand_CancelQueued(t *testing.T) {
+	s := newTestShell(t)
+
+	slow, err := s.Command("sleep 200ms", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := s.Command("write never", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(slow.Run)
+	waitFor(t, func() bool { return slow.State() == shell.Started })
+	if queued.State() != shell.Ready {
+		t.Errorf("command shouldn't be queued before it runs, got state %v", queued.State())
+	}
+	wg.Go(queued.Run)
+	waitFor(t, func() bool { return queued.State() == shell.Queued })
+
+	queued.(*Command).Cancel()
+	wg.Wait()
+	if !errors.Is(queued.Err(), context.Canceled) {
+		t.Errorf("got error %v, wanted context.Canceled", queued.Err())
+	}
+	if slow.ExitCode() != 0 {
+		t.Errorf("slow command should still succeed, got %d", slow.ExitCode())
+	}
+}
+
+func TestShell_ProtocolError(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("needs the fanostest server")
+	}
+	s := newTestShell(t)
+
+	c, err := s.Command("error no thanks", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Run()
+	if !errors.Is(c.Err(), ErrEval) {
+		t.Errorf("got error %v, wanted ErrEval", c.Err())
+	}
+	if c.ExitCode() != -1 {
+		t.Errorf("got exit code %d, wanted -1", c.ExitCode())
+	}
+}
+
+func TestCommand_Signal(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("needs the fanostest server")
+	}
+	tests := []struct {
+		name   string
+		signal func(c *Command) error
+		want   int
+	}{
+		{"Interrupt", (*Command).Interrupt, 130},
+		{"Kill", (*Command).Kill, 137},
+		{"Cancel", func(c *Command) error { c.Cancel(); return nil }, 130},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestShell(t)
+			sc, err := s.Command("spawn sleep 10", &pty.Winsize{Rows: 10, Cols: 80})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := sc.(*Command)
+			go c.Run()
+			waitFor(t, func() bool {
+				_, err := c.foreground()
+				return err == nil
+			})
+
+			if err := tt.signal(c); err != nil {
+				t.Fatal(err)
+			}
+			c.Wait()
+			if c.ExitCode() != tt.want {
+				t.Errorf("got exit code %d, wanted %d", c.ExitCode(), tt.want)
+			}
+			if err := c.Interrupt(); !errors.Is(err, shell.ErrNotRunning) {
+				t.Errorf("got %v, wanted ErrNotRunning", err)
+			}
+		})
+	}
+}
+
+func TestCommand_SetInput(t *testing.T) {
+	s := newTestShell(t)
+
+	c, err := s.Command("cat", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.(shell.Redirectable).SetInput(strings.NewReader("earlier\noutput\n"))
+	c.Run()
+	c.Wait()
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ReplaceAll(c.Stdout(), "\r", ""); got != "earlier\noutput\n" {
+		t.Errorf("got stdout %q", got)
+	}
+}
+
+func TestCommand_Subscribe(t *testing.T) {
+	s := newTestShell(t)
+
+	c, err := s.Command("write out\nwrite err >&2", &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	c.(shell.Teeable).Subscribe(shell.Stdout, &stdout)
+	c.(shell.Teeable).Subscribe(shell.Stderr, &stderr)
+	c.Run()
+	c.Wait()
+	if stdout.String() != c.Stdout() || stderr.String() != "err\n" {
+		t.Errorf("got %q and %q", stdout.String(), stderr.String())
+	}
+}
@@ -0,0 +1,409 @@
+// Package fanostest provides a fake headless shell speaking the FANOS
+// protocol, so clients can be tested without an oils binary.
+//
+// Every line of an EVAL is a tiny command. The default builtins are
+//
+//	write|echo ARGS...   print ARGS, or to stderr with a trailing >&2
+//	cat                  copy stdin to stdout
+//	sleep DURATION       wait, e.g. "sleep 100ms" or "sleep 2"
+//	return|exit N        stop the script with status N
+//	true, false          status 0 and 1
+//	error MESSAGE        reply "ERROR MESSAGE" instead of "OK"
+//	crash                drop the connection without replying
+//	spawn PROGRAM ARGS   run a real program in the foreground of the terminal on stdin
+//	cd DIR, pwd          change and print the (pretend) working directory
+//	export NAME=VALUE    set a variable in the (pretend) environment
+//	env [-0]             print the environment, NUL separated with -0
+//
+// $? expands to the status of the previous command, also across EVALs.
+// Lines starting with # are comments. A script starting with StateMarker
+// is answered like the state query of the fanos package, or like a broken
+// one if StateError is set.
+// Unknown commands print an error and return 127, like a shell would.
+package fanostest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Melkor333/oils-readline/fanos/netstring"
+)
+
+var (
+	// ErrCrash makes the server drop the connection without a reply.
+	ErrCrash = errors.New("fanostest: crash")
+	// errStop ends a script early, keeping the status.
+	errStop = errors.New("fanostest: stop")
+)
+
+// StateMarker is the first line of the fanos state query.
+const StateMarker = "# oils-readline: state"
+
+// A ReplyError makes the server answer "ERROR <message>".
+type ReplyError struct {
+	Message string
+}
+
+func (e *ReplyError) Error() string { return e.Message }
+
+// Call is what a builtin gets to work with.
+type Call struct {
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Session is the per connection state, e.g. the last status.
+	Session *Session
+}
+
+// A Builtin runs a single command and returns its status.
+// Returning a *ReplyError or ErrCrash simulates protocol errors.
+type Builtin func(c *Call) (int, error)
+
+// Session is the state of one connection.
+type Session struct {
+	Status int
+	Dir    string
+	Env    []string
+}
+
+type Server struct {
+	// Builtins can be extended or replaced before the first Dial.
+	Builtins map[string]Builtin
+	// Dir and Env are what new sessions start with.
+	Dir string
+	Env []string
+	// StateError makes state queries print only the status, and this on
+	// stderr. Set it before the first Dial.
+	StateError string
+
+	mu       sync.Mutex
+	conns    []*net.UnixConn
+	requests []string
+	wg       sync.WaitGroup
+}
+
+func NewServer() *Server {
+	s := &Server{Builtins: map[string]Builtin{
+		"write":  write,
+		"echo":   write,
+		"cat":    cat,
+		"sleep":  sleep,
+		"return": exit,
+		"exit":   exit,
+		"true":   func(*Call) (int, error) { return 0, nil },
+		"false":  func(*Call) (int, error) { return 1, nil },
+		"error": func(c *Call) (int, error) {
+			return 0, &ReplyError{Message: strings.Join(c.Args[1:], " ")}
+		},
+		"crash":  func(*Call) (int, error) { return 0, ErrCrash },
+		"spawn":  spawn,
+		"cd":     cd,
+		"pwd":    pwd,
+		"export": export,
+		"env":    env,
+	}, Dir: "/"}
+	return s
+}
+
+// Dial returns the client end of a new connection to the server.
+// It can be passed to fanos.WithDialer.
+func (s *Server) Dial() (*net.UnixConn, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	var conns [2]*net.UnixConn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "fanostest")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, conns[1])
+	s.mu.Unlock()
+	s.wg.Go(func() { s.serve(conns[1]) })
+	return conns[0], nil
+}
+
+// Requests returns the scripts of all EVALs received so far, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Close drops all connections and waits for running requests.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve(conn *net.UnixConn) {
+	defer conn.Close()
+	dec := netstring.NewDecoder(conn)
+	enc := netstring.NewEncoder(conn)
+	session := &Session{Dir: s.Dir, Env: append([]string(nil), s.Env...)}
+	for {
+		payload, fds, err := dec.Decode()
+		files := make([]*os.File, len(fds))
+		for i, fd := range fds {
+			files[i] = os.NewFile(uintptr(fd), "fanostest-fd")
+		}
+		if err != nil {
+			closeAll(files)
+			return
+		}
+		reply, err := s.handle(session, string(payload), files)
+		closeAll(files)
+		if errors.Is(err, ErrCrash) {
+			return
+		}
+		if err := enc.Encode([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func (s *Server) handle(session *Session, request string, files []*os.File) (string, error) {
+	method, script, _ := strings.Cut(request, " ")
+	if method != "EVAL" {
+		return "ERROR unknown method " + method, nil
+	}
+	if len(files) != 3 {
+		return fmt.Sprintf("ERROR expected 3 fds, got %d", len(files)), nil
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, script)
+	s.mu.Unlock()
+
+	stdin, stdout, stderr := files[0], files[1], files[2]
+	if strings.HasPrefix(script, StateMarker) {
+		if s.StateError != "" {
+			fmt.Fprintf(stdout, "%d\n", session.Status)
+			fmt.Fprintln(stderr, s.StateError)
+			return "OK", nil
+		}
+		if err := writeState(session, stdout); err != nil {
+			return "ERROR " + err.Error(), nil
+		}
+		return "OK", nil
+	}
+	for line := range strings.Lines(script) {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		args := splitWords(strings.ReplaceAll(line, "$?", strconv.Itoa(session.Status)))
+		if len(args) == 0 {
+			continue
+		}
+		c := &Call{Args: args, Stdin: stdin, Stdout: stdout, Stderr: stderr, Session: session}
+		if n := len(args); n > 1 && args[n-1] == ">&2" {
+			c.Args, c.Stdout = args[:n-1], stderr
+		}
+		builtin, ok := s.Builtins[args[0]]
+		if !ok {
+			fmt.Fprintf(stderr, "%s: command not found\n", args[0])
+			session.Status = 127
+			continue
+		}
+		status, err := builtin(c)
+		session.Status = status
+		var replyErr *ReplyError
+		switch {
+		case errors.As(err, &replyErr):
+			return "ERROR " + replyErr.Message, nil
+		case errors.Is(err, ErrCrash):
+			return "", err
+		case errors.Is(err, errStop):
+			return "OK", nil
+		case err != nil:
+			fmt.Fprintln(stderr, err)
+		}
+	}
+	return "OK", nil
+}
+
+// splitWords splits a line like a (very) simple shell: on whitespace,
+// keeping '...' and "..." together.
+func splitWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+func write(c *Call) (int, error) {
+	_, err := fmt.Fprintln(c.Stdout, strings.Join(c.Args[1:], " "))
+	return 0, err
+}
+
+func cat(c *Call) (int, error) {
+	_, err := io.Copy(c.Stdout, c.Stdin)
+	return 0, err
+}
+
+func sleep(c *Call) (int, error) {
+	if len(c.Args) != 2 {
+		return 2, errors.New("usage: sleep DURATION")
+	}
+	d, err := time.ParseDuration(c.Args[1])
+	if err != nil {
+		seconds, ferr := strconv.ParseFloat(c.Args[1], 64)
+		if ferr != nil {
+			return 2, err
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	time.Sleep(d)
+	return 0, nil
+}
+
+func exit(c *Call) (int, error) {
+	status := 0
+	if len(c.Args) > 1 {
+		var err error
+		if status, err = strconv.Atoi(c.Args[1]); err != nil {
+			return 2, err
+		}
+	}
+	return status, errStop
+}
+
+// spawn starts a program in a new session with stdin as its controlling
+// terminal, so it becomes the foreground process group like a job of a real shell.
+// stdin has to be a terminal.
+func spawn(c *Call) (int, error) {
+	if len(c.Args) < 2 {
+		return 2, errors.New("usage: spawn PROGRAM ARGS...")
+	}
+	cmd := exec.Command(c.Args[1], c.Args[2:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.Stdin, c.Stdout, c.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 127, err
+	}
+	return 0, nil
+}
+
+func cd(c *Call) (int, error) {
+	if len(c.Args) != 2 {
+		return 2, errors.New("usage: cd DIR")
+	}
+	dir := c.Args[1]
+	if !path.IsAbs(dir) {
+		dir = path.Join(c.Session.Dir, dir)
+	}
+	c.Session.Dir = path.Clean(dir)
+	return 0, nil
+}
+
+func pwd(c *Call) (int, error) {
+	_, err := fmt.Fprintln(c.Stdout, c.Session.Dir)
+	return 0, err
+}
+
+func export(c *Call) (int, error) {
+	for _, arg := range c.Args[1:] {
+		name, _, ok := strings.Cut(arg, "=")
+		if !ok {
+			return 2, fmt.Errorf("export: %q isn't NAME=VALUE", arg)
+		}
+		c.Session.Env = slices.DeleteFunc(c.Session.Env, func(v string) bool {
+			return strings.HasPrefix(v, name+"=")
+		})
+		c.Session.Env = append(c.Session.Env, arg)
+	}
+	return 0, nil
+}
+
+func env(c *Call) (int, error) {
+	sep := "\n"
+	if len(c.Args) > 1 && c.Args[1] == "-0" {
+		sep = "\x00"
+	}
+	for _, v := range c.Session.Env {
+		if _, err := io.WriteString(c.Stdout, v+sep); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+// writeState answers the state query: $? and the session as JSON.
+func writeState(session *Session, w io.Writer) error {
+	env := map[string]string{}
+	for _, v := range session.Env {
+		name, value, _ := strings.Cut(v, "=")
+		env[name] = value
+	}
+	data, err := json.Marshal(map[string]any{
+		"cwd":       session.Dir,
+		"pwd":       session.Dir,
+		"env":       env,
+		"vars":      map[string]any{},
+		"dir_stack": []string{session.Dir},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%d\n%s\n", session.Status, data)
+	return err
+}
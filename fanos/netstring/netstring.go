@@ -0,0 +1,257 @@
+// Package netstring implements the framing used by FANOS: netstrings
+// (https://cr.yp.to/proto/netstrings.txt) which may carry file descriptors
+// as SCM_RIGHTS ancillary data.
+//
+//	12:EVAL echo hi,
+package netstring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+var (
+	ErrInvalidLength = errors.New("netstring: invalid length prefix")
+	ErrMissingComma  = errors.New("netstring: missing trailing ','")
+	ErrTooLong       = errors.New("netstring: payload exceeds maximum length")
+	ErrNoFds         = errors.New("netstring: writer can't pass file descriptors")
+	ErrFdsTruncated  = errors.New("netstring: received file descriptors were truncated")
+)
+
+// DefaultMaxLength is the largest payload a Decoder accepts unless told otherwise.
+const DefaultMaxLength = 64 << 20
+
+// maxFdsPerRead bounds the ancillary data buffer of a single read.
+const maxFdsPerRead = 64
+
+// FdWriter is implemented by connections that can attach file descriptors to
+// the data they send, such as *net.UnixConn.
+type FdWriter interface {
+	io.Writer
+	WriteMsgUnix(b, oob []byte, addr *net.UnixAddr) (n, oobn int, err error)
+}
+
+// FdReader is implemented by connections that can receive file descriptors,
+// such as *net.UnixConn.
+type FdReader interface {
+	io.Reader
+	ReadMsgUnix(b, oob []byte) (n, oobn, flags int, addr *net.UnixAddr, err error)
+}
+
+// Append appends the netstring encoding of payload to dst.
+func Append(dst, payload []byte) []byte {
+	dst = strconv.AppendInt(dst, int64(len(payload)), 10)
+	dst = append(dst, ':')
+	dst = append(dst, payload...)
+	return append(dst, ',')
+}
+
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes payload as a single netstring.
+// fds are attached to the payload, which requires the writer to be an FdWriter.
+func (e *Encoder) Encode(payload []byte, fds ...int) error {
+	if len(fds) == 0 {
+		_, err := e.w.Write(Append(nil, payload))
+		return err
+	}
+	fw, ok := e.w.(FdWriter)
+	if !ok {
+		return ErrNoFds
+	}
+	if len(payload) == 0 {
+		// Ancillary data needs at least one byte to travel with
+		return fmt.Errorf("%w: empty payload", ErrNoFds)
+	}
+
+	// The prefix, the payload and the ',' are sent separately: the fds are
+	// attached to the payload only, so a peer that reads the prefix
+	// byte-by-byte with read(2) (like oils' fanos.c) doesn't drop them.
+	if _, err := fw.Write(strconv.AppendInt(nil, int64(len(payload)), 10)); err != nil {
+		return err
+	}
+	if _, err := fw.Write([]byte{':'}); err != nil {
+		return err
+	}
+	n, _, err := fw.WriteMsgUnix(payload, syscall.UnixRights(fds...), nil)
+	if err != nil {
+		return err
+	}
+	// Short writes only send the fds with the first chunk
+	if n < len(payload) {
+		if _, err := fw.Write(payload[n:]); err != nil {
+			return err
+		}
+	}
+	_, err = fw.Write([]byte{','})
+	return err
+}
+
+// Decoder reads netstrings from a stream.
+// Messages may be split across reads or share a read with the next message.
+type Decoder struct {
+	// MaxLength is the largest payload accepted. Longer ones return ErrTooLong.
+	MaxLength int
+
+	r          io.Reader
+	fr         FdReader
+	buf        []byte
+	start, end int
+	fds        []int
+	err        error
+}
+
+// NewDecoder reads netstrings from r.
+// If r is an FdReader, file descriptors passed alongside the data are collected too.
+func NewDecoder(r io.Reader) *Decoder {
+	d := &Decoder{MaxLength: DefaultMaxLength, r: r, buf: make([]byte, 4096)}
+	d.fr, _ = r.(FdReader)
+	return d
+}
+
+// Decode reads the next netstring.
+// fds are the file descriptors received while reading it; the caller owns them.
+// io.EOF is only returned if the stream ended cleanly between two netstrings.
+func (d *Decoder) Decode() (payload []byte, fds []int, err error) {
+	maxDigits := len(strconv.Itoa(d.MaxLength))
+
+	// Length prefix
+	var colon int
+	for {
+		colon = bytes.IndexByte(d.buf[d.start:d.end], ':')
+		if colon >= 0 {
+			break
+		}
+		if err := d.validPrefix(d.buf[d.start:d.end], maxDigits); err != nil {
+			return nil, d.takeFds(), err
+		}
+		if err := d.fill(); err != nil {
+			if err == io.EOF && d.end > d.start {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, d.takeFds(), err
+		}
+	}
+	prefix := d.buf[d.start : d.start+colon]
+	if len(prefix) == 0 {
+		return nil, d.takeFds(), ErrInvalidLength
+	}
+	if err := d.validPrefix(prefix, maxDigits); err != nil {
+		return nil, d.takeFds(), err
+	}
+	n, err := strconv.Atoi(string(prefix))
+	if err != nil {
+		return nil, d.takeFds(), fmt.Errorf("%w: %q", ErrInvalidLength, prefix)
+	}
+	if n > d.MaxLength {
+		return nil, d.takeFds(), fmt.Errorf("%w: %d > %d", ErrTooLong, n, d.MaxLength)
+	}
+	d.start += colon + 1
+
+	// Payload and trailing ','
+	for d.end-d.start < n+1 {
+		if err := d.fill(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, d.takeFds(), err
+		}
+	}
+	if d.buf[d.start+n] != ',' {
+		return nil, d.takeFds(), ErrMissingComma
+	}
+	payload = bytes.Clone(d.buf[d.start : d.start+n])
+	d.start += n + 1
+	return payload, d.takeFds(), nil
+}
+
+// validPrefix checks a (possibly incomplete) length prefix.
+func (d *Decoder) validPrefix(prefix []byte, maxDigits int) error {
+	for i, c := range prefix {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("%w: unexpected %q", ErrInvalidLength, c)
+		}
+		// Leading zeros are not allowed, "0:," is the only way to start with a 0
+		if i == 1 && prefix[0] == '0' {
+			return fmt.Errorf("%w: leading zero", ErrInvalidLength)
+		}
+	}
+	if len(prefix) > maxDigits {
+		return fmt.Errorf("%w: more than %d digits", ErrTooLong, maxDigits)
+	}
+	return nil
+}
+
+// takeFds hands all collected file descriptors to the caller.
+func (d *Decoder) takeFds() []int {
+	fds := d.fds
+	d.fds = nil
+	return fds
+}
+
+// fill reads more data into the buffer.
+func (d *Decoder) fill() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.start > 0 {
+		d.end = copy(d.buf, d.buf[d.start:d.end])
+		d.start = 0
+	}
+	if d.end == len(d.buf) {
+		d.buf = append(d.buf, make([]byte, len(d.buf))...)
+	}
+
+	var n int
+	var err error
+	if d.fr != nil {
+		oob := make([]byte, syscall.CmsgSpace(maxFdsPerRead*4))
+		var oobn, flags int
+		n, oobn, flags, _, err = d.fr.ReadMsgUnix(d.buf[d.end:], oob)
+		if oobn > 0 {
+			if perr := d.parseRights(oob[:oobn]); perr != nil && err == nil {
+				err = perr
+			}
+		}
+		if flags&syscall.MSG_CTRUNC != 0 && err == nil {
+			err = ErrFdsTruncated
+		}
+	} else {
+		n, err = d.r.Read(d.buf[d.end:])
+	}
+	d.end += n
+	if err != nil {
+		d.err = err
+		if n > 0 {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *Decoder) parseRights(oob []byte) error {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		fds, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		d.fds = append(d.fds, fds...)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package netstring
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+)
+
+var decodeTests = []struct {
+	name    string
+	input   string
+	want    []string
+	wantErr error
+}{
+	{"simple", "2:OK,", []string{"OK"}, nil},
+	{"empty", "0:,", []string{""}, nil},
+	{"comma in payload", "9:ERROR a,b,", []string{"ERROR a,b"}, nil},
+	{"colon in payload", "3:a:b,", []string{"a:b"}, nil},
+	{"two in a row", "2:OK,3:OK ,", []string{"OK", "OK "}, nil},
+	{"clean end", "2:OK,", []string{"OK"}, io.EOF},
+	{"bad length", "x:OK,", nil, ErrInvalidLength},
+	{"empty length", ":OK,", nil, ErrInvalidLength},
+	{"leading zero", "02:OK,", nil, ErrInvalidLength},
+	{"negative", "-2:OK,", nil, ErrInvalidLength},
+	{"too many digits", "123456789012:", nil, ErrTooLong},
+	{"missing comma", "2:OK;", nil, ErrMissingComma},
+	{"truncated payload", "5:OK", nil, io.ErrUnexpectedEOF},
+	{"truncated prefix", "5", nil, io.ErrUnexpectedEOF},
+}
+
+func TestDecode(t *testing.T) {
+	readers := map[string]func(string) io.Reader{
+		"whole":    func(s string) io.Reader { return strings.NewReader(s) },
+		"one byte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+		"half":     func(s string) io.Reader { return iotest.HalfReader(strings.NewReader(s)) },
+	}
+	for _, tt := range decodeTests {
+		for name, reader := range readers {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				d := NewDecoder(reader(tt.input))
+				for _, want := range tt.want {
+					got, _, err := d.Decode()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(got) != want {
+						t.Errorf("got %q, wanted %q", got, want)
+					}
+				}
+				if tt.wantErr != nil {
+					if _, _, err := d.Decode(); !errors.Is(err, tt.wantErr) {
+						t.Errorf("got error %v, wanted %v", err, tt.wantErr)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeMaxLength(t *testing.T) {
+	d := NewDecoder(strings.NewReader("11:hello world,"))
+	d.MaxLength = 5
+	if _, _, err := d.Decode(); !errors.Is(err, ErrTooLong) {
+		t.Errorf("got error %v, wanted %v", err, ErrTooLong)
+	}
+}
+
+func TestDecodeLargePayload(t *testing.T) {
+	payload := bytes.Repeat([]byte("a,b:"), 10000)
+	d := NewDecoder(iotest.HalfReader(bytes.NewReader(Append(nil, payload))))
+	got, _, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload of %d bytes doesn't match", len(payload))
+	}
+}
+
+func TestEncodeWithoutFdSupport(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.Encode([]byte("EVAL echo hi")); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "12:EVAL echo hi," {
+		t.Errorf("got %q", got)
+	}
+	if err := e.Encode([]byte("EVAL"), 0); !errors.Is(err, ErrNoFds) {
+		t.Errorf("got error %v, wanted %v", err, ErrNoFds)
+	}
+}
+
+// socketPair returns both ends of a connected unix stream socket.
+func socketPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+		t.Cleanup(func() { conns[i].Close() })
+	}
+	return conns[0], conns[1]
+}
+
+func TestFdPassing(t *testing.T) {
+	client, server := socketPair(t)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	enc := NewEncoder(client)
+	if err := enc.Encode([]byte("EVAL write hi"), int(w.Fd()), int(w.Fd())); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := enc.Encode([]byte("OK")); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(server)
+	payload, fds, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "EVAL write hi" {
+		t.Errorf("got payload %q", payload)
+	}
+	if len(fds) != 2 {
+		t.Fatalf("got %d fds, wanted 2", len(fds))
+	}
+	for _, fd := range fds {
+		f := os.NewFile(uintptr(fd), "received")
+		if _, err := f.WriteString("hi\n"); err != nil {
+			t.Error(err)
+		}
+		f.Close()
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hi\nhi\n" {
+		t.Errorf("got %q through the passed fds", out)
+	}
+
+	payload, fds, err = dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "OK" || len(fds) != 0 {
+		t.Errorf("got %q with %d fds", payload, len(fds))
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte(""))
+	f.Add([]byte("EVAL echo hi"))
+	f.Add([]byte("a,b:c,"))
+	f.Add([]byte("12:nested,"))
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		if err := e.Encode(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Encode(payload); err != nil {
+			t.Fatal(err)
+		}
+		d := NewDecoder(iotest.HalfReader(&buf))
+		for range 2 {
+			got, _, err := d.Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("got %q, wanted %q", got, payload)
+			}
+		}
+		if _, _, err := d.Decode(); err != io.EOF {
+			t.Fatalf("got error %v, wanted EOF", err)
+		}
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, tt := range decodeTests {
+		f.Add([]byte(tt.input))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(bytes.NewReader(data))
+		d.MaxLength = 1 << 16
+		var reencoded []byte
+		for {
+			payload, _, err := d.Decode()
+			if err != nil {
+				break
+			}
+			reencoded = Append(reencoded, payload)
+		}
+		// Everything that decoded must be an exact prefix of the input
+		if !bytes.HasPrefix(data, reencoded) {
+			t.Fatalf("re-encoded %q is not a prefix of %q", reencoded, data)
+		}
+	})
+}
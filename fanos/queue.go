@@ -0,0 +1,119 @@
+package fanos
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+var ErrClosed = errors.New("shell closed")
+
+// evalQueue makes sure only one EVAL is in flight on the socket.
+// Waiting requests get their turn in the order they arrived; the work itself
+// is done on the caller's goroutine once acquire returns.
+type evalQueue struct {
+	mu      sync.Mutex
+	busy    bool
+	closed  bool
+	backlog []*evalTurn
+}
+
+type evalTurn struct {
+	ready chan struct{}
+	err   error
+}
+
+// acquire blocks until it's the caller's turn to talk to the shell, which
+// must be handed on with release.
+// onQueued is only called if the caller actually has to wait.
+// If ctx is done while waiting, the request leaves the backlog with ctx.Err().
+func (q *evalQueue) acquire(ctx context.Context, onQueued func()) error {
+	return q.join(ctx, onQueued, false)
+}
+
+// acquireNext is acquire, but skips the backlog.
+// Used to restart the shell before anything else talks to it.
+func (q *evalQueue) acquireNext(ctx context.Context) error {
+	return q.join(ctx, nil, true)
+}
+
+func (q *evalQueue) join(ctx context.Context, onQueued func(), front bool) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrClosed
+	}
+	// Given up before it even asked, e.g. the command was canceled
+	if err := ctx.Err(); err != nil {
+		q.mu.Unlock()
+		return err
+	}
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return nil
+	}
+	t := &evalTurn{ready: make(chan struct{})}
+	if front {
+		q.backlog = slices.Insert(q.backlog, 0, t)
+	} else {
+		q.backlog = append(q.backlog, t)
+	}
+	if onQueued != nil {
+		onQueued()
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return t.err
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	if i := slices.Index(q.backlog, t); i >= 0 {
+		q.backlog = slices.Delete(q.backlog, i, i+1)
+		q.mu.Unlock()
+		return ctx.Err()
+	}
+	q.mu.Unlock()
+	// We got the turn just as we gave up on it, pass it on
+	<-t.ready
+	if t.err == nil {
+		q.release()
+	}
+	return ctx.Err()
+}
+
+// release hands the socket to the next waiting request.
+func (q *evalQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.backlog) == 0 {
+		q.busy = false
+		return
+	}
+	t := q.backlog[0]
+	q.backlog = q.backlog[1:]
+	close(t.ready)
+}
+
+// waiting returns the number of requests waiting for their turn.
+func (q *evalQueue) waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.backlog)
+}
+
+// close fails all waiting and future requests with ErrClosed.
+func (q *evalQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	for _, t := range q.backlog {
+		t.err = ErrClosed
+		close(t.ready)
+	}
+	q.backlog = nil
+}
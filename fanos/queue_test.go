@@ -0,0 +1,143 @@
+package fanos
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls until cond is true or fails the test.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for range 200 {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func TestEvalQueueOrder(t *testing.T) {
+	var q evalQueue
+	if err := q.acquire(context.Background(), func() { t.Error("first request must not be queued") }); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Go(func() {
+			queued := false
+			if err := q.acquire(context.Background(), func() { queued = true }); err != nil {
+				t.Error(err)
+				return
+			}
+			if !queued {
+				t.Errorf("request %d should have been queued", i)
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			q.release()
+		})
+		// Make sure they enter the backlog in order
+		waitFor(t, func() bool { return q.waiting() == i+1 })
+	}
+	q.release()
+	wg.Wait()
+
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("requests ran in order %v", order)
+		}
+	}
+	if q.busy {
+		t.Error("queue should be idle after the last release")
+	}
+}
+
+func TestEvalQueueCancelQueued(t *testing.T) {
+	var q evalQueue
+	q.acquire(context.Background(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- q.acquire(ctx, nil) }()
+	waitFor(t, func() bool { return q.waiting() == 1 })
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, wanted context.Canceled", err)
+	}
+	if q.waiting() != 0 {
+		t.Error("canceled request should leave the backlog")
+	}
+
+	// The next request gets the turn once the first is done
+	next := make(chan error)
+	go func() { next <- q.acquire(context.Background(), nil) }()
+	waitFor(t, func() bool { return q.waiting() == 1 })
+	q.release()
+	if err := <-next; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEvalQueueCanceledIdle(t *testing.T) {
+	var q evalQueue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.acquire(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, wanted context.Canceled", err)
+	}
+	// Nobody has the turn
+	if err := q.acquire(context.Background(), nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEvalQueueClose(t *testing.T) {
+	var q evalQueue
+	q.acquire(context.Background(), nil)
+
+	done := make(chan error)
+	go func() { done <- q.acquire(context.Background(), nil) }()
+	waitFor(t, func() bool { return q.waiting() == 1 })
+
+	q.close()
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, wanted ErrClosed", err)
+	}
+	if err := q.acquire(context.Background(), nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, wanted ErrClosed", err)
+	}
+}
+
+func TestEvalQueueAcquireNext(t *testing.T) {
+	var q evalQueue
+	q.acquire(context.Background(), nil)
+
+	got := make(chan string, 2)
+	go func() {
+		q.acquire(context.Background(), nil)
+		got <- "queued"
+		q.release()
+	}()
+	waitFor(t, func() bool { return q.waiting() == 1 })
+	go func() {
+		q.acquireNext(context.Background())
+		got <- "next"
+		q.release()
+	}()
+	waitFor(t, func() bool { return q.waiting() == 2 })
+
+	q.release()
+	if first := <-got; first != "next" {
+		t.Errorf("acquireNext should skip the backlog, %q ran first", first)
+	}
+	<-got
+}
@@ -0,0 +1,83 @@
+package fanos
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// ErrNoForeground is returned when nothing on the command's terminal can be signaled.
+var ErrNoForeground = errors.New("no foreground process group")
+
+// What the line discipline turns into a signal, for when we can't find
+// the foreground process group ourselves.
+var controlChars = map[syscall.Signal]byte{
+	syscall.SIGINT:  0x03, // ^C
+	syscall.SIGQUIT: 0x1c, // ^\
+	syscall.SIGTSTP: 0x1a, // ^Z
+}
+
+// Signal sends sig to the foreground process group of the command's pty.
+// A command which is still queued is dropped from the queue instead.
+func (c *Command) Signal(sig os.Signal) error {
+	switch c.State() {
+	case shell.Ready, shell.Stopped:
+		return shell.ErrNotRunning
+	case shell.Queued:
+		c.Cancel()
+		return nil
+	}
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", sig)
+	}
+
+	pgrp, err := c.foreground()
+	if err == nil {
+		return syscall.Kill(-pgrp, s)
+	}
+	if b, ok := controlChars[s]; ok {
+		_, werr := c.ptmx.Write([]byte{b})
+		return werr
+	}
+	return err
+}
+
+func (c *Command) Interrupt() error {
+	return c.Signal(syscall.SIGINT)
+}
+
+func (c *Command) Kill() error {
+	return c.Signal(syscall.SIGKILL)
+}
+
+// foreground returns the foreground process group of the command's pty.
+// We never return our own group or the one of the shell, killing those
+// would take down more than the command.
+func (c *Command) foreground() (int, error) {
+	raw, err := c.ptmx.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var pgrp int
+	var ioctlErr error
+	// Not using Fd(), it would put the pty into blocking mode
+	err = raw.Control(func(fd uintptr) {
+		pgrp, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	})
+	if err == nil {
+		err = ioctlErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrNoForeground, err)
+	}
+	if pgrp <= 0 || pgrp == syscall.Getpgrp() || pgrp == c.shell.pgid() {
+		return 0, ErrNoForeground
+	}
+	return pgrp, nil
+}
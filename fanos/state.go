@@ -0,0 +1,139 @@
+package fanos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// Variables reported in State.Vars unless WithStateVars says otherwise
+var defaultStateVars = []string{"HOME", "USER", "HOSTNAME", "PS1"}
+
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// WithStateVars selects the shell variables reported in State.Vars.
+// Invalid names are ignored.
+func WithStateVars(names ...string) Option {
+	return func(s *Shell) {
+		s.stateVars = slices.DeleteFunc(slices.Clone(names), func(n string) bool { return !varName.MatchString(n) })
+	}
+}
+
+// stateMarker starts every state query, fanostest.StateMarker has to match.
+const stateMarker = "# oils-readline: state"
+
+// stateScript prints `$?` on the first line, followed by the State as JSON.
+// The JSON is built by ysh in a subshell, so nothing leaks into the user's
+// session. osh needs the ysh features switched on first, and eval so the
+// snippet is only parsed afterwards. ysh:upgrade isn't enough, ENV comes with
+// env_obj of ysh:all.
+func stateScript(vars []string, osh bool) string {
+	body := `var vars = {}
+for name in :| ` + strings.Join(vars, " ") + ` | {
+  setvar vars[name] = getVar(name)
+}
+json write ({
+  cwd: $(pwd -P),
+  pwd: getVar("PWD"),
+  env: ENV,
+  vars: vars,
+  dir_stack: :| @(dirs -l -p) |
+})`
+	if osh {
+		return stateMarker + "\necho $?\n( shopt --set ysh:all; eval '" +
+			strings.ReplaceAll(body, "'", `'\''`) + "' )"
+	}
+	return stateMarker + "\necho $?\nforkwait {\n" + body + "\n}"
+}
+
+// parseState reads the output of stateScript. The status is set if it could
+// be read, even if the rest couldn't.
+func parseState(out string) (shell.State, error) {
+	var state shell.State
+	statusLine, data, _ := strings.Cut(out, "\n")
+	status, err := strconv.Atoi(strings.TrimSpace(statusLine))
+	if err != nil {
+		return state, fmt.Errorf("%w: invalid exit status %q", ErrProtocol, statusLine)
+	}
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return shell.State{Status: status}, fmt.Errorf("%w: invalid state: %w", ErrProtocol, err)
+	}
+	state.Status = status
+	return state, nil
+}
+
+// State returns what the shell looked like after the last command.
+func (s *Shell) State() shell.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// RefreshState asks the shell for its current state, waiting for running commands.
+func (s *Shell) RefreshState() (shell.State, error) {
+	if err := s.queue.acquire(s.ctx, nil); err != nil {
+		return shell.State{}, err
+	}
+	defer s.queue.release()
+	state, err := s.refreshState()
+	if errors.Is(err, ErrCrashed) {
+		s.restart(err)
+	}
+	return state, err
+}
+
+// refreshState queries and caches the state, the caller holds the EVAL turn.
+func (s *Shell) refreshState() (shell.State, error) {
+	vars := s.stateVars
+	if vars == nil {
+		vars = defaultStateVars
+	}
+	out, err := s.query(stateScript(vars, s.osh), "")
+	if errors.Is(err, ErrCrashed) {
+		return shell.State{}, err
+	}
+	state, parseErr := parseState(out)
+	if err == nil {
+		err = parseErr
+	}
+	if err != nil {
+		// Keep what we knew, only the status is new
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.state.Status = state.Status
+		return s.state, err
+	}
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+	return state, nil
+}
+
+// environ turns State.Env back into what exec.Cmd wants, nil means
+// inheriting ours.
+func environ(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	var list []string
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// isOsh tells whether the interpreter runs as osh rather than ysh.
+func isOsh(path string, args []string) bool {
+	if len(args) > 0 {
+		return args[0] == "osh"
+	}
+	return strings.HasPrefix(filepath.Base(path), "osh")
+}
@@ -0,0 +1,201 @@
+package fanos
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Melkor333/oils-readline/fanos/fanostest"
+)
+
+func TestParseState(t *testing.T) {
+	state, err := parseState(`3
+{
+  "cwd": "/home/me/src",
+  "pwd": "/home/me/link",
+  "env": {"HOME": "/home/me"},
+  "vars": {"PS1": "$ ", "UNSET": null},
+  "dir_stack": ["/home/me/link", "/tmp"]
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != 3 || state.Cwd != "/home/me/src" || state.PWD != "/home/me/link" {
+		t.Errorf("got %+v", state)
+	}
+	if state.Env["HOME"] != "/home/me" || state.Vars["PS1"] != "$ " || state.Vars["UNSET"] != nil {
+		t.Errorf("got env %v and vars %v", state.Env, state.Vars)
+	}
+	if !slices.Equal(state.DirStack, []string{"/home/me/link", "/tmp"}) {
+		t.Errorf("got dir stack %v", state.DirStack)
+	}
+
+	for _, out := range []string{"", "x\n{}", "0\nnot json"} {
+		if _, err := parseState(out); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%q: got %v, wanted ErrProtocol", out, err)
+		}
+	}
+}
+
+func TestStateScript(t *testing.T) {
+	ysh := stateScript([]string{"HOME", "PS1"}, false)
+	if !strings.HasPrefix(ysh, fanostest.StateMarker+"\necho $?\n") {
+		t.Errorf("the status has to be read first:\n%s", ysh)
+	}
+	if !strings.Contains(ysh, ":| HOME PS1 |") {
+		t.Errorf("variables missing:\n%s", ysh)
+	}
+	osh := stateScript(nil, true)
+	if !strings.Contains(osh, "shopt --set ysh:all; eval '") {
+		t.Errorf("osh needs ysh features for the snippet:\n%s", osh)
+	}
+}
+
+func TestWithStateVars(t *testing.T) {
+	s := &Shell{}
+	WithStateVars("PS1", "not valid", "$(reboot)", "_x1")(s)
+	if !slices.Equal(s.stateVars, []string{"PS1", "_x1"}) {
+		t.Errorf("got %v", s.stateVars)
+	}
+}
+
+func TestShell_State(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("needs the fanostest server")
+	}
+	s := newTestShell(t)
+	if s.State().Cwd != "/" {
+		t.Errorf("New should fetch the state, got %+v", s.State())
+	}
+
+	runCommand(t, s, "cd /home/me/src\nexport FOO=bar\nexport HOME=/home/me\nfalse")
+	state := s.State()
+	if state.Cwd != "/home/me/src" || s.Dir() != "/home/me/src" {
+		t.Errorf("got cwd %q and Dir %q", state.Cwd, s.Dir())
+	}
+	if state.Status != 1 {
+		t.Errorf("got status %d, wanted 1", state.Status)
+	}
+	if state.Env["FOO"] != "bar" {
+		t.Errorf("got env %v", state.Env)
+	}
+	if got := s.GetPrompt(); got != "~/src $ " {
+		t.Errorf("got prompt %q", got)
+	}
+
+	refreshed, err := s.RefreshState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Cwd != state.Cwd || refreshed.Status != 1 {
+		t.Errorf("got %+v after refreshing, wanted %+v", refreshed, state)
+	}
+
+	// A restarted shell continues where this one is
+	cmd := s.oilsCommand(context.Background(), "oils-for-unix", "ysh")
+	if cmd.Dir != "/home/me/src" {
+		t.Errorf("restarted shell should run in /home/me/src, got %q", cmd.Dir)
+	}
+	if !slices.Contains(cmd.Env, "FOO=bar") {
+		t.Errorf("restarted shell should get the exported environment, got %q", cmd.Env)
+	}
+}
+
+func TestIsOsh(t *testing.T) {
+	tests := []struct {
+		path string
+		args []string
+		want bool
+	}{
+		{"/usr/bin/osh", nil, true},
+		{"/usr/bin/ysh", nil, false},
+		{"/cache/oils-for-unix", []string{"ysh"}, false},
+		{"/cache/oils-for-unix", []string{"osh"}, true},
+	}
+	for _, tt := range tests {
+		if got := isOsh(tt.path, tt.args); got != tt.want {
+			t.Errorf("isOsh(%q, %q) = %v, wanted %v", tt.path, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestShell_BrokenStateQuery(t *testing.T) {
+	srv := fanostest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.StateError = "ENV: undefined variable"
+	s, err := New(WithDialer(srv.Dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Cancel)
+
+	if c := runCommand(t, s, "write hi"); c.ExitCode() != 0 {
+		t.Errorf("the command worked, got status %d", c.ExitCode())
+	}
+	if c := runCommand(t, s, "false"); c.ExitCode() != 1 {
+		t.Errorf("the status comes before the state, got %d", c.ExitCode())
+	}
+	if _, err := s.RefreshState(); !errors.Is(err, ErrEval) {
+		t.Errorf("got %v, wanted ErrEval", err)
+	}
+}
+
+// TestStateScriptOils runs the state query in a real osh and ysh, with
+// -oil_path or the embedded oils.
+func TestStateScriptOils(t *testing.T) {
+	if *fanosShellPath == "" && len(embeddedOils) == 0 {
+		t.Skip("needs oils, see -oil_path")
+	}
+	for _, name := range []string{"osh", "ysh"} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(dir, "sub"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			s, err := New(WithInterpreter(name), WithDir(dir), WithStateVars("HOME", "OILS_READLINE_X"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(s.Cancel)
+			if got := s.Interpreter(); got != name {
+				t.Fatalf("got %s", got)
+			}
+
+			setup := "cd sub; export OILS_READLINE_FOO=bar; OILS_READLINE_X=1"
+			if name == "ysh" {
+				setup = "cd sub; setglobal ENV.OILS_READLINE_FOO = 'bar'; setglobal OILS_READLINE_X = '1'"
+			}
+			if c := runCommand(t, s, "var OILS_READLINE_X = ''"); name == "ysh" && c.ExitCode() != 0 {
+				t.Fatalf("got status %d", c.ExitCode())
+			}
+			if c := runCommand(t, s, setup); c.ExitCode() != 0 {
+				t.Fatalf("got status %d", c.ExitCode())
+			}
+			state, err := s.RefreshState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Cwd != filepath.Join(dir, "sub") || state.Env["OILS_READLINE_FOO"] != "bar" {
+				t.Errorf("got cwd %q and env %v", state.Cwd, state.Env)
+			}
+			if state.Vars["OILS_READLINE_X"] != "1" || state.Vars["HOME"] == nil {
+				t.Errorf("got vars %v", state.Vars)
+			}
+			if len(state.DirStack) == 0 || state.DirStack[0] != filepath.Join(dir, "sub") {
+				t.Errorf("got dir stack %v", state.DirStack)
+			}
+
+			if c := runCommand(t, s, "false"); c.ExitCode() != 1 {
+				t.Errorf("got status %d", c.ExitCode())
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package fanos
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A shell that dies more often than this within restartWindow stays dead,
+// e.g. when it crashes on startup.
+const (
+	maxRestarts   = 3
+	restartWindow = 30 * time.Second
+)
+
+// Keep at most this much of the interpreter's own stderr around
+const diagnosticsLimit = 64 << 10
+
+// processExited restarts the shell if oils died while nobody talked to it.
+func (s *Shell) processExited(generation int, state *os.ProcessState) {
+	if err := s.queue.acquireNext(s.ctx); err != nil {
+		return
+	}
+	defer s.queue.release()
+	s.mu.Lock()
+	// An EVAL noticed it first and restarted it already
+	current := s.generation == generation
+	s.mu.Unlock()
+	if current {
+		s.restart(fmt.Errorf("%w: %v", ErrCrashed, state))
+	}
+}
+
+// restart replaces a dead shell with a new one.
+// The caller has to hold the EVAL turn.
+func (s *Shell) restart(cause error) {
+	if s.ctx.Err() != nil {
+		// We were canceled, nothing crashed
+		return
+	}
+	s.mu.Lock()
+	socket, stop, exited := s.socket, s.stopProc, s.exited
+	now := time.Now()
+	s.restarts = slices.DeleteFunc(s.restarts, func(t time.Time) bool { return now.Sub(t) > restartWindow })
+	s.restarts = append(s.restarts, now)
+	tooMany := len(s.restarts) > maxRestarts
+	dir := s.state.Cwd
+	s.mu.Unlock()
+
+	// Make sure the old one is really gone
+	socket.Close()
+	if stop != nil {
+		stop()
+		<-exited
+	}
+
+	if tooMany {
+		s.diag.notef("shell died %d times within %v, giving up: %v", len(s.restarts), restartWindow, cause)
+		s.Cancel()
+		return
+	}
+	if dir != "" {
+		s.diag.notef("%v, restarting in %s", cause, dir)
+	} else {
+		s.diag.notef("%v, restarting", cause)
+	}
+	if err := s.start(); err != nil {
+		s.diag.notef("can't restart shell: %v", err)
+		s.Cancel()
+		return
+	}
+
+	s.mu.Lock()
+	onRestart := s.onRestart
+	s.mu.Unlock()
+	if onRestart != nil {
+		onRestart(cause)
+	}
+}
+
+// SetOnRestart registers fn to be called after the shell was restarted.
+func (s *Shell) SetOnRestart(fn func(cause error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRestart = fn
+}
+
+// SetOnDiagnostics registers fn to be called when there are new diagnostics.
+func (s *Shell) SetOnDiagnostics(fn func()) {
+	s.diag.mu.Lock()
+	defer s.diag.mu.Unlock()
+	s.diag.onWrite = fn
+}
+
+// Diagnostics returns what the interpreter itself wrote to stderr,
+// together with notes about restarts.
+func (s *Shell) Diagnostics() string {
+	return s.diag.String()
+}
+
+// diagnostics keeps the tail of the interpreter's stderr.
+type diagnostics struct {
+	mu      sync.Mutex
+	buf     []byte
+	onWrite func()
+}
+
+func (d *diagnostics) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	d.buf = append(d.buf, p...)
+	if over := len(d.buf) - diagnosticsLimit; over > 0 {
+		d.buf = slices.Delete(d.buf, 0, over)
+	}
+	onWrite := d.onWrite
+	d.mu.Unlock()
+	if onWrite != nil {
+		onWrite()
+	}
+	return len(p), nil
+}
+
+func (d *diagnostics) notef(format string, args ...any) {
+	fmt.Fprintf(d, "oils-readline: "+format+"\n", args...)
+}
+
+func (d *diagnostics) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return string(d.buf)
+}
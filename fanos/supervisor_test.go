@@ -0,0 +1,120 @@
+package fanos
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+func runCommand(t *testing.T, s *Shell, commandLine string) *Command {
+	t.Helper()
+	c, err := s.Command(commandLine, &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Run()
+	return c.(*Command)
+}
+
+func TestShell_RestartAfterCrash(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("needs the fanostest server")
+	}
+	s := newTestShell(t)
+	var restarted atomic.Int32
+	s.SetOnRestart(func(cause error) {
+		if !errors.Is(cause, ErrCrashed) {
+			t.Errorf("got cause %v, wanted ErrCrashed", cause)
+		}
+		restarted.Add(1)
+	})
+
+	c := runCommand(t, s, "crash")
+	if !errors.Is(c.Err(), ErrCrashed) {
+		t.Errorf("got error %v, wanted ErrCrashed", c.Err())
+	}
+	if restarted.Load() != 1 {
+		t.Errorf("shell restarted %d times, wanted 1", restarted.Load())
+	}
+	if !strings.Contains(s.Diagnostics(), "restarting") {
+		t.Errorf("restart should be noted in the diagnostics, got %q", s.Diagnostics())
+	}
+
+	c = runCommand(t, s, "write still here")
+	if c.Err() != nil {
+		t.Errorf("command after restart failed: %v", c.Err())
+	}
+	waitFor(t, func() bool { return strings.Contains(c.Stdout(), "still here") })
+}
+
+func TestShell_GiveUpRestarting(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("needs the fanostest server")
+	}
+	s := newTestShell(t)
+	for range maxRestarts + 1 {
+		runCommand(t, s, "crash")
+	}
+
+	done := make(chan struct{})
+	go func() { s.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shell should give up after crashing repeatedly")
+	}
+	if !strings.Contains(s.Diagnostics(), "giving up") {
+		t.Errorf("got diagnostics %q", s.Diagnostics())
+	}
+	if c := runCommand(t, s, "write hi"); !errors.Is(c.Err(), ErrClosed) {
+		t.Errorf("got %v, wanted ErrClosed", c.Err())
+	}
+}
+
+// A shell which doesn't speak FANOS dies right away, without anybody talking to it.
+func TestShell_DiagnosticsFromStderr(t *testing.T) {
+	if *fanosShellPath != "" {
+		t.Skip("replaces -oil_path")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	*fanosShellPath = sh
+	defer func() { *fanosShellPath = "" }()
+
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cancel()
+	var notified atomic.Bool
+	s.SetOnDiagnostics(func() { notified.Store(true) })
+
+	done := make(chan struct{})
+	go func() { s.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shell should give up")
+	}
+	diag := s.Diagnostics()
+	if !strings.Contains(diag, "giving up") {
+		t.Errorf("got diagnostics %q", diag)
+	}
+	// sh complains about --headless
+	if !slices.ContainsFunc(strings.Split(diag, "\n"), func(l string) bool {
+		return l != "" && !strings.HasPrefix(l, "oils-readline:")
+	}) {
+		t.Errorf("stderr of the shell should end up in the diagnostics, got %q", diag)
+	}
+	if !notified.Load() {
+		t.Error("OnDiagnostics wasn't called")
+	}
+}
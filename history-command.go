@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Melkor333/oils-readline/history"
+)
+
+var errHistoryUsage = errors.New(`usage:
+  oils-readline history import FORMAT FILE    add the history of another shell
+  oils-readline history export FORMAT [FILE]  write the history for another shell
+FORMAT is one of bash, zsh or oils, FILE - is stdin/stdout`)
+
+var (
+	ignoreSpaceFlag = flag.Bool("history_ignore_space", true, "Don't keep commands in the history which start with a space")
+	ignoreDupsFlag  = flag.Bool("history_ignore_dups", false, "Don't keep a command in the history if it's the same as the one before")
+	redactFlag      = flag.Bool("history_redact", true, "Hide secrets (tokens, passwords, ...) in the history, see also -history_redact_pattern")
+
+	ignorePatterns []*regexp.Regexp
+	redactPatterns []*regexp.Regexp
+)
+
+func init() {
+	flag.Func("history_ignore", "Don't keep commands in the history which match this regexp (repeatable)", regexpAppender(&ignorePatterns))
+	flag.Func("history_redact_pattern", "Also hide what this regexp, or its groups, match in the history (repeatable)", regexpAppender(&redactPatterns))
+}
+
+func regexpAppender(res *[]*regexp.Regexp) func(string) error {
+	return func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		*res = append(*res, re)
+		return nil
+	}
+}
+
+// historyPolicy decides what the history keeps, from the flags.
+func historyPolicy() history.Policy {
+	p := history.Policy{
+		IgnoreSpace: *ignoreSpaceFlag,
+		IgnoreDups:  *ignoreDupsFlag,
+		Ignore:      ignorePatterns,
+		Redact:      redactPatterns,
+	}
+	if *redactFlag {
+		p.Redact = append(slices.Clone(history.DefaultRedact), redactPatterns...)
+	}
+	return p
+}
+
+// historyPath is the -history file.
+func historyPath() (string, error) {
+	if *historyFlag != "" {
+		return *historyFlag, nil
+	}
+	return history.DefaultPath()
+}
+
+// historyCommand runs `oils-readline history ARGS`.
+// Exported history is written to stdout unless there's a file.
+func historyCommand(args []string, stdout io.Writer) error {
+	if len(args) < 2 {
+		return errHistoryUsage
+	}
+	format, err := history.ParseFormat(args[1])
+	if err != nil {
+		return err
+	}
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	store, err := history.NewStore(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "import" && len(args) == 3:
+		entries, err := importFile(format, args[2])
+		if err != nil {
+			return err
+		}
+		policy := historyPolicy()
+		added, err := store.Import(policy.Filter(entries))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Imported %d of %d commands into %s\n", added, len(entries), path)
+		return nil
+
+	case args[0] == "export" && len(args) <= 3:
+		entries, err := store.Load()
+		if err != nil {
+			return err
+		}
+		if len(args) == 3 && args[2] != "-" {
+			f, err := os.OpenFile(args[2], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			stdout = f
+		}
+		return history.Export(stdout, format, entries)
+	}
+	return errHistoryUsage
+}
+
+func importFile(format history.Format, path string) ([]history.Entry, error) {
+	if path == "-" {
+		return history.Import(os.Stdin, format)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return history.Import(f, format)
+}
+
+// seedHistory adds the histories of -seed_history to h.
+func seedHistory(h *history.History) *history.History {
+	if *seedFlag == "" {
+		return h
+	}
+	var seed []history.Entry
+	for _, spec := range strings.Split(*seedFlag, ",") {
+		name, path, ok := strings.Cut(spec, ":")
+		if !ok {
+			log.Printf("Can't seed history from %q: expected FORMAT:FILE", spec)
+			continue
+		}
+		format, err := history.ParseFormat(name)
+		if err != nil {
+			log.Printf("Can't seed history from %q: %v", spec, err)
+			continue
+		}
+		// Not expanded by the shell after the colon
+		if rest, ok := strings.CutPrefix(path, "~/"); ok {
+			home, _ := os.UserHomeDir()
+			path = filepath.Join(home, rest)
+		}
+		entries, err := importFile(format, path)
+		if err != nil {
+			log.Printf("Can't seed history from %q: %v", spec, err)
+			continue
+		}
+		seed = append(seed, entries...)
+	}
+	h.Seed(seed)
+	return h
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryCommand(t *testing.T) {
+	dir := t.TempDir()
+	*historyFlag = filepath.Join(dir, "history.jsonl")
+	defer func() { *historyFlag = "" }()
+
+	bash := filepath.Join(dir, "bash_history")
+	os.WriteFile(bash, []byte("#1700000000\nls\n#1700000005\nmake\n"), 0600)
+
+	var out strings.Builder
+	if assert.NoError(t, historyCommand([]string{"import", "bash", bash}, &out)) {
+		assert.Contains(t, out.String(), "Imported 2 of 2 commands")
+	}
+
+	out.Reset()
+	if assert.NoError(t, historyCommand([]string{"export", "zsh"}, &out)) {
+		assert.Equal(t, ": 1700000000:0;ls\n: 1700000005:0;make\n", out.String())
+	}
+
+	assert.ErrorIs(t, historyCommand([]string{"import", "fish", bash}, &out), history.ErrUnknownFormat)
+	assert.ErrorIs(t, historyCommand([]string{"import", "bash"}, &out), errHistoryUsage)
+}
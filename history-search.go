@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"charm.land/bubbles/v2/textinput"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// HistorySearch filters the history as you type, like reverse-i-search.
+// Accepting a command puts it into the prompt, or shows it in the viewers.
+type HistorySearch struct {
+	history *history.History
+	// The focused shell, the filters and ranking refer to it. Its id is nil
+	// without one
+	shell   shell.Shell
+	shellID *uint64
+
+	input   textinput.Model
+	filter  history.SearchFilter
+	matches []history.Match
+	cursor  int
+	width   int
+	height  int
+}
+
+func newHistorySearch(h *history.History, s shell.Shell, id *uint64) *HistorySearch {
+	hs := &HistorySearch{
+		history: h,
+		shell:   s,
+		shellID: id,
+		input:   textinput.New(),
+	}
+	hs.input.Prompt = "> "
+	hs.input.Placeholder = "search history"
+	hs.search()
+	return hs
+}
+
+func (hs *HistorySearch) Init() tea.Cmd {
+	return hs.input.Focus()
+}
+
+func (hs *HistorySearch) cwd() string {
+	if hs.shell == nil {
+		return ""
+	}
+	return hs.shell.Dir()
+}
+
+func (hs *HistorySearch) search() {
+	hs.matches = hs.history.Search(hs.input.Value(), hs.filter, hs.cwd())
+	hs.cursor = 0
+}
+
+func (hs *HistorySearch) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		switch msg.String() {
+		case "up", "ctrl+p":
+			if hs.cursor > 0 {
+				hs.cursor--
+			}
+			return hs, nil
+		case "down", "ctrl+n", "ctrl+r":
+			if hs.cursor < len(hs.matches)-1 {
+				hs.cursor++
+			}
+			return hs, nil
+		case "alt+f":
+			hs.filter.Failed = !hs.filter.Failed
+			hs.search()
+			return hs, nil
+		case "alt+s":
+			if hs.filter.Shell == nil {
+				hs.filter.Shell = hs.shellID
+			} else {
+				hs.filter.Shell = nil
+			}
+			hs.search()
+			return hs, nil
+		case "alt+d":
+			if hs.filter.Cwd == "" {
+				hs.filter.Cwd = hs.cwd()
+			} else {
+				hs.filter.Cwd = ""
+			}
+			hs.search()
+			return hs, nil
+		case "enter":
+			if sel, ok := hs.selected(); ok {
+				return hs, closeWith(SetPromptMsg{Text: sel.Entry.Command, Shell: hs.shell})
+			}
+			return hs, func() tea.Msg { return CloseSelectorMsg{} }
+		case "tab":
+			if sel, ok := hs.selected(); ok {
+				// ctrl+h/ctrl+l go on from there
+				hs.history.SetCurrent(sel.Index)
+				return hs, closeWith(history.HistoryEntryMsg{Cmd: sel.Cmd, Index: sel.Index, Total: hs.history.Count()})
+			}
+			return hs, nil
+		case "esc", "ctrl+c", "ctrl+g":
+			return hs, func() tea.Msg { return CloseSelectorMsg{} }
+		}
+	case tea.WindowSizeMsg:
+		hs.width = msg.Width
+		hs.height = msg.Height
+		hs.input.SetWidth(max(10, msg.Width/2))
+		return hs, nil
+	}
+
+	query := hs.input.Value()
+	var cmd tea.Cmd
+	hs.input, cmd = hs.input.Update(msg)
+	if hs.input.Value() != query {
+		hs.search()
+	}
+	return hs, cmd
+}
+
+func (hs *HistorySearch) selected() (history.Match, bool) {
+	if hs.cursor >= len(hs.matches) {
+		return history.Match{}, false
+	}
+	return hs.matches[hs.cursor], true
+}
+
+// markMatches renders a command line on one line, with the matched characters
+// in matchStyle.
+func markMatches(m history.Match, style, matchStyle lipgloss.Style) string {
+	matched := map[int]bool{}
+	for _, i := range m.MatchedIndexes {
+		matched[i] = true
+	}
+	var b strings.Builder
+	for i, r := range m.Entry.Command {
+		s := string(r)
+		if r == '\n' {
+			s = "↵"
+		}
+		if matched[i] {
+			b.WriteString(matchStyle.Render(s))
+		} else {
+			b.WriteString(style.Render(s))
+		}
+	}
+	return b.String()
+}
+
+func (hs *HistorySearch) View() tea.View {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	cursorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	itemStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("15"))
+	matchStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("3"))
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+
+	title := titleStyle.Render("Search History")
+
+	filters := []string{"all"}
+	if hs.filter.Failed || hs.filter.Shell != nil || hs.filter.Cwd != "" {
+		filters = nil
+	}
+	if hs.filter.Failed {
+		filters = append(filters, "failed")
+	}
+	if hs.filter.Shell != nil {
+		filters = append(filters, fmt.Sprintf("shell #%d", *hs.filter.Shell))
+	}
+	if hs.filter.Cwd != "" {
+		filters = append(filters, "in "+hs.filter.Cwd)
+	}
+	status := helpStyle.Render(fmt.Sprintf("%d matches, %s", len(hs.matches), strings.Join(filters, ", ")))
+
+	// Leave room for the border, padding, title, input and help
+	rows := max(1, hs.height-12)
+	first := max(0, hs.cursor-rows+1)
+	width := max(20, hs.width-12)
+	var items []string
+	for i := first; i < len(hs.matches) && i < first+rows; i++ {
+		m := hs.matches[i]
+		style := itemStyle
+		prefix := "  "
+		if i == hs.cursor {
+			style = cursorStyle
+			prefix = "> "
+		}
+		mark := " "
+		if m.Entry.Status > 0 {
+			mark = highlightColor.Render("✗")
+		}
+		line := prefix + mark + " " + markMatches(m, style, matchStyle)
+		if m.Entry.Cwd != "" {
+			line += helpStyle.Render("  " + m.Entry.Cwd)
+		}
+		items = append(items, lipgloss.NewStyle().MaxWidth(width).Render(line))
+	}
+	if len(items) == 0 {
+		items = append(items, itemStyle.Render("  no matches"))
+	}
+	list := lipgloss.JoinVertical(lipgloss.Left, items...)
+	help := helpStyle.Render("enter: edit  tab: show  alt+f: failed  alt+s: this shell  alt+d: this dir")
+	content := lipgloss.JoinVertical(lipgloss.Left, title, hs.input.View(), status, "", list, "", help)
+
+	dialog := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("12")).
+		Padding(1, 2).
+		Render(content)
+
+	centered := lipgloss.Place(hs.width, hs.height, lipgloss.Center, lipgloss.Center, dialog)
+	return tea.NewView(centered)
+}
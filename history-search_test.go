@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/stretchr/testify/assert"
+)
+
+// sequence runs the commands of a tea.Sequence, in order.
+func sequence(t *testing.T, cmd tea.Cmd) []tea.Msg {
+	t.Helper()
+	v := reflect.ValueOf(cmd())
+	var msgs []tea.Msg
+	for i := range v.Len() {
+		msgs = append(msgs, v.Index(i).Interface().(tea.Cmd)())
+	}
+	return msgs
+}
+
+func typeText(m tea.Model, text string) {
+	for _, r := range text {
+		m.Update(tea.KeyPressMsg{Code: r, Text: string(r)})
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	s := &namedShell{kind: "ysh", dir: "/src"}
+	h := &history.History{}
+	h.Add(newFakeCmd("make test", ""), history.Entry{Cwd: "/src"})
+	h.Add(newFakeCmd("git status", ""), history.Entry{Cwd: "/tmp"})
+	h.Add(newFakeCmd("make build", ""), history.Entry{Cwd: "/tmp"})
+
+	hs := newHistorySearch(h, s, nil)
+	hs.Init()
+	hs.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	assert.Len(t, hs.matches, 3)
+
+	typeText(hs, "mk")
+	if assert.Len(t, hs.matches, 2) {
+		assert.Equal(t, "make build", hs.matches[0].Entry.Command)
+	}
+	assert.Contains(t, hs.View().Content, "2 matches")
+
+	hs.Update(tea.KeyPressMsg{Code: 'd', Mod: tea.ModAlt})
+	if assert.Len(t, hs.matches, 1, "only /src") {
+		assert.Equal(t, "make test", hs.matches[0].Entry.Command)
+	}
+	assert.Contains(t, hs.View().Content, "in /src")
+
+	_, cmd := hs.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	assert.Equal(t, []tea.Msg{SetPromptMsg{Text: "make test", Shell: s}, CloseSelectorMsg{}}, sequence(t, cmd))
+
+	_, cmd = hs.Update(tea.KeyPressMsg{Code: tea.KeyTab})
+	msgs := sequence(t, cmd)
+	if entry, ok := msgs[0].(history.HistoryEntryMsg); assert.True(t, ok) {
+		assert.Equal(t, 0, entry.Index)
+		assert.Equal(t, 3, entry.Total)
+	}
+}
+
+func TestHistorySearchShellZero(t *testing.T) {
+	s := &namedShell{kind: "ysh", dir: "/src"}
+	h := &history.History{}
+	h.Add(newFakeCmd("make test", ""), history.Entry{Shell: 0})
+	h.Add(newFakeCmd("make build", ""), history.Entry{Shell: 1})
+
+	var id uint64
+	hs := newHistorySearch(h, s, &id)
+	hs.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	hs.Update(tea.KeyPressMsg{Code: 's', Mod: tea.ModAlt})
+	if assert.Len(t, hs.matches, 1, "only shell #0") {
+		assert.Equal(t, "make test", hs.matches[0].Entry.Command)
+	}
+	assert.Contains(t, hs.View().Content, "shell #0")
+	hs.Update(tea.KeyPressMsg{Code: 's', Mod: tea.ModAlt})
+	assert.Len(t, hs.matches, 2)
+
+	// Not one of ours, nothing to filter by
+	hs = newHistorySearch(h, nil, nil)
+	hs.Update(tea.KeyPressMsg{Code: 's', Mod: tea.ModAlt})
+	assert.Len(t, hs.matches, 2)
+}
+
+func TestBasicPromptSetPrompt(t *testing.T) {
+	s := &namedShell{kind: "ysh", dir: "/src"}
+	bp := newBasicPrompt(s, nil)
+	bp.Update(SetPromptMsg{Text: "make test", Shell: &namedShell{}})
+	assert.Equal(t, "", bp.input.Value(), "not for this prompt")
+	bp.Update(SetPromptMsg{Text: "make test", Shell: s})
+	assert.Equal(t, "make test", bp.input.Value())
+}
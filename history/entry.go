@@ -0,0 +1,136 @@
+package history
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/creack/pty"
+)
+
+// Version of the on-disk format, every entry carries it.
+// Entries of newer versions are skipped when loading.
+const Version = 1
+
+// Entry is a finished command as it's stored on disk.
+type Entry struct {
+	Version int       `json:"v"`
+	Command string    `json:"cmd"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Duration is End - Start, in nanoseconds
+	Duration time.Duration `json:"duration"`
+	// Cwd is where the command started
+	Cwd string `json:"cwd"`
+	// Pid is the oils-readline instance, Shell the shell within it
+	Pid   int    `json:"pid"`
+	Shell uint64 `json:"shell"`
+	// Status is -1 if the command never got one
+	Status   int    `json:"status"`
+	Hostname string `json:"host"`
+	// Output names the stdout and stderr in the OutputStore. They aren't
+	// there if there was none, or not yet, or they were pruned
+	Output string `json:"output,omitempty"`
+	// RerunOf is the ID of the entry this one ran again
+	RerunOf string `json:"rerun_of,omitempty"`
+	// PipedFrom is the ID of the entry whose stdout, or stderr with PipedStderr,
+	// this one read on stdin
+	PipedFrom   string `json:"piped_from,omitempty"`
+	PipedStderr bool   `json:"piped_stderr,omitempty"`
+	// UID is the ID of an entry imported from another shell, see importIDs
+	UID string `json:"id,omitempty"`
+}
+
+// ID names an entry, unique as long as an instance doesn't start two commands
+// in the same shell within a nanosecond.
+func (e Entry) ID() string {
+	if e.UID != "" {
+		return e.UID
+	}
+	return fmt.Sprintf("%d-%d-%d", e.Start.UnixNano(), e.Pid, e.Shell)
+}
+
+// importIDs gives the entries which didn't come from an instance (without a
+// pid) a UID, otherwise all of those without a time would have the same ID.
+// It's made of the time and the command, so it stays the same when they're
+// imported again.
+func importIDs(entries []Entry) {
+	seen := map[string]int{}
+	for i := range entries {
+		e := &entries[i]
+		if e.Pid != 0 || e.UID != "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(e.Command))
+		id := fmt.Sprintf("%d-import-%x", e.Start.UnixNano(), sum[:6])
+		e.UID = id
+		if n := seen[id]; n > 0 {
+			e.UID = fmt.Sprintf("%s-%d", id, n)
+		}
+		seen[id]++
+	}
+}
+
+// Record is a read-only shell.Command for an Entry of an earlier session.
+// Its output is only read from outputs once it's shown.
+type Record struct {
+	Entry Entry
+
+	outputs        *OutputStore
+	load           sync.Once
+	stdout, stderr string
+}
+
+func (r *Record) loadOutput() {
+	r.load.Do(func() {
+		if r.outputs == nil || r.Entry.Output == "" {
+			return
+		}
+		var err error
+		r.stdout, r.stderr, err = r.outputs.Load(r.Entry.Output)
+		if err != nil {
+			log.Printf("Can't load output of %q: %v", r.Entry.Command, err)
+		}
+	})
+}
+
+func (r *Record) Stdout() string {
+	r.loadOutput()
+	return r.stdout
+}
+
+func (r *Record) Stderr() string {
+	r.loadOutput()
+	return r.stderr
+}
+
+func (r *Record) Run()                        {}
+func (r *Record) Wait()                       {}
+func (r *Record) CommandLine() string         { return r.Entry.Command }
+func (r *Record) Stdin() io.Writer            { return io.Discard }
+func (r *Record) SetStdout(io.Reader)         {}
+func (r *Record) SetStdin(io.Writer)          {}
+func (r *Record) SetOnStdout(func())          {}
+func (r *Record) SetOnStderr(func())          {}
+func (r *Record) State() shell.CommandState   { return shell.Stopped }
+func (r *Record) SetState(shell.CommandState) {}
+func (r *Record) Resize(*pty.Winsize) error   { return nil }
+func (r *Record) ExitCode() int               { return r.Entry.Status }
+func (r *Record) Signal(os.Signal) error      { return shell.ErrNotRunning }
+func (r *Record) Interrupt() error            { return shell.ErrNotRunning }
+func (r *Record) Kill() error                 { return shell.ErrNotRunning }
+
+func (r *Record) Err() error {
+	switch {
+	case r.Entry.Status == 0:
+		return nil
+	case r.Entry.Status < 0:
+		return ErrNoStatus
+	}
+	return &shell.ExitError{Status: r.Entry.Status}
+}
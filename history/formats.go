@@ -0,0 +1,242 @@
+package history
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownFormat = errors.New("unknown history format")
+
+// Format of the history file of another shell.
+type Format string
+
+const (
+	// Bash is ~/.bash_history, optionally with #timestamp lines (HISTTIMEFORMAT).
+	// With timestamps, all lines up to the next one belong to a command (lithist).
+	Bash Format = "bash"
+	// Zsh is ~/.zsh_history, optionally with EXTENDED_HISTORY ": start:elapsed;command" lines.
+	Zsh Format = "zsh"
+	// Oils is the readline history of osh and ysh, one command per line.
+	Oils Format = "oils"
+)
+
+var Formats = []Format{Bash, Zsh, Oils}
+
+func ParseFormat(name string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// Import reads a history file of format f, oldest first.
+// Nothing but the command line and maybe the time is known about the entries,
+// their Status is -1.
+func Import(r io.Reader, f Format) ([]Entry, error) {
+	switch f {
+	case Bash:
+		return readBash(r)
+	case Oils:
+		return readOils(r)
+	case Zsh:
+		return readZsh(r)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+}
+
+// Export writes entries in format f, which keeps as much of them as it can.
+func Export(w io.Writer, f Format, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		switch f {
+		case Bash:
+			if !e.Start.IsZero() {
+				fmt.Fprintf(bw, "#%d\n", e.Start.Unix())
+			}
+			fmt.Fprintf(bw, "%s\n", e.Command)
+		case Oils:
+			// readline would read the lines as separate commands
+			fmt.Fprintf(bw, "%s\n", strings.ReplaceAll(e.Command, "\n", " "))
+		case Zsh:
+			if !e.Start.IsZero() {
+				fmt.Fprintf(bw, ": %d:%d;", e.Start.Unix(), int64(e.Duration.Seconds()))
+			}
+			fmt.Fprintf(bw, "%s\n", metafy(strings.ReplaceAll(e.Command, "\n", "\\\n")))
+		default:
+			return fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+		}
+	}
+	return bw.Flush()
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	return scanner
+}
+
+var bashTimestamp = regexp.MustCompile(`^#[0-9]+$`)
+
+func readBash(r io.Reader) ([]Entry, error) {
+	var lines []string
+	timestamps := false
+	scanner := newScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lines = append(lines, line)
+		timestamps = timestamps || bashTimestamp.MatchString(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !timestamps {
+		return plainLines(lines), nil
+	}
+
+	var entries []Entry
+	var e *Entry
+	for _, line := range lines {
+		if bashTimestamp.MatchString(line) {
+			sec, _ := strconv.ParseInt(line[1:], 10, 64)
+			entries = append(entries, Entry{Start: time.Unix(sec, 0), Status: -1})
+			e = &entries[len(entries)-1]
+			continue
+		}
+		if e == nil {
+			// Lines before the first timestamp
+			entries = append(entries, Entry{Command: line, Status: -1})
+			continue
+		}
+		if e.Command != "" {
+			e.Command += "\n"
+		}
+		e.Command += line
+	}
+	// A timestamp without a command
+	return dropEmpty(entries), nil
+}
+
+// readOils reads the readline history of osh and ysh, a command per line.
+// It has no times, so a line like #1700000000 is just a comment that ran.
+func readOils(r io.Reader) ([]Entry, error) {
+	var lines []string
+	scanner := newScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return plainLines(lines), nil
+}
+
+// plainLines makes an entry of each line which isn't blank.
+func plainLines(lines []string) []Entry {
+	var entries []Entry
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			entries = append(entries, Entry{Command: line, Status: -1})
+		}
+	}
+	return entries
+}
+
+var zshExtended = regexp.MustCompile(`^: *([0-9]+):([0-9]+);`)
+
+func readZsh(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var command strings.Builder
+	var e Entry
+	scanner := newScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if command.Len() == 0 {
+			e = Entry{Status: -1}
+			if m := zshExtended.FindStringSubmatch(line); m != nil {
+				start, _ := strconv.ParseInt(m[1], 10, 64)
+				elapsed, _ := strconv.ParseInt(m[2], 10, 64)
+				e.Start = time.Unix(start, 0)
+				e.Duration = time.Duration(elapsed) * time.Second
+				e.End = e.Start.Add(e.Duration)
+				line = line[len(m[0]):]
+			}
+		}
+		// A trailing backslash continues the command on the next line
+		if cont, ok := strings.CutSuffix(line, "\\"); ok {
+			command.WriteString(cont + "\n")
+			continue
+		}
+		command.WriteString(line)
+		e.Command = unmetafy(command.String())
+		entries = append(entries, e)
+		command.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if command.Len() > 0 {
+		e.Command = unmetafy(strings.TrimSuffix(command.String(), "\n"))
+		entries = append(entries, e)
+	}
+	return dropEmpty(entries), nil
+}
+
+func dropEmpty(entries []Entry) []Entry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if strings.TrimSpace(e.Command) != "" {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// zsh escapes some bytes in its history: a meta byte followed by the byte xor 32.
+const zshMeta = 0x83
+
+func zshIsMeta(b byte) bool {
+	return b == 0 || (b >= zshMeta && b <= 0xa2)
+}
+
+func unmetafy(s string) string {
+	if strings.IndexByte(s, zshMeta) < 0 {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == zshMeta && i+1 < len(s) {
+			i++
+			b = append(b, s[i]^32)
+			continue
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+func metafy(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if zshIsMeta(s[i]) {
+			if b == nil {
+				b = append(make([]byte, 0, len(s)+8), s[:i]...)
+			}
+			b = append(b, zshMeta, s[i]^32)
+		} else if b != nil {
+			b = append(b, s[i])
+		}
+	}
+	if b == nil {
+		return s
+	}
+	return string(b)
+}
@@ -0,0 +1,99 @@
+package history
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+		want   []Entry
+	}{
+		{"bash", Bash, "ls -l\n\ncd /tmp\n", []Entry{
+			{Command: "ls -l", Status: -1},
+			{Command: "cd /tmp", Status: -1},
+		}},
+		{"bash with timestamps", Bash, "#1700000000\nfor i in 1 2\ndo echo $i\ndone\n#1700000005\n#1700000010\nls\n", []Entry{
+			{Command: "for i in 1 2\ndo echo $i\ndone", Start: time.Unix(1700000000, 0), Status: -1},
+			{Command: "ls", Start: time.Unix(1700000010, 0), Status: -1},
+		}},
+		{"zsh", Zsh, "ls\n: 1700000000:3;make \\\n  test\n:1700000010:0;echo caf\x83\xa9\n", []Entry{
+			{Command: "ls", Status: -1},
+			{Command: "make \n  test", Start: time.Unix(1700000000, 0), End: time.Unix(1700000003, 0), Duration: 3 * time.Second, Status: -1},
+			{Command: "echo caf\x89", Start: time.Unix(1700000010, 0), End: time.Unix(1700000010, 0), Status: -1},
+		}},
+		{"oils", Oils, "echo hi\nvar x = 1\n", []Entry{
+			{Command: "echo hi", Status: -1},
+			{Command: "var x = 1", Status: -1},
+		}},
+		{"oils with a comment like a bash timestamp", Oils, "echo hi\n#1700000000\nls\n", []Entry{
+			{Command: "echo hi", Status: -1},
+			{Command: "#1700000000", Status: -1},
+			{Command: "ls", Status: -1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Import(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, wanted %+v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) {
+					t.Errorf("%d: got times %v %v", i, got[i].Start, got[i].End)
+				}
+				got[i].Start, got[i].End = tt.want[i].Start, tt.want[i].End
+				if got[i] != tt.want[i] {
+					t.Errorf("%d: got %+v, wanted %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := Import(strings.NewReader(""), "fish"); err == nil {
+		t.Error("unknown formats should fail")
+	}
+}
+
+func TestExport(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	entries := []Entry{
+		{Command: "ls"},
+		{Command: "make \\\n  test", Start: start, Duration: 3 * time.Second},
+		{Command: "echo caf\xc3\xa9"},
+	}
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{Bash, "ls\n#1700000000\nmake \\\n  test\necho café\n"},
+		{Zsh, "ls\n: 1700000000:3;make \\\\\n  test\necho café\n"},
+		{Oils, "ls\nmake \\   test\necho café\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := Export(&b, tt.format, entries); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, wanted %q", tt.format, b.String(), tt.want)
+		}
+	}
+
+	// zsh and bash with timestamps keep multi-line commands
+	for _, format := range []Format{Zsh, Bash} {
+		var b strings.Builder
+		Export(&b, format, []Entry{{Command: "if true\nthen echo é\nfi", Start: start}})
+		got, err := Import(strings.NewReader(b.String()), format)
+		if err != nil || len(got) != 1 || got[0].Command != "if true\nthen echo é\nfi" {
+			t.Errorf("%s: got %+v, %v", format, got, err)
+		}
+	}
+}
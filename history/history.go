@@ -3,6 +3,10 @@ package history
 import (
 	"errors"
 	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
 
 	tea "charm.land/bubbletea/v2"
 	"github.com/Melkor333/oils-readline/shell"
@@ -12,6 +16,8 @@ var (
 	ErrEndOFHistory   = errors.New("End of history Reached")
 	ErrBeginOFHistory = errors.New("Beginning of history Reached")
 	ErrNotFound       = errors.New("Entry not found")
+	ErrNoStatus       = errors.New("command didn't finish")
+	ErrIgnored        = errors.New("ignored by the history policy")
 )
 
 // TODO: Uncomment once it's not in the main module anymore
@@ -40,6 +46,77 @@ func (msg HistoryEntryMsg) TargetWidget() uint64 { return msg.Id }
 type History struct {
 	cc      []shell.Command
 	current int
+
+	// Entries of the commands of this session, completed once they're done.
+	// Finished commands are appended to store, if there is one
+	entries map[shell.Command]Entry
+	store   *Store
+	// Their output too, if there is an output store
+	outputs *OutputStore
+	policy  Policy
+	// For Suggest, built on first use
+	prefixes *prefixIndex
+}
+
+// An Option configures a History opened by Open.
+type Option func(*History)
+
+// WithOutputStore saves the output of commands to o as well.
+// Old outputs beyond its limits are pruned when opening.
+func WithOutputStore(o *OutputStore) Option {
+	return func(h *History) { h.outputs = o }
+}
+
+// WithPolicy applies p to the commands before they're kept.
+func WithPolicy(p Policy) Option {
+	return func(h *History) { h.policy = p }
+}
+
+// New returns a history which only lasts for this session.
+func New(opts ...Option) *History {
+	h := &History{}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Open loads the history of the store at path as Records.
+// Commands added afterwards are appended to it once they're done.
+func Open(path string, opts ...Option) (*History, error) {
+	store, err := NewStore(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &History{store: store}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.outputs != nil {
+		if err := h.outputs.Prune(); err != nil {
+			log.Printf("Can't prune old output: %v", err)
+		}
+	}
+	entries, err := store.Load()
+	for _, e := range entries {
+		h.cc = append(h.cc, &Record{Entry: e, outputs: h.outputs})
+	}
+	h.current = len(h.cc) - 1
+	return h, err
+}
+
+// Seed puts entries, e.g. imported from another shell, before the rest of the
+// history. They are only kept in memory.
+func (h *History) Seed(entries []Entry) {
+	entries = slices.Clone(h.policy.Filter(entries))
+	importIDs(entries)
+	seeded := make([]shell.Command, 0, len(entries)+len(h.cc))
+	for _, e := range entries {
+		seeded = append(seeded, &Record{Entry: e, outputs: h.outputs})
+	}
+	h.cc = append(seeded, h.cc...)
+	h.current += len(entries)
+	h.prefixes = nil
 }
 
 // Do not use `Update` because the signature is different!
@@ -76,6 +153,8 @@ func (h *History) Dispatch(msg tea.Msg) (tea.Msg, tea.Cmd) {
 				Id:    msg.Id,
 			}, nil
 		}
+	case shell.CommandDoneMsg:
+		return msg, h.finish(msg.Cmd)
 	case tea.KeyPressMsg:
 		switch msg.String() {
 		case "esc":
@@ -92,7 +171,7 @@ func (h *History) Dispatch(msg tea.Msg) (tea.Msg, tea.Cmd) {
 				}
 			}
 			// TODO: Always return a ShellHistoryEntry, but with `Id` == -1
-			return shell.CommandMsg{cmd}, nil
+			return shell.CommandMsg{Cmd: cmd}, nil
 		case "ctrl+h":
 			cmd, err := h.Prev()
 			if err != nil {
@@ -102,15 +181,143 @@ func (h *History) Dispatch(msg tea.Msg) (tea.Msg, tea.Cmd) {
 				}
 			}
 			// TODO: Always return a ShellHistoryEntry, but with `Id` == -1
-			return shell.CommandMsg{cmd}, nil
+			return shell.CommandMsg{Cmd: cmd}, nil
 		}
 	}
 	return msg, nil
 }
 
-func (h *History) Add(c shell.Command) error {
-	h.cc = append(h.cc, c)
-	return nil
+// Add appends a command which is about to run, unless the policy ignores it.
+// e describes where it runs, the rest is filled in when it's done. Its
+// Command is the command line, if c runs it wrapped in something else.
+// It returns c as the history shows it, with the secrets of its command line
+// hidden. That's what should be passed around from then on.
+func (h *History) Add(c shell.Command, e Entry) (shell.Command, error) {
+	line, prev := c.CommandLine(), ""
+	if e.Command != "" {
+		line = e.Command
+	}
+	if len(h.cc) > 0 {
+		prev = h.EntryOf(h.cc[len(h.cc)-1]).Command
+	}
+	e.Command = h.policy.RedactString(line)
+	shown := c
+	if e.Command != c.CommandLine() {
+		shown = &redacted{Command: c, commandLine: e.Command, line: line}
+	}
+	if h.policy.ignores(line, prev) {
+		return shown, fmt.Errorf("%w: %q", ErrIgnored, e.Command)
+	}
+	h.cc = append(h.cc, shown)
+
+	if e.Start.IsZero() {
+		e.Start = time.Now()
+	}
+	e.Pid = os.Getpid()
+	e.Status = -1
+	if h.entries == nil {
+		h.entries = map[shell.Command]Entry{}
+	}
+	h.entries[c] = e
+	return shown, nil
+}
+
+// EntryOf describes c, as far as it's known.
+func (h *History) EntryOf(c shell.Command) Entry {
+	switch r := c.(type) {
+	case *Record:
+		return r.Entry
+	case *redacted:
+		c = r.Command
+	}
+	if e, ok := h.entries[c]; ok {
+		return e
+	}
+	return Entry{Command: c.CommandLine(), Status: -1}
+}
+
+// finish completes the entry of a command that is done and stores it.
+// The line is written right away, background jobs may keep the output open
+// for much longer. Output names where it's saved once it's complete.
+func (h *History) finish(c shell.Command) tea.Cmd {
+	c = Unwrap(c)
+	e, ok := h.entries[c]
+	if !ok || !e.End.IsZero() {
+		return nil
+	}
+	e.End = time.Now()
+	e.Duration = e.End.Sub(e.Start)
+	e.Status = c.ExitCode()
+	e.Hostname, _ = os.Hostname()
+	if h.store != nil && h.outputs != nil {
+		e.Output = e.ID()
+	}
+	h.entries[c] = e
+	if h.store == nil {
+		return nil
+	}
+	store, outputs, policy := h.store, h.outputs, h.policy
+	return func() tea.Msg {
+		if err := store.Append(e); err != nil {
+			log.Printf("Can't save history: %v", err)
+		}
+		if outputs == nil {
+			return nil
+		}
+		// The output may still be on its way
+		c.Wait()
+		stdout, stderr := policy.RedactString(c.Stdout()), policy.RedactString(c.Stderr())
+		if err := outputs.Save(e.Output, stdout, stderr); err != nil {
+			log.Printf("Can't save output: %v", err)
+		}
+		return nil
+	}
+}
+
+// Origin returns the index of the entry c ran again, see Entry.RerunOf.
+func (h *History) Origin(c shell.Command) (int, error) {
+	id := h.EntryOf(c).RerunOf
+	if id == "" {
+		return -1, fmt.Errorf("%w: %q isn't a rerun", ErrNotFound, c.CommandLine())
+	}
+	return h.indexOfID(id)
+}
+
+// Source returns the index of the entry whose output c read, see Entry.PipedFrom.
+func (h *History) Source(c shell.Command) (int, error) {
+	id := h.EntryOf(c).PipedFrom
+	if id == "" {
+		return -1, fmt.Errorf("%w: %q wasn't piped", ErrNotFound, c.CommandLine())
+	}
+	return h.indexOfID(id)
+}
+
+func (h *History) indexOfID(id string) (int, error) {
+	for i := len(h.cc) - 1; i >= 0; i-- {
+		if h.EntryOf(h.cc[i]).ID() == id {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: entry %s", ErrNotFound, id)
+}
+
+// PreviousRun returns the index of the run before the entry at i: the one it
+// reran, or else the last one before it with the same command line.
+func (h *History) PreviousRun(i int) (int, error) {
+	c, err := h.AtIndex(i)
+	if err != nil {
+		return -1, err
+	}
+	if o, err := h.Origin(c); err == nil && o < i {
+		return o, nil
+	}
+	line := h.EntryOf(c).Command
+	for j := i - 1; j >= 0; j-- {
+		if h.EntryOf(h.cc[j]).Command == line {
+			return j, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: no run of %q before %d", ErrNotFound, line, i)
 }
 
 func (h *History) Next() (shell.Command, error) {
@@ -123,6 +330,9 @@ func (h *History) Next() (shell.Command, error) {
 
 func (h *History) GetIndexOf(c shell.Command) (int, error) {
 	for i, cmd := range h.cc {
+		if r, ok := cmd.(*redacted); ok && r.Command == c {
+			return i, nil
+		}
 		if cmd == c {
 			return i, nil
 		}
@@ -131,7 +341,7 @@ func (h *History) GetIndexOf(c shell.Command) (int, error) {
 }
 
 func (h *History) AtIndex(i int) (shell.Command, error) {
-	if l := len(h.cc); i >= l {
+	if l := len(h.cc); i >= l || i < 0 {
 		return nil, fmt.Errorf("%w, index %v out of range %v", ErrNotFound, i, l)
 	}
 	return h.cc[i], nil
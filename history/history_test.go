@@ -0,0 +1,128 @@
+package history
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Melkor333/oils-readline/fanos"
+	"github.com/Melkor333/oils-readline/fanos/fanostest"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/creack/pty"
+)
+
+func newTestShell(t *testing.T) shell.Shell {
+	t.Helper()
+	srv := fanostest.NewServer()
+	t.Cleanup(srv.Close)
+	s, err := fanos.New(fanos.WithDialer(srv.Dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Cancel)
+	return s
+}
+
+func run(t *testing.T, s shell.Shell, commandLine string) shell.Command {
+	t.Helper()
+	c, err := s.Command(commandLine, &pty.Winsize{Rows: 10, Cols: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Run()
+	return c
+}
+
+func TestHistoryNavigation(t *testing.T) {
+	s := newTestShell(t)
+	h := &History{}
+
+	if _, err := h.Last(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("empty history should return ErrNotFound, got %v", err)
+	}
+
+	first := run(t, s, "write first")
+	second := run(t, s, "return 1")
+	third := run(t, s, "write third")
+	for _, c := range []shell.Command{first, second, third} {
+		h.Add(c, Entry{})
+	}
+
+	if h.Count() != 3 {
+		t.Fatalf("got %d entries, wanted 3", h.Count())
+	}
+	if last, _ := h.Last(); last != third {
+		t.Errorf("Last should return the newest command, got %v", last.CommandLine())
+	}
+	if i, _ := h.GetIndexOf(second); i != 1 {
+		t.Errorf("got index %d, wanted 1", i)
+	}
+	if c, _ := h.AtIndex(1); c.ExitCode() != 1 {
+		t.Errorf("got exit code %d, wanted 1", c.ExitCode())
+	}
+
+	h.SetCurrent(2)
+	if c, _ := h.Prev(); c != second {
+		t.Errorf("Prev should return the second command, got %v", c.CommandLine())
+	}
+	if c, _ := h.Prev(); c != first {
+		t.Errorf("Prev should return the first command, got %v", c.CommandLine())
+	}
+	if _, err := h.Prev(); !errors.Is(err, ErrBeginOFHistory) {
+		t.Errorf("got %v, wanted ErrBeginOFHistory", err)
+	}
+	if c, _ := h.Next(); c != second {
+		t.Errorf("Next should return the second command, got %v", c.CommandLine())
+	}
+	h.Next()
+	if _, err := h.Next(); !errors.Is(err, ErrEndOFHistory) {
+		t.Errorf("got %v, wanted ErrEndOFHistory", err)
+	}
+	if _, err := h.AtIndex(3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, wanted ErrNotFound", err)
+	}
+}
+
+func TestHistoryEntryMsg(t *testing.T) {
+	s := newTestShell(t)
+	h := &History{}
+	h.Add(run(t, s, "write a"), Entry{})
+	h.Add(run(t, s, "write b"), Entry{})
+
+	msg, _ := h.Dispatch(RequestHistoryEntryMsg{Index: -1, Id: 7})
+	entry, ok := msg.(HistoryEntryMsg)
+	if !ok {
+		t.Fatalf("got %T, wanted HistoryEntryMsg", msg)
+	}
+	if entry.Index != 1 || entry.Total != 2 || entry.Id != 7 {
+		t.Errorf("got %+v", entry)
+	}
+	if entry.Cmd.CommandLine() != "write b" {
+		t.Errorf("got command %q", entry.Cmd.CommandLine())
+	}
+
+	if msg, _ := h.Dispatch(RequestHistoryEntryMsg{Index: 5}); msg != nil {
+		t.Errorf("out of range requests should be swallowed, got %v", msg)
+	}
+}
+
+func TestHistoryPreviousRun(t *testing.T) {
+	s := newTestShell(t)
+	h := &History{}
+	for _, line := range []string{"write a", "write b", "write a", "write c"} {
+		h.Add(run(t, s, line), Entry{})
+	}
+	// A rerun of the first one
+	h.Add(run(t, s, "write a"), Entry{RerunOf: h.EntryOf(h.cc[0]).ID()})
+
+	for i, want := range map[int]int{2: 0, 4: 0} {
+		if got, err := h.PreviousRun(i); got != want || err != nil {
+			t.Errorf("PreviousRun(%d) = %d, %v, wanted %d", i, got, err, want)
+		}
+	}
+	if _, err := h.PreviousRun(3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, wanted ErrNotFound", err)
+	}
+	if _, err := h.PreviousRun(-1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, wanted ErrNotFound", err)
+	}
+}
@@ -0,0 +1,169 @@
+package history
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OutputStore keeps the stdout and stderr of commands as gzipped files in a
+// directory, next to the history file.
+// Empty outputs aren't written, a missing file just means there was none.
+type OutputStore struct {
+	dir string
+
+	// MaxSize caps each output, only its end is kept. 0 means no limit
+	MaxSize int64
+	// Prune removes outputs older than MaxAge, and then the oldest ones until
+	// all of them together fit into MaxTotal. 0 means no limit
+	MaxAge   time.Duration
+	MaxTotal int64
+}
+
+// NewOutputStore keeps outputs in dir, creating it if needed.
+func NewOutputStore(dir string) (*OutputStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &OutputStore{dir: dir}, nil
+}
+
+func (o *OutputStore) Dir() string {
+	return o.dir
+}
+
+func (o *OutputStore) path(id, stream string) string {
+	return filepath.Join(o.dir, id+"."+stream+".gz")
+}
+
+// Save writes the outputs of the command with id.
+func (o *OutputStore) Save(id, stdout, stderr string) error {
+	return errors.Join(
+		o.save(o.path(id, "stdout"), stdout),
+		o.save(o.path(id, "stderr"), stderr),
+	)
+}
+
+func (o *OutputStore) save(path, output string) error {
+	if output == "" {
+		return nil
+	}
+	if o.MaxSize > 0 && int64(len(output)) > o.MaxSize {
+		output = output[int64(len(output))-o.MaxSize:]
+		// Don't start in the middle of a line
+		if i := strings.IndexByte(output, '\n'); i >= 0 && i < len(output)-1 {
+			output = output[i+1:]
+		}
+	}
+
+	// Written to a temporary file first, so a crash never leaves half a file
+	f, err := os.CreateTemp(o.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	zw := gzip.NewWriter(f)
+	_, err = io.WriteString(zw, output)
+	err = errors.Join(err, zw.Close(), f.Close())
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load reads the outputs of the command with id.
+func (o *OutputStore) Load(id string) (stdout, stderr string, err error) {
+	stdout, err1 := o.load(o.path(id, "stdout"))
+	stderr, err2 := o.load(o.path(id, "stderr"))
+	return stdout, stderr, errors.Join(err1, err2)
+}
+
+func (o *OutputStore) load(path string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Never written or pruned since
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return string(b), nil
+}
+
+// staleTemp is how long a temporary file of Save may be around. Older ones
+// were left by a crash, younger ones may be written by another instance.
+const staleTemp = time.Hour
+
+// Prune removes outputs beyond MaxAge and MaxTotal, oldest first, and
+// temporary files left by a crash in Save.
+func (o *OutputStore) Prune() error {
+	dirEntries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+	var files []fs.FileInfo
+	var total int64
+	var errs []error
+	for _, de := range dirEntries {
+		fi, err := de.Info()
+		if err != nil {
+			// Removed by another instance
+			continue
+		}
+		if strings.HasPrefix(de.Name(), ".tmp-") {
+			if time.Since(fi.ModTime()) > staleTemp {
+				errs = append(errs, o.remove(de.Name()))
+			}
+			continue
+		}
+		if !strings.HasSuffix(de.Name(), ".gz") {
+			continue
+		}
+		files = append(files, fi)
+		total += fi.Size()
+	}
+	if o.MaxAge <= 0 && o.MaxTotal <= 0 {
+		return errors.Join(errs...)
+	}
+	slices.SortFunc(files, func(a, b fs.FileInfo) int { return a.ModTime().Compare(b.ModTime()) })
+
+	cutoff := time.Now().Add(-o.MaxAge)
+	for _, fi := range files {
+		old := o.MaxAge > 0 && fi.ModTime().Before(cutoff)
+		tooMuch := o.MaxTotal > 0 && total > o.MaxTotal
+		if !old && !tooMuch {
+			break
+		}
+		if err := o.remove(fi.Name()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		total -= fi.Size()
+	}
+	return errors.Join(errs...)
+}
+
+// remove removes the file name, unless another instance was faster.
+func (o *OutputStore) remove(name string) error {
+	err := os.Remove(filepath.Join(o.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
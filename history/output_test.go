@@ -0,0 +1,192 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+func TestOutputStore(t *testing.T) {
+	o, err := NewOutputStore(filepath.Join(t.TempDir(), "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.MaxSize = 10
+
+	if err := o.Save("a", "line 1\nline 2\nend\n", ""); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err := o.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only whole lines of the end fit
+	if stdout != "end\n" || stderr != "" {
+		t.Errorf("got %q and %q", stdout, stderr)
+	}
+	if _, err := os.Stat(o.path("a", "stderr")); !os.IsNotExist(err) {
+		t.Errorf("empty output shouldn't be written: %v", err)
+	}
+
+	if stdout, stderr, err := o.Load("missing"); stdout != "" || stderr != "" || err != nil {
+		t.Errorf("got %q, %q and %v", stdout, stderr, err)
+	}
+}
+
+func TestOutputStorePrune(t *testing.T) {
+	o, err := NewOutputStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := strings.Repeat("x", 1000)
+	for i, id := range []string{"ancient", "old", "new", "newest"} {
+		if err := o.Save(id, out, ""); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Duration(i-3) * time.Hour)
+		if id == "ancient" {
+			mtime = time.Now().Add(-48 * time.Hour)
+		}
+		os.Chtimes(o.path(id, "stdout"), mtime, mtime)
+	}
+	fi, _ := os.Stat(o.path("new", "stdout"))
+
+	o.MaxAge = 24 * time.Hour
+	o.MaxTotal = 2 * fi.Size()
+	if err := o.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	for id, kept := range map[string]bool{"ancient": false, "old": false, "new": true, "newest": true} {
+		if stdout, _, _ := o.Load(id); (stdout != "") != kept {
+			t.Errorf("%s: kept %v, wanted %v", id, !kept, kept)
+		}
+	}
+}
+
+func TestHistoryOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	o, err := NewOutputStore(filepath.Join(dir, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := Open(path, WithOutputStore(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestShell(t)
+	for _, command := range []string{"echo built\necho warning >&2", "true"} {
+		c := run(t, s, command)
+		h.Add(c, Entry{})
+		_, cmd := h.Dispatch(shell.CommandDoneMsg{Cmd: c})
+		cmd()
+	}
+
+	h, err = Open(path, WithOutputStore(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := h.cc[0].(*Record)
+	if built.Entry.Output == "" {
+		t.Fatal("output wasn't saved")
+	}
+	if built.stdout != "" {
+		t.Error("output should only be loaded when needed")
+	}
+	if !strings.Contains(built.Stdout(), "built") || !strings.Contains(built.Stderr(), "warning") {
+		t.Errorf("got %q and %q", built.Stdout(), built.Stderr())
+	}
+	if r := h.cc[1].(*Record); r.Stdout() != "" || r.Stderr() != "" {
+		t.Errorf("nothing to save for %+v", r.Entry)
+	}
+	if entries, _ := os.ReadDir(o.Dir()); len(entries) != 2 {
+		t.Errorf("only the output of the first is saved, got %v", entries)
+	}
+
+	// Without an output store it's just not there
+	h, _ = Open(path)
+	if stdout := h.cc[0].Stdout(); stdout != "" {
+		t.Errorf("got %q", stdout)
+	}
+}
+
+// lingering is a command that stopped, but a background job it started still
+// holds its output open.
+type lingering struct {
+	*Record
+	closed chan struct{}
+}
+
+func (c *lingering) Wait() { <-c.closed }
+
+func TestHistoryOutputLingering(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	o, err := NewOutputStore(filepath.Join(dir, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := Open(path, WithOutputStore(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &lingering{Record: &Record{Entry: Entry{Command: "sleep 100 &"}}, closed: make(chan struct{})}
+	c.stdout = "started"
+	c.load.Do(func() {})
+	h.Add(c, Entry{})
+	_, cmd := h.Dispatch(shell.CommandDoneMsg{Cmd: c})
+	done := make(chan struct{})
+	go func() {
+		cmd()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, _ := h.store.Load()
+		if len(entries) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the entry is only written once the output is closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(c.closed)
+	<-done
+	h, err = Open(path, WithOutputStore(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout := h.cc[0].Stdout(); stdout != "started" {
+		t.Errorf("got %q", stdout)
+	}
+}
+
+func TestOutputStorePruneTemp(t *testing.T) {
+	o, err := NewOutputStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".tmp-crashed", ".tmp-writing"} {
+		if err := os.WriteFile(filepath.Join(o.Dir(), name), []byte("half"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTemp)
+	os.Chtimes(filepath.Join(o.Dir(), ".tmp-crashed"), old, old)
+
+	// Even without limits
+	if err := o.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(o.Dir())
+	if len(entries) != 1 || entries[0].Name() != ".tmp-writing" {
+		t.Errorf("got %v, wanted only .tmp-writing", entries)
+	}
+}
@@ -0,0 +1,140 @@
+package history
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// Redacted replaces the secrets hidden by a Policy.
+const Redacted = "[REDACTED]"
+
+// DefaultRedact finds common secrets: the values of variables and flags named
+// like one, Authorization headers, passwords in URLs and well known token shapes.
+// Only the capture groups are redacted.
+var DefaultRedact = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b[A-Z0-9_]*(?:TOKEN|SECRET|PASSWORD|PASSWD|API_?KEY|ACCESS_KEY|PRIVATE_KEY)[A-Z0-9_]*=("[^"]*"|'[^']*'|\S+)`),
+	regexp.MustCompile(`(?i)--(?:password|passwd|token|secret|api-?key)(?:=|\s+)("[^"]*"|'[^']*'|\S+)`),
+	regexp.MustCompile(`(?i)\b(?:Proxy-)?Authorization:\s*(?:(?:Bearer|Basic|Token)\s+)?([^\s'"]+)`),
+	regexp.MustCompile(`://[^/\s:@]+:([^/\s@]+)@`),
+	regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,}|glpat-[A-Za-z0-9_-]{20,}|xox[abprs]-[A-Za-z0-9-]{10,}|AKIA[0-9A-Z]{16}|sk-[A-Za-z0-9_-]{20,})\b`),
+}
+
+// Policy decides what the history keeps of a command, before anything is
+// saved or shown. The zero value keeps everything verbatim.
+type Policy struct {
+	// IgnoreSpace drops commands starting with a space, like HISTCONTROL=ignorespace
+	IgnoreSpace bool
+	// IgnoreDups drops a command equal to the one right before it
+	IgnoreDups bool
+	// Ignore drops commands matching any of these, like HISTIGNORE
+	Ignore []*regexp.Regexp
+	// Redact replaces the matches, or their capture groups if they have some, with Redacted
+	Redact []*regexp.Regexp
+}
+
+// DefaultPolicy ignores commands starting with a space and redacts DefaultRedact.
+func DefaultPolicy() Policy {
+	return Policy{IgnoreSpace: true, Redact: DefaultRedact}
+}
+
+// ignores says whether command is dropped, prev is the (redacted) one before it.
+func (p *Policy) ignores(command, prev string) bool {
+	if p.IgnoreSpace && strings.HasPrefix(command, " ") {
+		return true
+	}
+	if p.IgnoreDups && p.RedactString(command) == prev {
+		return true
+	}
+	for _, re := range p.Ignore {
+		if re.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactString hides the secrets in s.
+func (p *Policy) RedactString(s string) string {
+	for _, re := range p.Redact {
+		s = redact(re, s)
+	}
+	return s
+}
+
+func redact(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		spans := m[2:]
+		if len(spans) == 0 {
+			spans = m[:2]
+		}
+		for i := 0; i < len(spans); i += 2 {
+			start, end := spans[i], spans[i+1]
+			// Unmatched optional group, or nested in the one before
+			if start < last || start == end {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(Redacted)
+			last = end
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// Filter applies the policy to entries from elsewhere, e.g. an import.
+func (p *Policy) Filter(entries []Entry) []Entry {
+	var kept []Entry
+	prev := ""
+	for _, e := range entries {
+		if p.ignores(e.Command, prev) {
+			continue
+		}
+		e.Command = p.RedactString(e.Command)
+		prev = e.Command
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// CommandLine returns the command line of c as it was entered, including
+// what the policy hides. It's false if that's gone, like for the Records of
+// earlier sessions.
+func CommandLine(c shell.Command) (string, bool) {
+	if r, ok := c.(*redacted); ok {
+		return r.line, true
+	}
+	line := c.CommandLine()
+	return line, !strings.Contains(line, Redacted)
+}
+
+// Unwrap returns the command which actually runs c, for what the history
+// hides it in.
+func Unwrap(c shell.Command) shell.Command {
+	if r, ok := c.(*redacted); ok {
+		return r.Command
+	}
+	return c
+}
+
+// redacted shows a command of this session with the secrets of its command
+// line hidden, and without what it ran wrapped in, see Add.
+type redacted struct {
+	shell.Command
+	commandLine string
+	// As it was entered
+	line string
+}
+
+func (r *redacted) CommandLine() string { return r.commandLine }
+
+// Unwrap returns the command which actually runs.
+func (r *redacted) Unwrap() shell.Command { return r.Command }
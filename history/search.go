@@ -0,0 +1,98 @@
+package history
+
+import (
+	"os"
+	"slices"
+
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/sahilm/fuzzy"
+)
+
+// How much being recent (at most) or in the same directory adds to the score
+// of a fuzzy match. A few matched characters in a row weigh about the same.
+const (
+	recentBonus = 20
+	cwdBonus    = 10
+)
+
+// SearchFilter narrows a Search, the zero value keeps everything.
+type SearchFilter struct {
+	// Only commands which exited with a non-zero status
+	Failed bool
+	// Only commands of this shell of this instance, if not nil
+	Shell *uint64
+	// Only commands started in this directory, if not ""
+	Cwd string
+}
+
+func (f SearchFilter) keep(e Entry, pid int) bool {
+	switch {
+	case f.Failed && e.Status <= 0:
+		return false
+	case f.Shell != nil && (e.Shell != *f.Shell || e.Pid != pid):
+		return false
+	case f.Cwd != "" && e.Cwd != f.Cwd:
+		return false
+	}
+	return true
+}
+
+// Match is a command found by Search.
+type Match struct {
+	Cmd   shell.Command
+	Entry Entry
+	// Index in the history, see AtIndex
+	Index int
+	// Of the characters of the command line that matched
+	MatchedIndexes []int
+	Score          int
+}
+
+// candidates are the entries kept by a search, newest first
+type candidates []Match
+
+func (c candidates) String(i int) string { return c[i].Entry.Command }
+func (c candidates) Len() int            { return len(c) }
+
+// Search finds the commands matching query fuzzily, best first.
+// Recent commands and those started in cwd rank higher. Only the newest of
+// identical command lines is kept.
+func (h *History) Search(query string, f SearchFilter, cwd string) []Match {
+	pid := os.Getpid()
+	seen := map[string]bool{}
+	var cands candidates
+	for i := len(h.cc) - 1; i >= 0; i-- {
+		e := h.EntryOf(h.cc[i])
+		if seen[e.Command] || !f.keep(e, pid) {
+			continue
+		}
+		seen[e.Command] = true
+		cands = append(cands, Match{Cmd: h.cc[i], Entry: e, Index: i})
+	}
+
+	bonus := func(m Match) int {
+		score := recentBonus * (m.Index + 1) / len(h.cc)
+		if cwd != "" && m.Entry.Cwd == cwd {
+			score += cwdBonus
+		}
+		return score
+	}
+
+	var matches []Match
+	if query == "" {
+		matches = cands
+		for i := range matches {
+			matches[i].Score = bonus(matches[i])
+		}
+	} else {
+		for _, fm := range fuzzy.FindFromNoSort(query, cands) {
+			m := cands[fm.Index]
+			m.MatchedIndexes = fm.MatchedIndexes
+			m.Score = fm.Score + bonus(m)
+			matches = append(matches, m)
+		}
+	}
+	// Stable, so equal scores stay newest first
+	slices.SortStableFunc(matches, func(a, b Match) int { return b.Score - a.Score })
+	return matches
+}
@@ -0,0 +1,116 @@
+package history
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// newSearchHistory has a Record for each entry, oldest first.
+func newSearchHistory(entries ...Entry) *History {
+	h := &History{}
+	for _, e := range entries {
+		h.cc = append(h.cc, &Record{Entry: e})
+	}
+	return h
+}
+
+func commands(matches []Match) []string {
+	var cmds []string
+	for _, m := range matches {
+		cmds = append(cmds, m.Entry.Command)
+	}
+	return cmds
+}
+
+func TestSearch(t *testing.T) {
+	h := newSearchHistory(
+		Entry{Command: "make test", Cwd: "/a"},
+		Entry{Command: "git status", Cwd: "/a"},
+		Entry{Command: "make build", Cwd: "/b"},
+		Entry{Command: "git status", Cwd: "/b"},
+	)
+
+	got := h.Search("", SearchFilter{}, "")
+	if want := []string{"git status", "make build", "make test"}; !slices.Equal(commands(got), want) {
+		t.Errorf("got %q, wanted the newest of each first %q", commands(got), want)
+	}
+	if got[0].Index != 3 {
+		t.Errorf("got index %d of the newest git status, wanted 3", got[0].Index)
+	}
+
+	got = h.Search("mk", SearchFilter{}, "")
+	if want := []string{"make build", "make test"}; !slices.Equal(commands(got), want) {
+		t.Errorf("got %q, wanted %q", commands(got), want)
+	}
+	if want := []int{0, 2}; !slices.Equal(got[0].MatchedIndexes, want) {
+		t.Errorf("got matched indexes %v, wanted %v", got[0].MatchedIndexes, want)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	entries := []Entry{
+		{Command: "echo one", Cwd: "/a"},
+		{Command: "echo two", Cwd: "/b"},
+	}
+	// Older commands so one and two are about as recent
+	for range 10 {
+		entries = append([]Entry{{Command: "true"}}, entries...)
+	}
+	h := newSearchHistory(entries...)
+
+	if got := h.Search("echo", SearchFilter{}, ""); commands(got)[0] != "echo two" {
+		t.Errorf("recent commands should be first, got %q", commands(got))
+	}
+	if got := h.Search("echo", SearchFilter{}, "/a"); commands(got)[0] != "echo one" {
+		t.Errorf("commands of this directory should be first, got %q", commands(got))
+	}
+	// A much better match wins anyway
+	if got := h.Search("two", SearchFilter{}, "/a"); len(got) != 1 || got[0].Entry.Command != "echo two" {
+		t.Errorf("got %q", commands(got))
+	}
+}
+
+func shellID(id uint64) *uint64 { return &id }
+
+func TestSearchFilter(t *testing.T) {
+	pid := os.Getpid()
+	h := newSearchHistory(
+		Entry{Command: "false", Status: 1, Cwd: "/a", Pid: pid, Shell: 1},
+		Entry{Command: "true", Status: 0, Cwd: "/a", Pid: pid, Shell: 2},
+		Entry{Command: "crashed", Status: -1, Cwd: "/b", Pid: pid, Shell: 1},
+		Entry{Command: "elsewhere", Status: 2, Cwd: "/b", Pid: pid + 1, Shell: 1},
+		Entry{Command: "first", Status: 0, Cwd: "/c", Pid: pid, Shell: 0},
+	)
+
+	tests := []struct {
+		filter SearchFilter
+		want   []string
+	}{
+		{SearchFilter{Failed: true}, []string{"elsewhere", "false"}},
+		{SearchFilter{Shell: shellID(1)}, []string{"crashed", "false"}},
+		{SearchFilter{Shell: shellID(0)}, []string{"first"}},
+		{SearchFilter{Cwd: "/a"}, []string{"true", "false"}},
+		{SearchFilter{Failed: true, Cwd: "/b"}, []string{"elsewhere"}},
+	}
+	for _, tt := range tests {
+		if got := commands(h.Search("", tt.filter, "")); !slices.Equal(got, tt.want) {
+			t.Errorf("%+v: got %q, wanted %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestSearchThisSession(t *testing.T) {
+	s := newTestShell(t)
+	h := &History{}
+	c := run(t, s, "exit 4")
+	h.Add(c, Entry{Cwd: "/a", Shell: 1})
+	h.Dispatch(shell.CommandDoneMsg{Cmd: c})
+
+	got := h.Search("exit", SearchFilter{Failed: true, Shell: shellID(1), Cwd: "/a"}, "")
+	if len(got) != 1 || got[0].Cmd != c || got[0].Entry.Status != 4 {
+		t.Errorf("got %+v", got)
+	}
+}
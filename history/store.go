@@ -0,0 +1,161 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Store is a history file with one JSON Entry per line.
+// Several instances may append to it at the same time.
+type Store struct {
+	path string
+}
+
+// DefaultPath is history.jsonl in $XDG_STATE_HOME/oils-readline, or ~/.local/state/oils-readline.
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "oils-readline", "history.jsonl"), nil
+}
+
+// NewStore uses the history file at path, creating its directory if needed.
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &Store{path: path}, nil
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append adds e to the end of the file.
+func (s *Store) Append(e Entry) error {
+	return s.AppendAll([]Entry{e})
+}
+
+// AppendAll adds entries to the end of the file.
+// The lines are written at once while holding an exclusive lock, so lines of
+// concurrent instances never interleave.
+func (s *Store) AppendAll(entries []Entry) error {
+	var lines []byte
+	for _, e := range entries {
+		e.Version = Version
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lock(f, unix.LOCK_EX); err != nil {
+		return err
+	}
+	defer lock(f, unix.LOCK_UN)
+	_, err = f.Write(lines)
+	return err
+}
+
+// Load returns all entries, oldest first.
+// Lines which can't be read (e.g. cut off by a crash, or from a newer
+// version) are skipped.
+func (s *Store) Load() ([]Entry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := lock(f, unix.LOCK_SH); err != nil {
+		return nil, err
+	}
+	defer lock(f, unix.LOCK_UN)
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Printf("%s:%d: skipping broken entry: %v", s.path, n, err)
+			continue
+		}
+		if e.Version < 1 || e.Version > Version {
+			log.Printf("%s:%d: skipping entry of version %d", s.path, n, e.Version)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("can't read %s: %w", s.path, err)
+	}
+	return entries, nil
+}
+
+// Import appends the entries which aren't in the file yet, see AppendAll.
+// An entry is there if one with the same command started at the same second,
+// so of the entries without a time only one per command is kept.
+// Entries of other shells get an ID of their own, see importIDs.
+// It returns how many were added.
+func (s *Store) Import(entries []Entry) (int, error) {
+	existing, err := s.Load()
+	if err != nil {
+		return 0, err
+	}
+	type key struct {
+		start   int64
+		command string
+	}
+	seen := map[key]bool{}
+	for _, e := range existing {
+		seen[key{e.Start.Unix(), e.Command}] = true
+	}
+	var added []Entry
+	for _, e := range entries {
+		k := key{e.Start.Unix(), e.Command}
+		if !seen[k] {
+			seen[k] = true
+			added = append(added, e)
+		}
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	importIDs(added)
+	return len(added), s.AppendAll(added)
+}
+
+// lock calls flock, retrying when interrupted.
+func lock(f *os.File, how int) error {
+	for {
+		err := unix.Flock(int(f.Fd()), how)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "history.jsonl")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := s.Load(); err != nil || len(entries) != 0 {
+		t.Errorf("missing file should be empty, got %v %v", entries, err)
+	}
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := Entry{Command: "ls\n-l", Start: start, End: start.Add(time.Second), Duration: time.Second, Cwd: "/src", Pid: 42, Shell: 1, Status: 2, Hostname: "box"}
+	if err := s.Append(want); err != nil {
+		t.Fatal(err)
+	}
+	// A line cut off by a crash, and one from the future
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	fmt.Fprintf(f, "{\"v\":1,\"cmd\":\"ec\n{\"v\":%d,\"cmd\":\"new\"}\n", Version+1)
+	f.Close()
+	if err := s.Append(Entry{Command: "true"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, wanted 2: %+v", len(entries), entries)
+	}
+	want.Version = Version
+	if !entries[0].Start.Equal(want.Start) || !entries[0].End.Equal(want.End) {
+		t.Errorf("got times %v %v", entries[0].Start, entries[0].End)
+	}
+	entries[0].Start, entries[0].End = want.Start, want.End
+	if entries[0] != want {
+		t.Errorf("got %+v, wanted %+v", entries[0], want)
+	}
+	if entries[1].Command != "true" {
+		t.Errorf("got %q", entries[1].Command)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Errorf("history should be private, got %v", fi.Mode())
+	}
+}
+
+func TestStoreConcurrentAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			// Separate stores, like separate instances
+			s, _ := NewStore(path)
+			for j := range 50 {
+				if err := s.Append(Entry{Command: fmt.Sprintf("%d-%d", i, j)}); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	s, _ := NewStore(path)
+	entries, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 500 {
+		t.Errorf("got %d entries, wanted 500", len(entries))
+	}
+}
+
+func TestHistoryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestShell(t)
+	c := run(t, s, "return 3")
+	h.Add(c, Entry{Cwd: "/src", Shell: 2})
+	_, cmd := h.Dispatch(shell.CommandDoneMsg{Cmd: c})
+	if cmd == nil {
+		t.Fatal("finished commands should be saved")
+	}
+	cmd()
+
+	h, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := h.Dispatch(RequestHistoryEntryMsg{Index: -1})
+	entry := msg.(HistoryEntryMsg)
+	r, ok := entry.Cmd.(*Record)
+	if !ok {
+		t.Fatalf("got %T, wanted *Record", entry.Cmd)
+	}
+	if r.CommandLine() != "return 3" || r.Entry.Cwd != "/src" || r.Entry.Shell != 2 || r.Entry.Pid != os.Getpid() {
+		t.Errorf("got %+v", r.Entry)
+	}
+	if r.Entry.Duration <= 0 || r.Entry.End.Before(r.Entry.Start) || r.Entry.Hostname == "" {
+		t.Errorf("got %+v", r.Entry)
+	}
+	var exitErr *shell.ExitError
+	if r.ExitCode() != 3 || !errors.As(r.Err(), &exitErr) || r.State() != shell.Stopped {
+		t.Errorf("got status %d, err %v, state %v", r.ExitCode(), r.Err(), r.State())
+	}
+	if err := r.Interrupt(); !errors.Is(err, shell.ErrNotRunning) {
+		t.Errorf("records can't be signaled, got %v", err)
+	}
+}
+
+func TestStoreImport(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []Entry{
+		{Command: "ls", Start: time.Unix(1700000000, 0)},
+		{Command: "ls", Start: time.Unix(1700000010, 0)},
+		{Command: "make"},
+		{Command: "make"},
+		{Command: "make test"},
+	}
+	if added, err := s.Import(entries); added != 4 || err != nil {
+		t.Errorf("got %d, %v, wanted 4 added", added, err)
+	}
+	loaded, _ := s.Load()
+	ids := map[string]bool{}
+	for _, e := range loaded {
+		ids[e.ID()] = true
+	}
+	if len(ids) != len(loaded) {
+		t.Errorf("IDs aren't unique: %v", ids)
+	}
+	// Importing again adds nothing
+	if added, err := s.Import(entries); added != 0 || err != nil {
+		t.Errorf("got %d, %v, wanted nothing added", added, err)
+	}
+	if entries[2].UID != "" {
+		t.Error("the entries passed in were changed")
+	}
+}
+
+func TestHistorySeed(t *testing.T) {
+	s := newTestShell(t)
+	h := &History{}
+	c := run(t, s, "echo now")
+	h.Add(c, Entry{})
+	h.Seed([]Entry{{Command: "echo before"}})
+
+	if h.Count() != 2 {
+		t.Fatalf("got %d entries", h.Count())
+	}
+	if first, _ := h.AtIndex(0); first.CommandLine() != "echo before" {
+		t.Errorf("seeded entries should come first, got %q", first.CommandLine())
+	}
+	if prev, _ := h.Prev(); prev.CommandLine() != "echo before" {
+		t.Errorf("got %q", prev.CommandLine())
+	}
+}
+
+func TestHistorySeedIDs(t *testing.T) {
+	h := &History{}
+	h.Seed([]Entry{{Command: "make"}, {Command: "ls"}, {Command: "make"}})
+	for i := range 3 {
+		c, _ := h.AtIndex(i)
+		if got, err := h.indexOfID(h.EntryOf(c).ID()); got != i || err != nil {
+			t.Errorf("entry %d is found at %d, %v", i, got, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"slices"
+	"strings"
+)
+
+// prefixIndex has the distinct command lines of a history sorted, so those
+// starting with a prefix are next to each other.
+type prefixIndex struct {
+	lines []string
+	// Where each line is in the history, oldest first
+	runs map[string][]int
+	// How many commands of the history are in it
+	indexed int
+}
+
+// update adds the commands added to h since the last time.
+func (p *prefixIndex) update(h *History) {
+	for i := p.indexed; i < len(h.cc); i++ {
+		line := h.EntryOf(h.cc[i]).Command
+		if _, ok := p.runs[line]; !ok {
+			j, _ := slices.BinarySearch(p.lines, line)
+			p.lines = slices.Insert(p.lines, j, line)
+		}
+		p.runs[line] = append(p.runs[line], i)
+	}
+	p.indexed = len(h.cc)
+}
+
+// withPrefix returns the lines starting with prefix.
+func (p *prefixIndex) withPrefix(prefix string) []string {
+	start, _ := slices.BinarySearch(p.lines, prefix)
+	end := start
+	for end < len(p.lines) && strings.HasPrefix(p.lines[end], prefix) {
+		end++
+	}
+	return p.lines[start:end]
+}
+
+// Suggest returns the command line prefix is most likely the start of, or ""
+// if there is none. Commands which ran in cwd and succeeded are preferred,
+// then those which succeeded, then those of cwd, the newest of each first.
+func (h *History) Suggest(prefix, cwd string) string {
+	if prefix == "" {
+		return ""
+	}
+	// Seed puts entries in front, then the indexes are off
+	if h.prefixes == nil || h.prefixes.indexed > len(h.cc) {
+		h.prefixes = &prefixIndex{runs: map[string][]int{}}
+	}
+	h.prefixes.update(h)
+
+	best, bestRank, bestIndex := "", -1, -1
+	for _, line := range h.prefixes.withPrefix(prefix) {
+		// Nothing to add, or not what the user typed
+		if line == prefix || strings.Contains(line, Redacted) {
+			continue
+		}
+		runs := h.prefixes.runs[line]
+		for k := len(runs) - 1; k >= 0; k-- {
+			i := runs[k]
+			e := h.EntryOf(h.cc[i])
+			rank := 0
+			if e.Status == 0 {
+				rank += 2
+			}
+			if cwd != "" && e.Cwd == cwd {
+				rank++
+			}
+			if rank > bestRank || rank == bestRank && i > bestIndex {
+				best, bestRank, bestIndex = line, rank, i
+			}
+			if rank == 3 {
+				// Older runs can't do better
+				break
+			}
+		}
+	}
+	return best
+}
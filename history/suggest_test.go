@@ -0,0 +1,61 @@
+package history
+
+import "testing"
+
+func TestSuggest(t *testing.T) {
+	h := newSearchHistory(
+		Entry{Command: "make test", Cwd: "/a", Status: 0},
+		Entry{Command: "make build", Cwd: "/b", Status: 0},
+		Entry{Command: "make lint", Cwd: "/a", Status: 2},
+		Entry{Command: "git push", Cwd: "/a", Status: 0},
+		Entry{Command: "make", Cwd: "/a", Status: 0},
+		Entry{Command: "curl -H 'Authorization: [REDACTED]'", Status: 0},
+	)
+
+	for _, tt := range []struct {
+		prefix, cwd, want string
+	}{
+		{"make ", "/a", "make test"},
+		{"make ", "/b", "make build"},
+		{"make ", "", "make build"},
+		{"make l", "/a", "make lint"},
+		{"g", "/b", "git push"},
+		{"make test", "/a", ""},
+		{"cu", "", ""},
+		{"", "/a", ""},
+		{"x", "/a", ""},
+	} {
+		if got := h.Suggest(tt.prefix, tt.cwd); got != tt.want {
+			t.Errorf("Suggest(%q, %q) = %q, wanted %q", tt.prefix, tt.cwd, got, tt.want)
+		}
+	}
+
+	// Added and seeded commands count too
+	h.cc = append(h.cc, &Record{Entry: Entry{Command: "make build", Cwd: "/a"}})
+	if got := h.Suggest("make ", "/a"); got != "make build" {
+		t.Errorf("got %q after adding make build in /a", got)
+	}
+	h.Seed([]Entry{{Command: "docker ps"}})
+	if got := h.Suggest("do", ""); got != "docker ps" {
+		t.Errorf("got %q after seeding", got)
+	}
+	if got := h.Suggest("make ", "/a"); got != "make build" {
+		t.Errorf("got %q after seeding", got)
+	}
+}
+
+func TestSuggestRunning(t *testing.T) {
+	s := newTestShell(t)
+	h := &History{}
+	c := run(t, s, "write hello")
+	h.Add(c, Entry{Cwd: "/"})
+	h.Add(run(t, s, "write help"), Entry{Cwd: "/"})
+	// Neither is finished, so neither succeeded
+	if got := h.Suggest("write h", "/"); got != "write help" {
+		t.Errorf("got %q", got)
+	}
+	h.finish(c)
+	if got := h.Suggest("write h", "/"); got != "write hello" {
+		t.Errorf("got %q once it succeeded", got)
+	}
+}
@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	//"encoding/json"
 	"flag"
@@ -30,7 +32,9 @@ import (
 
 	"log"
 
+	"github.com/Melkor333/oils-readline/execsh"
 	"github.com/Melkor333/oils-readline/fanos"
+	"github.com/Melkor333/oils-readline/history"
 	"github.com/Melkor333/oils-readline/shell"
 	"github.com/Melkor333/oils-readline/tiling"
 )
@@ -39,28 +43,75 @@ var Version = "devel"
 
 var (
 	versionFlag = flag.Bool("version", false, "Print version and exit")
+	backendFlag = flag.String("backend", "fanos", "How to run commands: fanos (a headless oils, see -oil_path) or exec (sh -c per command, see -exec_shell)")
+	historyFlag = flag.String("history", "", "History file (default $XDG_STATE_HOME/oils-readline/history.jsonl)")
+	seedFlag    = flag.String("seed_history", "", "Also show the history of other shells, without saving it: FORMAT:FILE,... (formats: bash, zsh, oils)")
+
+	saveOutputFlag     = flag.Bool("save_output", false, "Also save the output of commands, in output/ next to the -history file")
+	outputMaxSizeFlag  = flag.Int64("output_max_size", 1<<20, "Only save the last bytes of each output")
+	outputMaxAgeFlag   = flag.Duration("output_max_age", 30*24*time.Hour, "Remove saved output after this long")
+	outputMaxTotalFlag = flag.Int64("output_max_total", 256<<20, "Remove the oldest saved output beyond this many bytes")
 )
 
-type CompletionReq struct {
-	Text string
-	Pos  int
-}
-
-type Completion struct {
-	Label        string `json:"label"`
-	DisplayLabel string `json:"displayLabel,omitempty"`
-	Detail       string `json:"detail,omitempty"`
-	Info         string `json:"info,omitempty"`
-	Apply        string `json:"apply,omitempty"`
-	Type         string `json:"type,omitempty"`
-	Boost        int    `json:"boost,omitempty"`
-	Section      string `json:"section,omitempty"`
+// openHistory loads the -history file.
+// Without one the history only lasts for this session.
+func openHistory() *history.History {
+	opts := []history.Option{history.WithPolicy(historyPolicy())}
+	path, err := historyPath()
+	if err != nil {
+		log.Print(err)
+		return seedHistory(history.New(opts...))
+	}
+	if *saveOutputFlag {
+		outputs, err := history.NewOutputStore(filepath.Join(filepath.Dir(path), "output"))
+		if err != nil {
+			log.Printf("Can't save output: %v", err)
+		} else {
+			outputs.MaxSize = *outputMaxSizeFlag
+			outputs.MaxAge = *outputMaxAgeFlag
+			outputs.MaxTotal = *outputMaxTotalFlag
+			opts = append(opts, history.WithOutputStore(outputs))
+		}
+	}
+	h, err := history.Open(path, opts...)
+	if err != nil {
+		log.Printf("Can't open history: %v", err)
+	}
+	if h == nil {
+		h = history.New(opts...)
+	}
+	return seedHistory(h)
 }
 
-type CompletionResult struct {
-	From    int          `json:"from"`
-	To      int          `json:"to,omitempty"`
-	Options []Completion `json:"options"`
+// newShell starts a shell of the -backend.
+// kind is osh or ysh for fanos, exec takes any POSIX shell (but ysh).
+func newShell(kind, dir string) (shell.Shell, error) {
+	switch *backendFlag {
+	case "fanos":
+		var opts []fanos.Option
+		if kind != "" {
+			opts = append(opts, fanos.WithInterpreter(kind))
+		}
+		if dir != "" {
+			opts = append(opts, fanos.WithDir(dir))
+		}
+		s, err := fanos.New(opts...)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "exec":
+		// The wrapper is POSIX shell
+		if kind == "ysh" {
+			kind = ""
+		}
+		s, err := execsh.New(execsh.WithShell(kind), execsh.WithDir(dir))
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", *backendFlag)
 }
 
 type ExecType int
@@ -72,9 +123,16 @@ func main() {
 		fmt.Printf("Oils-Readline version: %s\n", Version)
 		os.Exit(0)
 	}
+	if flag.Arg(0) == "history" {
+		if err := historyCommand(flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "oils-readline history:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	s, err := fanos.New()
+	s, err := newShell("", "")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -89,10 +147,13 @@ func main() {
 		log.SetOutput(io.Discard)
 	}
 
+	h := openHistory()
 	model := NewModel(
 		[]shell.Shell{s},
-		[]tea.Model{newBasicPrompt(s), newTerminal(), newStderrViewer()},
+		[]tea.Model{newBasicPrompt(s, h), newTerminal(s), newStderrViewer(s)},
 	)
+	model.newShell = newShell
+	model.history = h
 	defer model.Cancel()
 
 	model.layout.Split(tiling.SplitVerticalWithMain)
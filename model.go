@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"log"
 	"os"
 	"reflect"
@@ -18,11 +19,15 @@ import (
 // function (via a command) to request its own removal from the parent tiling
 // Model.
 func widgets(m *model) map[string]func() tea.Cmd {
+	// New widgets belong to the focused shell
+	s := m.focusedShell()
 	return map[string]func() tea.Cmd{
-		"SimplePrompt": func() tea.Cmd { return AddWidget(newBasicPrompt(m.shells[m.shellFocus])) },
-		"StdoutLog":    func() tea.Cmd { return AddWidget(newStdoutViewer()) },
-		"ErrorLog":     func() tea.Cmd { return AddWidget(newStderrViewer()) },
-		"Terminal":     func() tea.Cmd { return AddWidget(newTerminal()) },
+		"SimplePrompt": func() tea.Cmd { return AddWidget(newBasicPrompt(s, m.history)) },
+		"StdoutLog":    func() tea.Cmd { return AddWidget(newStdoutViewer(s)) },
+		"ErrorLog":     func() tea.Cmd { return AddWidget(newStderrViewer(s)) },
+		"Terminal":     func() tea.Cmd { return AddWidget(newTerminal(s)) },
+		"Diagnostics":  func() tea.Cmd { return AddWidget(newDiagnosticsViewer(s)) },
+		"Diff":         func() tea.Cmd { return AddWidget(newDiffViewer(s, m.history)) },
 	}
 }
 
@@ -40,16 +45,22 @@ type removeShellMsg struct {
 type trackedShell struct {
 	shell.Shell
 	id uint64
+	// osh or ysh, empty if unknown
+	kind string
 }
 
 type model struct {
 	shells      []trackedShell
 	shellFocus  int
 	nextShellID uint64
+	// newShell starts another shell, set in main
+	newShell func(kind, dir string) (shell.Shell, error)
 
 	widgets []*widget.Widget
 
 	history *history.History
+	// Stop the tees of running commands, see TeeMsg
+	tees map[shell.Command][]func() error
 
 	layout *tiling.Layout
 
@@ -60,7 +71,7 @@ type model struct {
 	program *tea.Program
 
 	selecting     bool
-	selector      *SelectorWidget
+	selector      tea.Model
 	captureWidget *widget.Widget // index of widget capturing all keys, -1 = none
 }
 
@@ -73,7 +84,7 @@ func NewModel(shells []shell.Shell, children []tea.Model) *model {
 		entries[i] = w
 	}
 	for i, shell := range shells {
-		s[i] = trackedShell{shell, uint64(i)}
+		s[i] = newTrackedShell(shell, uint64(i))
 	}
 	m := &model{
 		shells:        s,
@@ -82,6 +93,7 @@ func NewModel(shells []shell.Shell, children []tea.Model) *model {
 		widgets:       entries,
 		captureWidget: nil,
 		history:       &history.History{},
+		tees:          map[shell.Command][]func() error{},
 	}
 	return m
 }
@@ -97,12 +109,19 @@ func (m *model) addWidget(w *widget.Widget) tea.Cmd {
 // AddChild appends a child model to the end of the widget list and the layout.
 // It returns the child's Init command.
 func (m *model) AddChild(child tea.Model) tea.Cmd {
-	w := &widget.Widget{child}
+	w := &widget.Widget{Model: child}
 	m.widgets = append(m.widgets, w)
 
 	return nil
 }
 
+// openSelector shows sel on top of the layout until it sends CloseSelectorMsg.
+func (m *model) openSelector(sel tea.Model) tea.Cmd {
+	m.selecting = true
+	m.selector, _ = sel.Update(tea.WindowSizeMsg{Width: m.Width, Height: m.Height})
+	return m.selector.Init()
+}
+
 type Cancellable interface {
 	Cancel()
 }
@@ -122,13 +141,24 @@ func (m *model) Cancel() {
 func (m *model) AddShell(shell shell.Shell) tea.Cmd {
 	id := m.nextShellID
 	m.nextShellID++
-	m.shells = append(m.shells, trackedShell{shell, id})
+	m.shells = append(m.shells, newTrackedShell(shell, id))
+	m.supervise(shell)
 	return func() tea.Msg {
 		shell.Wait()
 		return removeShellMsg{shell}
 	}
 }
 
+// supervise forwards restarts and diagnostics of a Supervised shell to the widgets.
+func (m *model) supervise(s shell.Shell) {
+	sup, ok := s.(shell.Supervised)
+	if !ok {
+		return
+	}
+	sup.SetOnRestart(func(err error) { m.program.Send(shell.RestartedMsg{Shell: s, Err: err}) })
+	sup.SetOnDiagnostics(func() { m.program.Send(shell.DiagnosticsMsg{Shell: s}) })
+}
+
 func (m *model) RemoveChild(w *widget.Widget) tea.Cmd {
 	if m.captureWidget == w {
 		m.captureWidget = nil
@@ -149,6 +179,7 @@ func (m *model) Init() tea.Cmd {
 	var shellCmds []tea.Cmd
 
 	for _, shell := range m.shells {
+		m.supervise(shell.Shell)
 		shellCmds = append(shellCmds,
 			func() tea.Msg {
 				shell.Wait()
@@ -162,6 +193,7 @@ func (m *model) Init() tea.Cmd {
 	}
 	cmds = append(cmds,
 		func() tea.Msg { log.Print("request focus"); return tiling.RequestFocusMainMsg{} },
+		m.broadcastShells(),
 	)
 
 	// Focus the first widget after init
@@ -215,10 +247,8 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if m.selecting {
 		switch msg := msg.(type) {
 		case tea.KeyPressMsg:
-			newSel, cmd := m.selector.Update(msg)
-			if c, ok := newSel.(*SelectorWidget); ok {
-				m.selector = c
-			}
+			var cmd tea.Cmd
+			m.selector, cmd = m.selector.Update(msg)
 			return m, cmd
 		}
 	}
@@ -264,13 +294,40 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyPressMsg:
 		switch msg.String() {
 		case "ctrl+space":
-			m.selecting = true
-			m.selector = newWidgetSelector(widgets(m))
-			m.selector.width = m.Width
-			m.selector.height = m.Height
-			return m, m.selector.Init()
+			return m, m.openSelector(newWidgetSelector(widgets(m)))
+		case "ctrl+o":
+			return m, m.openSelector(newShellSelector(m.shellsMsg()))
+		case "ctrl+r":
+			s := m.focusedShell()
+			var id *uint64
+			if n, ok := m.shellID(s); ok {
+				id = &n
+			}
+			return m, m.openSelector(newHistorySearch(m.history, s, id))
+		case "ctrl+t":
+			like := m.likeFocused()
+			return m, m.newShellCmd(like.Interpreter, like.Dir)
+		case "alt+n":
+			return m, m.cycleShell(1)
+		case "alt+p":
+			return m, m.cycleShell(-1)
 		}
 
+	case NewShellMsg:
+		return m, m.newShellCmd(msg.Interpreter, msg.Dir)
+
+	case shellCreatedMsg:
+		return m, m.shellCreated(msg)
+
+	case FocusShellMsg:
+		return m, m.focusShell(msg.ID)
+
+	case CloseShellMsg:
+		return m, m.closeShell(msg.ID)
+
+	case removeShellMsg:
+		return m, m.removeShell(msg.s)
+
 	case CloseSelectorMsg:
 		m.selecting = false
 		m.selector = nil
@@ -289,34 +346,101 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.Height = msg.Height
 		m.layout.Size(msg.Width, msg.Height)
 		if m.selecting && m.selector != nil {
-			m.selector.width = msg.Width
-			m.selector.height = msg.Height
+			m.selector, _ = m.selector.Update(msg)
 		}
 		return m, m.recalculateSizes()
 	case CommandEnteredMsg:
 		command := msg.Text
+		if entry, stderr, rest, ok := parsePipe(command); ok {
+			src, err := m.pipeSource(entry)
+			if err != nil {
+				log.Printf("Can't pipe from !%s: %v", entry, err)
+				break
+			}
+			command, msg.PipeFrom, msg.PipeStderr = rest, src, stderr
+		}
 		if len(command) == 0 {
 			break // We still let widgets deal with it!
 		}
 
+		s := msg.Shell
+		if s == nil {
+			s = m.focusedShell()
+		}
+		if s == nil {
+			log.Print("No shell left to run the command")
+			break
+		}
+
+		line := command
+		if msg.Dir != "" && msg.Dir != s.Dir() {
+			command = inDir(s, msg.Dir, command)
+		}
 		size, _ := pty.GetsizeFull(os.Stdin)
-		cmd, err := m.shells[m.shellFocus].Command(command, size)
+		cmd, err := s.Command(command, size)
 		if err != nil {
 			log.Fatal("Can't create new Command!", err)
 		}
-		cmd.SetState(shell.Queued)
 
-		cmd.SetOnStdout(func() { m.program.Send(shell.StdoutMsg{Cmd: cmd}) })
-		cmd.SetOnStderr(func() { m.program.Send(shell.StderrMsg{Cmd: cmd}) })
+		var pipedFrom string
+		if msg.PipeFrom != nil {
+			if err := pipeInput(cmd, msg); err != nil {
+				log.Printf("Can't pipe: %v", err)
+				if c, ok := msg.Input.(io.Closer); ok {
+					c.Close()
+				}
+				break
+			}
+			pipedFrom = m.history.EntryOf(msg.PipeFrom).ID()
+		}
 
-		m.history.Add(cmd)
+		dir := s.Dir()
+		if msg.Dir != "" {
+			dir = msg.Dir
+		}
+		id, _ := m.shellID(s)
+		// From here on it's the command as the history shows it: without the
+		// cd of a rerun, and with its secrets hidden, viewers mustn't show them
+		cmd, _ = m.history.Add(cmd, history.Entry{Command: line, Cwd: dir, Shell: id, RerunOf: msg.RerunOf, PipedFrom: pipedFrom, PipedStderr: msg.PipeStderr})
+		cmd.SetOnStdout(func() { m.program.Send(shell.StdoutMsg{Cmd: cmd, Shell: s}) })
+		cmd.SetOnStderr(func() { m.program.Send(shell.StderrMsg{Cmd: cmd, Shell: s}) })
 
 		log.Print("Running command")
 		return m, tea.Batch(
-			func() tea.Msg { return shell.CommandMsg{Cmd: cmd} },
-			func() tea.Msg { cmd.Run(); return shell.CommandDoneMsg{Cmd: cmd} },
+			func() tea.Msg { return shell.CommandMsg{Cmd: cmd, Shell: s} },
+			func() tea.Msg {
+				cmd.Run()
+				return shell.CommandDoneMsg{Cmd: cmd, Shell: s}
+			},
 		)
 
+	case RerunMsg:
+		return m, m.rerun(msg)
+
+	case EditMsg:
+		return m, m.edit(msg)
+
+	case PipeMsg:
+		return m, m.pipe(msg)
+
+	case OpenTeeMsg:
+		return m, m.openSelector(newTeeDialog(msg))
+
+	case TeeMsg:
+		return m, m.tee(msg)
+
+	case UnteeMsg:
+		return m, m.untee(msg.Cmd)
+
+	case shell.RestartedMsg:
+		log.Printf("Shell restarted: %v", msg.Err)
+
+	case shell.CommandDoneMsg:
+		// The shell refreshed its state after the command, pass it on to the widgets
+		if s := msg.Shell; s != nil {
+			cmd = tea.Batch(cmd, func() tea.Msg { return shell.StateMsg{Shell: s, State: s.State()} })
+		}
+
 	case tea.EnvMsg:
 		log.Print("Got env from tea process")
 	}
@@ -334,7 +458,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, cmd
 	}
 
-	var cmds []tea.Cmd
+	cmds := []tea.Cmd{cmd}
 	for _, child := range m.widgets {
 		_, cmd := child.Update(msg)
 		cmds = append(cmds, cmd)
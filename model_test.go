@@ -14,7 +14,6 @@ import (
 	"github.com/Melkor333/oils-readline/shell"
 	"github.com/Melkor333/oils-readline/tiling"
 	"github.com/Melkor333/oils-readline/widget"
-	"github.com/chalk-ai/bubbline/editline"
 	"github.com/charmbracelet/x/exp/teatest/v2"
 	"github.com/creack/pty"
 	"github.com/stretchr/testify/assert"
@@ -28,12 +27,16 @@ func (m *MockShell) Command(cmd string, size *pty.Winsize) (shell.Command, error
 	return &MockCommand{}, nil
 }
 
-func (m *MockShell) Run(cmd string, ptmx, tty, stderr *os.File) error           { return nil }
-func (m *MockShell) GetPrompt() string                                          { return "" }
-func (m *MockShell) Cancel()                                                    {}
-func (m *MockShell) Complete([][]rune, int, int) (string, editline.Completions) { return "", nil }
-func (m *MockShell) Dir() string                                                { return "" }
-func (m *MockShell) Wait()                                                      { time.Sleep(time.Millisecond) }
+func (m *MockShell) Run(cmd string, ptmx, tty, stderr *os.File) error { return nil }
+func (m *MockShell) GetPrompt() string                                { return "" }
+func (m *MockShell) Cancel()                                          {}
+func (m *MockShell) Complete(shell.CompletionReq) (shell.CompletionResult, error) {
+	return shell.CompletionResult{}, nil
+}
+func (m *MockShell) Dir() string                        { return "" }
+func (m *MockShell) Wait()                              { select {} }
+func (m *MockShell) State() shell.State                 { return shell.State{} }
+func (m *MockShell) RefreshState() (shell.State, error) { return shell.State{}, nil }
 
 type MockCommand struct {
 	state shell.CommandState
@@ -52,6 +55,11 @@ func (m *MockCommand) SetOnStdout(fn func())         {}
 func (m *MockCommand) SetOnStderr(fn func())         {}
 func (m *MockCommand) State() shell.CommandState     { return m.state }
 func (m *MockCommand) SetState(s shell.CommandState) { m.state = s }
+func (m *MockCommand) ExitCode() int                 { return 0 }
+func (m *MockCommand) Err() error                    { return nil }
+func (m *MockCommand) Signal(os.Signal) error        { return nil }
+func (m *MockCommand) Interrupt() error              { return nil }
+func (m *MockCommand) Kill() error                   { return nil }
 
 type blockModel struct {
 	width  int
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"charm.land/bubbles/v2/textinput"
+	"charm.land/bubbles/v2/viewport"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+)
+
+var (
+	matchColor         = lipgloss.NewStyle().Reverse(true)
+	selectedMatchColor = lipgloss.NewStyle().Background(lipgloss.Color("3")).Foreground(lipgloss.Color("0")) // yellow
+)
+
+// outputSearch finds a regexp in the output of a viewer, like / in less.
+type outputSearch struct {
+	input textinput.Model
+	// The pattern is being typed
+	active bool
+	// To go back to on esc
+	previous string
+	pattern  *regexp.Regexp
+	err      error
+	matches  int
+}
+
+func newOutputSearch() outputSearch {
+	input := textinput.New()
+	input.Prompt = "/"
+	input.Placeholder = "regexp"
+	return outputSearch{input: input}
+}
+
+// start lets the pattern be typed.
+func (s *outputSearch) start() tea.Cmd {
+	s.active = true
+	s.previous = s.input.Value()
+	s.input.CursorEnd()
+	return s.input.Focus()
+}
+
+// key handles the keys while the pattern is typed, it's done after enter or esc.
+// The pattern applies as it's typed.
+func (s *outputSearch) key(msg tea.KeyPressMsg) (done bool, cmd tea.Cmd) {
+	switch msg.String() {
+	case "enter":
+	case "esc", "ctrl+c":
+		s.input.SetValue(s.previous)
+		s.compile()
+	default:
+		s.input, cmd = s.input.Update(msg)
+		s.compile()
+		return false, cmd
+	}
+	s.active = false
+	s.input.Blur()
+	return true, nil
+}
+
+func (s *outputSearch) compile() {
+	s.pattern, s.err = nil, nil
+	if s.input.Value() != "" {
+		s.pattern, s.err = regexp.Compile(s.input.Value())
+	}
+}
+
+// highlight marks the matches of text in view, which shows it wrapped.
+// Both must be free of escape sequences for the viewport to find them.
+// The search runs on text so a match can go across a wrapped line.
+func (s *outputSearch) highlight(view *viewport.Model, text, wrapped string) {
+	view.HighlightStyle = matchColor
+	view.SelectedHighlightStyle = selectedMatchColor
+	view.ClearHighlights()
+	s.matches = 0
+	if s.pattern == nil {
+		return
+	}
+	matches := s.find(text, wrapped)
+	s.matches = len(matches)
+	view.SetHighlights(matches)
+}
+
+// find matches the pattern in text and returns where the matches are in wrapped.
+func (s *outputSearch) find(text, wrapped string) [][]int {
+	breaks := wrapBreaks(text, wrapped)
+	var matches [][]int
+	for _, m := range s.pattern.FindAllStringIndex(text, -1) {
+		// Nothing to show of empty ones
+		if m[0] == m[1] {
+			continue
+		}
+		// A match starting on a new line starts after the break, one ending
+		// there ends before it
+		start := m[0] + sort.SearchInts(breaks, m[0]+1)
+		end := m[1] + sort.SearchInts(breaks, m[1])
+		matches = append(matches, []int{start, end})
+	}
+	return matches
+}
+
+// wrapBreaks finds the offsets in text where wrapping it into wrapped
+// added a newline.
+func wrapBreaks(text, wrapped string) []int {
+	var breaks []int
+	for i, j := 0, 0; j < len(wrapped); j++ {
+		if i < len(text) && text[i] == wrapped[j] {
+			i++
+		} else if wrapped[j] == '\n' {
+			breaks = append(breaks, i)
+		}
+	}
+	return breaks
+}
+
+// status shows the pattern and how often it matched, for the header line.
+func (s *outputSearch) status() string {
+	switch {
+	case s.active && s.err != nil:
+		return s.input.View() + " " + highlightColor.Render(s.err.Error())
+	case s.active:
+		return s.input.View() + inactiveColor.Render(fmt.Sprintf(" %d matches", s.matches))
+	case s.pattern != nil:
+		return inactiveColor.Render(fmt.Sprintf("/%s %d matches", s.pattern, s.matches))
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// pipePattern matches the start of a command line which reads the output of
+// an earlier command instead of running it again, like `!12| grep error`.
+// !! is the last entry, !-2 the one before. With :2 it's the stderr.
+var pipePattern = regexp.MustCompile(`^\s*!(!|-?\d+)(?::([12]))?\s*\|\s*`)
+
+// PipeMsg puts the start of a command line reading the output of Cmd into the
+// prompt of Shell, or of the focused shell.
+type PipeMsg struct {
+	Cmd    shell.Command
+	Stderr bool
+	Shell  shell.Shell
+}
+
+// pipeKey handles p in viewers, to pipe what they show into a new command.
+func pipeKey(c shell.Command, stderr bool, s shell.Shell) tea.Cmd {
+	if c == nil {
+		return nil
+	}
+	return func() tea.Msg { return PipeMsg{Cmd: c, Stderr: stderr, Shell: s} }
+}
+
+// pipePrefix is the start of a command line reading the output of entry i.
+func pipePrefix(i int, stderr bool) string {
+	if stderr {
+		return fmt.Sprintf("!%d:2| ", i)
+	}
+	return fmt.Sprintf("!%d| ", i)
+}
+
+// parsePipe splits a command line reading the output of an earlier one, see
+// pipePattern. ok is false for ordinary command lines.
+func parsePipe(text string) (entry string, stderr bool, rest string, ok bool) {
+	m := pipePattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", false, text, false
+	}
+	return m[1], m[2] == "2", text[len(m[0]):], true
+}
+
+// pipeSource finds the entry of parsePipe in the history.
+func (m *model) pipeSource(entry string) (shell.Command, error) {
+	i := m.history.Count() - 1
+	if entry != "!" {
+		i, _ = strconv.Atoi(entry)
+		if i < 0 {
+			i += m.history.Count()
+		}
+	}
+	return m.history.AtIndex(i)
+}
+
+// pipeInput makes c read the Input of msg, or the output of PipeFrom as far
+// as there is some.
+func pipeInput(c shell.Command, msg CommandEnteredMsg) error {
+	r, ok := c.(shell.Redirectable)
+	if !ok {
+		return fmt.Errorf("%q can't read the output of other commands", c.CommandLine())
+	}
+	if msg.Input != nil {
+		r.SetInput(msg.Input)
+		return nil
+	}
+	input := msg.PipeFrom.Stderr()
+	if !msg.PipeStderr {
+		// The pty turned \n into \r\n
+		input = strings.ReplaceAll(msg.PipeFrom.Stdout(), "\r\n", "\n")
+	}
+	r.SetInput(strings.NewReader(input))
+	return nil
+}
+
+func (m *model) pipe(msg PipeMsg) tea.Cmd {
+	i, err := m.history.GetIndexOf(msg.Cmd)
+	if err != nil {
+		return nil
+	}
+	s := msg.Shell
+	if s == nil {
+		s = m.focusedShell()
+	}
+	if s == nil {
+		return nil
+	}
+	prefix := pipePrefix(i, msg.Stderr)
+	var focus tea.Cmd
+	if id, ok := m.shellID(s); ok {
+		focus = m.focusShell(id)
+	}
+	return tea.Batch(
+		focus,
+		func() tea.Msg { return SetPromptMsg{Text: prefix, Shell: s} },
+	)
+}
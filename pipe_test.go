@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePipe(t *testing.T) {
+	for text, want := range map[string]struct {
+		entry  string
+		stderr bool
+		rest   string
+		ok     bool
+	}{
+		"!12| grep x":    {"12", false, "grep x", true},
+		"  !!|jq .":      {"!", false, "jq .", true},
+		"!-2:2| wc -l":   {"-2", true, "wc -l", true},
+		"!3:1| cat":      {"3", false, "cat", true},
+		"grep x":         {"", false, "grep x", false},
+		"echo !3| cat":   {"", false, "echo !3| cat", false},
+		"!3 | cat":       {"3", false, "cat", true},
+		"!history| grep": {"", false, "!history| grep", false},
+	} {
+		entry, stderr, rest, ok := parsePipe(text)
+		assert.Equal(t, want.entry, entry, text)
+		assert.Equal(t, want.stderr, stderr, text)
+		assert.Equal(t, want.rest, rest, text)
+		assert.Equal(t, want.ok, ok, text)
+	}
+}
+
+func input(t *testing.T, c shell.Command) string {
+	t.Helper()
+	r := c.(*fakeCommand).input
+	if r == nil {
+		return ""
+	}
+	b, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestPipe(t *testing.T) {
+	m, first := newShellModel()
+	m.Update(CommandEnteredMsg{Text: "kubectl get pods"})
+	src, _ := m.history.Last()
+	src.(*fakeCommand).stdout = "web\r\ndb\r\n"
+	src.(*fakeCommand).stderr = "warning\n"
+
+	m.Update(CommandEnteredMsg{Text: "!0| grep web"})
+	piped, _ := m.history.Last()
+	assert.Equal(t, []string{"kubectl get pods", "grep web"}, first.commands, "reads the output instead of running it again")
+	assert.Equal(t, "web\ndb\n", input(t, piped))
+	i, err := m.history.Source(piped)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, i, "the entry links to where its input came from")
+
+	m.Update(CommandEnteredMsg{Text: "!-2:2| wc -l"})
+	c, _ := m.history.Last()
+	assert.Equal(t, "warning\n", input(t, c))
+	assert.True(t, m.history.EntryOf(c).PipedStderr)
+
+	// Reruns read the same output again
+	entered := update(t, m, RerunMsg{Cmd: piped})
+	if assert.IsType(t, CommandEnteredMsg{}, entered) {
+		assert.Same(t, src, entered.(CommandEnteredMsg).PipeFrom)
+	}
+	_, cmd := m.Update(EditMsg{Cmd: piped})
+	assert.Contains(t, batch(cmd), SetPromptMsg{Text: "!0| grep web", Shell: first})
+
+	// Nothing to read from
+	m.Update(CommandEnteredMsg{Text: "!9| grep web"})
+	assert.Len(t, first.commands, 3)
+}
+
+func TestPipeKey(t *testing.T) {
+	m, first := newShellModel()
+	m.Update(CommandEnteredMsg{Text: "make"})
+	c, _ := m.history.Last()
+
+	v := newStderrViewer(nil)
+	v.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	v.Update(history.HistoryEntryMsg{Cmd: c, Index: 0, Total: 1})
+	_, cmd := v.Update(tea.KeyPressMsg{Code: 'p', Text: "p"})
+	pipe := cmd()
+	assert.Equal(t, PipeMsg{Cmd: c, Stderr: true}, pipe)
+
+	_, cmd = m.Update(pipe)
+	assert.Contains(t, batch(cmd), SetPromptMsg{Text: "!0:2| ", Shell: first})
+}
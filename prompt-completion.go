@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// completionRows is how many completions are shown at once.
+const completionRows = 8
+
+var (
+	completionStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
+	completionSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	completionCategoryStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+)
+
+// completionMsg has what the shell of prompt offers at byte pos of the input,
+// which was on line.
+type completionMsg struct {
+	prompt    *basicPrompt
+	seq       int
+	line, pos int
+	result    shell.CompletionResult
+	err       error
+}
+
+type completionItem struct {
+	title, category, replacement string
+	// The word it replaces starts at byte start of the input, and went on for
+	// after bytes right of the cursor when completing.
+	start, after int
+}
+
+// completionMenu is shown below the input after tab. What's typed while it's
+// open filters it.
+type completionMenu struct {
+	// Of the last request, older answers are dropped
+	seq     int
+	pending bool
+	open    bool
+	line    int
+	items   []completionItem
+	// What of items matches what's typed, and which of those is selected
+	shown    []int
+	selected int
+}
+
+// complete asks the shell for completions. That can take a while, e.g. if
+// it's busy, so it doesn't hold up typing.
+func (bp *basicPrompt) complete() tea.Cmd {
+	if bp.shell == nil || bp.menu.pending {
+		return nil
+	}
+	bp.menu.seq++
+	bp.menu.pending = true
+	s, seq := bp.shell, bp.menu.seq
+	req := shell.CompletionReq{Text: bp.input.Value(), Pos: bp.cursorOffset()}
+	line := bp.input.Line()
+	return func() tea.Msg {
+		result, err := s.Complete(req)
+		return completionMsg{prompt: bp, seq: seq, line: line, pos: req.Pos, result: result, err: err}
+	}
+}
+
+// cursorOffset is the byte of the input the cursor is at.
+func (bp *basicPrompt) cursorOffset() int {
+	lines := strings.Split(bp.input.Value(), "\n")
+	line := min(bp.input.Line(), len(lines)-1)
+	offset := 0
+	for _, l := range lines[:line] {
+		offset += len(l) + 1
+	}
+	runes := []rune(lines[line])
+	return offset + len(string(runes[:min(bp.input.Column(), len(runes))]))
+}
+
+// setCursorOffset moves the cursor to byte offset of the input.
+func (bp *basicPrompt) setCursorOffset(offset int) {
+	before := bp.input.Value()[:offset]
+	line := strings.Count(before, "\n")
+	col := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:])
+	bp.input.MoveToBegin()
+	for i := 0; bp.input.Line() < line && i < len(bp.input.Value()); i++ {
+		bp.input.CursorDown()
+	}
+	bp.input.SetCursorColumn(col)
+}
+
+func (bp *basicPrompt) completed(msg completionMsg) {
+	bp.menu.pending = false
+	if msg.seq != bp.menu.seq {
+		return
+	}
+	if msg.err != nil {
+		log.Printf("Completion failed: %v", msg.err)
+		return
+	}
+	var items []completionItem
+	for _, c := range msg.result.Options {
+		title := c.DisplayLabel
+		if title == "" {
+			title = c.Label
+		}
+		items = append(items, completionItem{
+			title:       title,
+			category:    c.Type,
+			replacement: c.Text(),
+			start:       msg.result.From,
+			after:       max(0, msg.result.To-msg.pos),
+		})
+	}
+	bp.menu.open, bp.menu.line, bp.menu.items = true, msg.line, items
+	bp.filterCompletions()
+	// Nothing to choose from
+	if bp.menu.open && len(items) == 1 {
+		bp.acceptCompletion()
+	}
+}
+
+// closeCompletions also drops the answer to a pending request.
+func (bp *basicPrompt) closeCompletions() {
+	bp.menu = completionMenu{seq: bp.menu.seq + 1, pending: bp.menu.pending}
+}
+
+// filterCompletions keeps what starts with the word typed so far, or closes
+// the menu if nothing does or the cursor left the word.
+func (bp *basicPrompt) filterCompletions() {
+	if !bp.menu.open {
+		return
+	}
+	value, pos := bp.input.Value(), bp.cursorOffset()
+	var selected *completionItem
+	if len(bp.menu.shown) > 0 {
+		selected = &bp.menu.items[bp.menu.shown[bp.menu.selected]]
+	}
+	bp.menu.shown, bp.menu.selected = nil, 0
+	for i, item := range bp.menu.items {
+		if bp.input.Line() != bp.menu.line || item.start > pos {
+			continue
+		}
+		// The quotes of an accepted completion don't have to be typed
+		typed := strings.TrimLeft(value[item.start:pos], `'"`)
+		if strings.HasPrefix(item.title, typed) || strings.HasPrefix(item.replacement, typed) {
+			if &bp.menu.items[i] == selected {
+				bp.menu.selected = len(bp.menu.shown)
+			}
+			bp.menu.shown = append(bp.menu.shown, i)
+		}
+	}
+	if len(bp.menu.shown) == 0 {
+		bp.closeCompletions()
+	}
+}
+
+// acceptCompletion replaces the word with the selected completion.
+func (bp *basicPrompt) acceptCompletion() {
+	item := bp.menu.items[bp.menu.shown[bp.menu.selected]]
+	bp.closeCompletions()
+
+	value := bp.input.Value()
+	end := min(bp.cursorOffset()+item.after, len(value))
+	bp.input.SetValue(value[:item.start] + item.replacement + value[end:])
+	// SetValue moves the cursor to the end
+	bp.setCursorOffset(item.start + len(item.replacement))
+}
+
+// completionKey handles the keys of the open menu, ok is false for those
+// which go to the input.
+func (bp *basicPrompt) completionKey(msg tea.KeyPressMsg) (ok bool) {
+	n := len(bp.menu.shown)
+	switch msg.String() {
+	case "tab", "down", "ctrl+n":
+		bp.menu.selected = (bp.menu.selected + 1) % n
+	case "shift+tab", "up", "ctrl+p":
+		bp.menu.selected = (bp.menu.selected - 1 + n) % n
+	case "enter":
+		bp.acceptCompletion()
+	case "esc", "ctrl+g":
+		bp.closeCompletions()
+	default:
+		return false
+	}
+	return true
+}
+
+// completionView lists the completions around the selected one.
+func (bp *basicPrompt) completionView() []string {
+	if !bp.menu.open {
+		return nil
+	}
+	first := max(0, min(bp.menu.selected-completionRows+1, len(bp.menu.shown)-completionRows))
+	shown := bp.menu.shown[first:min(first+completionRows, len(bp.menu.shown))]
+	width := 0
+	for _, i := range shown {
+		width = max(width, ansi.StringWidth(bp.menu.items[i].title))
+	}
+	var lines []string
+	for j, i := range shown {
+		item := bp.menu.items[i]
+		title := item.title + strings.Repeat(" ", width-ansi.StringWidth(item.title))
+		style := completionStyle
+		if first+j == bp.menu.selected {
+			style = completionSelectedStyle
+		}
+		lines = append(lines, style.Render(" "+title+" ")+" "+completionCategoryStyle.Render(item.category))
+	}
+	if len(bp.menu.shown) > completionRows {
+		lines = append(lines, completionCategoryStyle.Render(fmt.Sprintf(" %d/%d", bp.menu.selected+1, len(bp.menu.shown))))
+	}
+	if bp.width > 0 {
+		for i := range lines {
+			lines[i] = ansi.Truncate(lines[i], bp.width-lipgloss.Width(bp.prompt), "…")
+		}
+	}
+	return lines
+}
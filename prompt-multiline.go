@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// indentUnit is added after an open bracket, and removed again by the closing one.
+const indentUnit = "  "
+
+// incomplete tells if the input needs more lines, so enter adds one instead of
+// running it. Without a highlighter it's always complete.
+func (bp *basicPrompt) incomplete() bool {
+	if bp.hl == nil {
+		return false
+	}
+	// Update parsed it for highlighting
+	return bp.hl.Incomplete()
+}
+
+// lineBeforeCursor is the current line up to the cursor.
+func (bp *basicPrompt) lineBeforeCursor() string {
+	value, pos := bp.input.Value(), bp.cursorOffset()
+	return value[strings.LastIndexByte(value[:pos], '\n')+1 : pos]
+}
+
+// newline breaks the line at the cursor and indents the next one like it, or
+// more after an open bracket.
+func (bp *basicPrompt) newline() {
+	line := bp.lineBeforeCursor()
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	if trimmed := strings.TrimRight(line, " \t"); trimmed != "" && strings.ContainsAny(trimmed[len(trimmed)-1:], "{([") {
+		indent += indentUnit
+	}
+	bp.input.InsertString("\n" + indent)
+}
+
+// dedent takes back an indentUnit before a closing bracket at the start of a line.
+func (bp *basicPrompt) dedent() {
+	line := bp.lineBeforeCursor()
+	if line == "" || strings.TrimLeft(line, " ") != "" {
+		return
+	}
+	for range min(len(line), len(indentUnit)) {
+		input, _ := bp.input.Update(tea.KeyPressMsg{Code: tea.KeyBackspace})
+		bp.input = &input
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// suggest looks up how the input might go on in the history, like fish does.
+// It's shown as ghost text after the cursor.
+func (bp *basicPrompt) suggest() {
+	value := bp.input.Value()
+	bp.suggestion, bp.suggestedFor, bp.suggested = "", value, true
+	if bp.history == nil || value == "" {
+		return
+	}
+	cwd := ""
+	if bp.shell != nil {
+		cwd = bp.shell.Dir()
+	}
+	bp.suggestion = bp.history.Suggest(value, cwd)
+}
+
+// ghost is the rest of the suggestion, as long as the cursor is at the end
+// of the input.
+func (bp *basicPrompt) ghost() string {
+	value := bp.input.Value()
+	if !bp.suggested || bp.suggestedFor != value {
+		// Not looked up yet, see Update
+		return ""
+	}
+	if value == "" || bp.menu.open || !bp.input.Focused() ||
+		!strings.HasPrefix(bp.suggestion, value) || bp.cursorOffset() != len(value) {
+		return ""
+	}
+	return bp.suggestion[len(value):]
+}
+
+// acceptSuggestion adds the ghost text to the input, or its first word only.
+func (bp *basicPrompt) acceptSuggestion(word bool) bool {
+	ghost := bp.ghost()
+	if ghost == "" {
+		return false
+	}
+	if word {
+		ghost = firstWord(ghost)
+	}
+	// The cursor ends up at the end
+	bp.input.SetValue(bp.input.Value() + ghost)
+	return true
+}
+
+// firstWord is s up to the end of its first word.
+func firstWord(s string) string {
+	start := len(s) - len(strings.TrimLeftFunc(s, unicode.IsSpace))
+	end := strings.IndexFunc(s[start:], unicode.IsSpace)
+	if end < 0 {
+		return s
+	}
+	return s[:start+end]
+}
+
+// ghostRows continues row, which ends where the cursor is, with ghost. The
+// cursor goes on its first character.
+func ghostRows(row, ghost string, width int) []string {
+	lines := strings.Split(ghost, "\n")
+	cursor, first := " ", lines[0]
+	if first != "" {
+		r, size := utf8.DecodeRuneInString(first)
+		cursor, first = string(r), first[size:]
+	}
+	row += cursorStyle.Render(cursor)
+	fits := ansi.Truncate(first, max(0, width-ansi.StringWidth(row)), "")
+	rows := []string{row + ghostStyle.Render(fits)}
+
+	wrap := func(line string) {
+		for _, wrapped := range strings.Split(ansi.Wrap(line, width, ""), "\n") {
+			rows = append(rows, ghostStyle.Render(wrapped))
+		}
+	}
+	// It goes on in the next row, where the space it breaks at isn't needed
+	if rest := strings.TrimLeft(first[len(fits):], " "); rest != "" {
+		wrap(rest)
+	}
+	for _, line := range lines[1:] {
+		wrap(line)
+	}
+	return rows
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+
+	tea "charm.land/bubbletea/v2"
+
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+// RerunMsg runs Cmd from the history again, in Shell or the focused shell.
+// With InDir it first changes to the directory Cmd ran in.
+// The new entry links to the old one, see history.Entry.RerunOf.
+type RerunMsg struct {
+	Cmd   shell.Command
+	Shell shell.Shell
+	InDir bool
+}
+
+// EditMsg puts the command line of Cmd into the prompt of Shell, or of the
+// focused shell, to change it before running it again.
+type EditMsg struct {
+	Cmd   shell.Command
+	Shell shell.Shell
+}
+
+// rerunKeys handles the keys of viewers showing a command: r reruns it,
+// R reruns it where it ran, i puts it into the prompt to edit it.
+func rerunKeys(key string, c shell.Command, s shell.Shell) tea.Cmd {
+	if c == nil {
+		return nil
+	}
+	var msg tea.Msg
+	switch key {
+	case "r":
+		msg = RerunMsg{Cmd: c, Shell: s}
+	case "R":
+		msg = RerunMsg{Cmd: c, Shell: s, InDir: true}
+	case "i":
+		msg = EditMsg{Cmd: c, Shell: s}
+	default:
+		return nil
+	}
+	return func() tea.Msg { return msg }
+}
+
+func (m *model) rerun(msg RerunMsg) tea.Cmd {
+	line, ok := history.CommandLine(msg.Cmd)
+	if !ok {
+		log.Printf("Can't rerun %q, the redacted parts are gone", line)
+		return m.edit(EditMsg{Cmd: msg.Cmd, Shell: msg.Shell})
+	}
+	e := m.history.EntryOf(msg.Cmd)
+	entered := CommandEnteredMsg{Text: line, Shell: msg.Shell, RerunOf: e.ID()}
+	if msg.InDir {
+		entered.Dir = e.Cwd
+	}
+	if e.PipedFrom != "" {
+		// It reads the same output again
+		src, err := m.source(msg.Cmd)
+		if err != nil {
+			log.Printf("Can't rerun %q, its input is gone: %v", line, err)
+			return m.edit(EditMsg{Cmd: msg.Cmd, Shell: msg.Shell})
+		}
+		entered.PipeFrom, entered.PipeStderr = src, e.PipedStderr
+	}
+	return func() tea.Msg { return entered }
+}
+
+// source returns the entry whose output c read.
+func (m *model) source(c shell.Command) (shell.Command, error) {
+	i, err := m.history.Source(c)
+	if err != nil {
+		return nil, err
+	}
+	return m.history.AtIndex(i)
+}
+
+func (m *model) edit(msg EditMsg) tea.Cmd {
+	line, _ := history.CommandLine(msg.Cmd)
+	if e := m.history.EntryOf(msg.Cmd); e.PipedFrom != "" {
+		if i, err := m.history.Source(msg.Cmd); err == nil {
+			line = pipePrefix(i, e.PipedStderr) + line
+		}
+	}
+	s := msg.Shell
+	if s == nil {
+		s = m.focusedShell()
+	}
+	if s == nil {
+		return nil
+	}
+	var focus tea.Cmd
+	if id, ok := m.shellID(s); ok {
+		focus = m.focusShell(id)
+	}
+	return tea.Batch(
+		focus,
+		func() tea.Msg { return SetPromptMsg{Text: line, Shell: s} },
+	)
+}
+
+// inDir wraps line so it runs in dir, e.g. for a rerun, while s stays where
+// it is. If the cd fails, line doesn't run and the status is that of cd.
+func inDir(s shell.Shell, dir, line string) string {
+	if i, ok := s.(interface{ Interpreter() string }); ok && i.Interpreter() == "ysh" {
+		// ysh changes back after the block
+		return "cd " + shell.Quote(dir) + " {\n" + line + "\n}"
+	}
+	// On lines of their own, line may end in a comment
+	return "(\ncd " + shell.Quote(dir) + " || exit\n" + line + "\n)"
+}
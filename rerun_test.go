@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// batch runs the commands of a tea.Batch.
+func batch(cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	msg := cmd()
+	b, ok := msg.(tea.BatchMsg)
+	if !ok {
+		return []tea.Msg{msg}
+	}
+	var msgs []tea.Msg
+	for _, c := range b {
+		msgs = append(msgs, batch(c)...)
+	}
+	return msgs
+}
+
+func TestRerun(t *testing.T) {
+	m, first := newShellModel()
+	m.Update(CommandEnteredMsg{Text: "make"})
+	orig, _ := m.history.Last()
+	first.dir = "/tmp"
+
+	entered := update(t, m, RerunMsg{Cmd: orig, InDir: true})
+	if assert.IsType(t, CommandEnteredMsg{}, entered) {
+		assert.Equal(t, "/src", entered.(CommandEnteredMsg).Dir)
+	}
+	_, cmd := m.Update(entered)
+	batch(cmd)
+	assert.Equal(t, []string{"make", "cd /src {\nmake\n}"}, first.commands, "reruns where it ran first")
+	assert.Empty(t, first.runs, "in the same command")
+	assert.Equal(t, "/tmp", first.dir)
+
+	rerun, _ := m.history.Last()
+	assert.Equal(t, "make", rerun.CommandLine())
+	assert.Equal(t, "make", m.history.EntryOf(rerun).Command)
+	assert.Equal(t, "/src", m.history.EntryOf(rerun).Cwd)
+	i, err := m.history.Origin(rerun)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, i, "the rerun links to the first run")
+
+	// Rerunning the rerun runs make, not the cd again
+	line, _ := history.CommandLine(rerun)
+	assert.Equal(t, "make", line)
+
+	// Without InDir it runs where the shell is
+	m.Update(update(t, m, RerunMsg{Cmd: orig}))
+	assert.Equal(t, "make", first.commands[2])
+}
+
+func TestInDir(t *testing.T) {
+	assert.Equal(t, "cd 'my dir' {\nmake # all\n}", inDir(&namedShell{kind: "ysh"}, "my dir", "make # all"))
+	assert.Equal(t, "(\ncd 'my dir' || exit\nmake # all\n)", inDir(&namedShell{kind: "osh"}, "my dir", "make # all"))
+	assert.Equal(t, "(\ncd /src || exit\nmake\n)", inDir(&MockShell{}, "/src", "make"))
+}
+
+func TestRerunRedacted(t *testing.T) {
+	m, first := newShellModel()
+	m.history = history.New(history.WithPolicy(history.DefaultPolicy()))
+
+	m.Update(CommandEnteredMsg{Text: "TOKEN=abc make"})
+	c, _ := m.history.Last()
+	assert.Equal(t, "TOKEN=[REDACTED] make", c.CommandLine())
+	m.Update(update(t, m, RerunMsg{Cmd: c}))
+	assert.Equal(t, []string{"TOKEN=abc make", "TOKEN=abc make"}, first.commands, "this session still knows the secret")
+
+	// An earlier session doesn't, so it's up to the user
+	record := &history.Record{Entry: history.Entry{Command: "TOKEN=[REDACTED] make"}}
+	_, cmd := m.Update(RerunMsg{Cmd: record})
+	assert.Contains(t, batch(cmd), SetPromptMsg{Text: "TOKEN=[REDACTED] make", Shell: first})
+	assert.Len(t, first.commands, 2)
+}
+
+func TestViewerRerunKeys(t *testing.T) {
+	c := newFakeCmd("make", "")
+	for _, v := range []tea.Model{newStdoutViewer(nil), newTerminal(nil)} {
+		v.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+		v.Update(history.HistoryEntryMsg{Cmd: c, Index: 0, Total: 1})
+		_, cmd := v.Update(tea.KeyPressMsg{Code: 'R', Text: "R"})
+		assert.Equal(t, RerunMsg{Cmd: c, InDir: true}, cmd())
+		_, cmd = v.Update(tea.KeyPressMsg{Code: 'i', Text: "i"})
+		assert.Equal(t, EditMsg{Cmd: c}, cmd())
+	}
+}
+
+func TestViewersDontSeeSecrets(t *testing.T) {
+	m, _ := newShellModel()
+	m.history = history.New(history.WithPolicy(history.DefaultPolicy()))
+	viewers := []tea.Model{newStdoutViewer(nil), newTerminal(nil), newDiffViewer(nil, m.history)}
+	for _, v := range viewers {
+		v.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	}
+
+	_, cmd := m.Update(CommandEnteredMsg{Text: "TOKEN=abc make"})
+	msgs := batch(cmd)
+	assert.Len(t, msgs, 2)
+	for _, msg := range msgs {
+		for _, v := range viewers {
+			v.Update(msg)
+			assert.NotContains(t, v.View().Content, "abc")
+		}
+		m.Update(msg)
+	}
+	for _, msg := range msgs {
+		switch msg := msg.(type) {
+		case shell.CommandMsg:
+			assert.Equal(t, "TOKEN=[REDACTED] make", msg.Cmd.CommandLine())
+		case shell.CommandDoneMsg:
+			assert.Equal(t, "TOKEN=[REDACTED] make", msg.Cmd.CommandLine())
+		default:
+			t.Errorf("unexpected %T", msg)
+		}
+	}
+	c, _ := m.history.Last()
+	assert.Equal(t, 0, m.history.EntryOf(c).Status, "it's finished all the same")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+)
+
+// ShellSelector lists the shells to switch between, open new ones or close them.
+type ShellSelector struct {
+	shells []ShellInfo
+	cursor int
+	width  int
+	height int
+}
+
+func newShellSelector(msg ShellsMsg) *ShellSelector {
+	s := &ShellSelector{shells: msg.Shells}
+	for i, info := range msg.Shells {
+		if info.Focused {
+			s.cursor = i
+		}
+	}
+	return s
+}
+
+func (ss *ShellSelector) Init() tea.Cmd {
+	return nil
+}
+
+// closeWith closes the selector after sending msg.
+func closeWith(msg tea.Msg) tea.Cmd {
+	return tea.Sequence(
+		func() tea.Msg { return msg },
+		func() tea.Msg { return CloseSelectorMsg{} },
+	)
+}
+
+func (ss *ShellSelector) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		switch msg.String() {
+		case "up", "k":
+			if ss.cursor > 0 {
+				ss.cursor--
+			}
+		case "down", "j":
+			if ss.cursor < len(ss.shells)-1 {
+				ss.cursor++
+			}
+		case "enter", "space":
+			if sel, ok := ss.selected(); ok {
+				return ss, closeWith(FocusShellMsg{ID: sel.ID})
+			}
+		case "d", "x":
+			if sel, ok := ss.selected(); ok {
+				return ss, closeWith(CloseShellMsg{ID: sel.ID})
+			}
+		case "n":
+			return ss, closeWith(ss.newShell("ysh"))
+		case "o":
+			return ss, closeWith(ss.newShell("osh"))
+		case "esc", "ctrl+o":
+			return ss, func() tea.Msg { return CloseSelectorMsg{} }
+		}
+	case tea.WindowSizeMsg:
+		ss.width = msg.Width
+		ss.height = msg.Height
+	}
+	return ss, nil
+}
+
+func (ss *ShellSelector) selected() (ShellInfo, bool) {
+	if ss.cursor >= len(ss.shells) {
+		return ShellInfo{}, false
+	}
+	return ss.shells[ss.cursor], true
+}
+
+// newShell opens the new shell where the selected one is.
+func (ss *ShellSelector) newShell(kind string) NewShellMsg {
+	msg := NewShellMsg{Interpreter: kind}
+	if sel, ok := ss.selected(); ok {
+		msg.Dir = sel.Shell.Dir()
+	}
+	return msg
+}
+
+func (ss *ShellSelector) View() tea.View {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	cursorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	itemStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("15"))
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+
+	title := titleStyle.Render("Select Shell")
+	var items []string
+	for i, info := range ss.shells {
+		mark := " "
+		if info.Focused {
+			mark = "*"
+		}
+		line := fmt.Sprintf("%s %s  %s", mark, info.Name, info.Shell.Dir())
+		if i == ss.cursor {
+			items = append(items, cursorStyle.Render("> "+line))
+		} else {
+			items = append(items, itemStyle.Render("  "+line))
+		}
+	}
+	if len(items) == 0 {
+		items = append(items, itemStyle.Render("  no shells"))
+	}
+	list := lipgloss.JoinVertical(lipgloss.Left, items...)
+	help := helpStyle.Render("enter: focus  d: close  n: new ysh  o: new osh")
+	content := lipgloss.JoinVertical(lipgloss.Left, title, "", list, "", help)
+
+	dialog := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("12")).
+		Padding(1, 2).
+		Render(content)
+
+	centered := lipgloss.Place(ss.width, ss.height, lipgloss.Center, lipgloss.Center, dialog)
+	return tea.NewView(centered)
+}
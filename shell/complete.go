@@ -0,0 +1,100 @@
+package shell
+
+import (
+	"strings"
+)
+
+// CompletionReq asks for what could go at byte Pos of Text.
+type CompletionReq struct {
+	Text string
+	Pos  int
+}
+
+// Completion is one option of a CompletionResult.
+type Completion struct {
+	Label        string `json:"label"`
+	DisplayLabel string `json:"displayLabel,omitempty"`
+	Detail       string `json:"detail,omitempty"`
+	Info         string `json:"info,omitempty"`
+	// Apply is inserted instead of Label, e.g. quoted
+	Apply string `json:"apply,omitempty"`
+	// Type is what it is, like "file", "dir", "command" or "variable"
+	Type    string `json:"type,omitempty"`
+	Boost   int    `json:"boost,omitempty"`
+	Section string `json:"section,omitempty"`
+}
+
+// Text is what accepting c inserts.
+func (c Completion) Text() string {
+	if c.Apply != "" {
+		return c.Apply
+	}
+	return c.Label
+}
+
+// CompletionResult has the options to replace the bytes From to To of the
+// CompletionReq with.
+type CompletionResult struct {
+	From    int          `json:"from"`
+	To      int          `json:"to,omitempty"`
+	Options []Completion `json:"options"`
+}
+
+// FileCompletions offers dirs and files for the word from to to.
+func FileCompletions(dirs, files []string, from, to int) CompletionResult {
+	result := CompletionResult{From: from, To: to}
+	for _, cat := range []struct {
+		typ   string
+		names []string
+	}{{"dir", dirs}, {"file", files}} {
+		for _, name := range cat.names {
+			c := Completion{Label: name, Type: cat.typ}
+			if quoted := Quote(name); quoted != name {
+				c.Apply = quoted
+			}
+			result.Options = append(result.Options, c)
+		}
+	}
+	return result
+}
+
+// Characters that end or change a word in sh and ysh
+const special = " \t\n|&;<>()$`\\\"'*?[]{}#!@"
+
+// Quote quotes word for osh and ysh if it has to be. Single quotes unless it
+// contains some itself.
+func Quote(word string) string {
+	if word != "" && !strings.ContainsAny(word, special) {
+		return word
+	}
+	if !strings.Contains(word, "'") {
+		return "'" + word + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+	return `"` + r.Replace(word) + `"`
+}
+
+// WordStart is where the word ending at i of line starts, as far as
+// completion is concerned. Quoted and escaped separators are part of it.
+func WordStart(line string, i int) int {
+	start := 0
+	var quote byte
+	for j := 0; j < i; j++ {
+		c := line[j]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				j++
+			}
+		case c == '\\':
+			j++
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.IndexByte(" \t\n|&;<>(", c) >= 0:
+			start = j + 1
+		}
+	}
+	return start
+}
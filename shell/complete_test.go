@@ -0,0 +1,62 @@
+package shell
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	for word, want := range map[string]string{
+		"main.go":       "main.go",
+		"src/":          "src/",
+		"my file":       "'my file'",
+		"$HOME":         "'$HOME'",
+		"it's":          `"it's"`,
+		`it's "$x"`:     `"it's \"\$x\""`,
+		"":              "''",
+		"a*b":           "'a*b'",
+		"dir with/sub/": "'dir with/sub/'",
+		"back\\slash's": `"back\\slash's"`,
+	} {
+		if got := Quote(word); got != want {
+			t.Errorf("Quote(%q) = %s, wanted %s", word, got, want)
+		}
+	}
+}
+
+func TestFileCompletions(t *testing.T) {
+	if c := FileCompletions(nil, nil, 0, 0); len(c.Options) != 0 {
+		t.Errorf("got %v", c.Options)
+	}
+	// Completing `cat my fi|le` at the |
+	c := FileCompletions([]string{"my dir/"}, []string{"my file"}, 4, 11)
+	if c.From != 4 || c.To != 11 || len(c.Options) != 2 {
+		t.Fatalf("got %+v", c)
+	}
+	if o := c.Options[0]; o.Type != "dir" || o.Label != "my dir/" {
+		t.Errorf("got %+v", o)
+	}
+	if o := c.Options[1]; o.Type != "file" || o.Text() != "'my file'" {
+		t.Errorf("got %+v", o)
+	}
+	if o := FileCompletions(nil, []string{"main.go"}, 0, 0).Options[0]; o.Apply != "" {
+		t.Errorf("%q doesn't need quotes", o.Apply)
+	}
+}
+
+func TestWordStart(t *testing.T) {
+	for line, want := range map[string]int{
+		"":           0,
+		"ec":         0,
+		"echo $HO":   5,
+		"ls | gr":    5,
+		"x=1;cat -":  8,
+		"cat my\\ f": 4,
+		"cat 'a b":   4,
+		`cat "a\" b`: 4,
+		"cat 'a' b":  8,
+		"(cd src/ma": 4,
+		"echo a\nb":  7,
+	} {
+		if got := WordStart(line, len(line)); got != want {
+			t.Errorf("WordStart(%q) = %d, wanted %d", line, got, want)
+		}
+	}
+}
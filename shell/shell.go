@@ -1,10 +1,12 @@
 package shell
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 
-	"github.com/chalk-ai/bubbline/editline"
 	"github.com/creack/pty"
 )
 
@@ -18,10 +20,77 @@ const (
 )
 
 type CommandOutputErrorMsg error
-type CommandMsg struct{ Cmd Command }
-type CommandDoneMsg struct{ Cmd Command }
-type StdoutMsg struct{ Cmd Command }
-type StderrMsg struct{ Cmd Command }
+
+// Command messages carry the Shell the command runs in.
+type CommandMsg struct {
+	Cmd   Command
+	Shell Shell
+}
+type CommandDoneMsg struct {
+	Cmd   Command
+	Shell Shell
+}
+type StdoutMsg struct {
+	Cmd   Command
+	Shell Shell
+}
+type StderrMsg struct {
+	Cmd   Command
+	Shell Shell
+}
+
+// State is what a shell looked like after a command.
+type State struct {
+	// Cwd is the physical working directory, PWD the logical one
+	Cwd    string `json:"cwd"`
+	PWD    string `json:"pwd"`
+	Status int    `json:"-"`
+	// Env are the exported variables
+	Env map[string]string `json:"env"`
+	// Vars are a selection of (not necessarily exported) shell variables,
+	// as decoded from JSON. Unset ones are nil.
+	Vars     map[string]any `json:"vars"`
+	DirStack []string       `json:"dir_stack"`
+}
+
+// DefaultPrompt shows the (logical) working directory of s, with ~ for HOME.
+func DefaultPrompt(s State) string {
+	dir := s.PWD
+	if dir == "" {
+		dir = s.Cwd
+	}
+	if home := s.Env["HOME"]; home != "" && (dir == home || strings.HasPrefix(dir, home+"/")) {
+		dir = "~" + strings.TrimPrefix(dir, home)
+	}
+	return dir + " $ "
+}
+
+// Sent with the new State of Shell, after a command finished.
+type StateMsg struct {
+	Shell Shell
+	State State
+}
+
+// Sent when a Supervised shell was restarted, Err is why it died.
+type RestartedMsg struct {
+	Shell Shell
+	Err   error
+}
+
+// Sent when a Supervised shell has new Diagnostics.
+type DiagnosticsMsg struct{ Shell Shell }
+
+// ErrNotRunning is returned when signaling a command that isn't running.
+var ErrNotRunning = errors.New("command is not running")
+
+// ExitError is returned when a command finished with a non-zero exit status.
+type ExitError struct {
+	Status int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Status)
+}
 
 type Shell interface {
 	//StdIO(*os.File, *os.File, *os.File) error
@@ -29,9 +98,31 @@ type Shell interface {
 	Run(cmd string, ptmx, tty, stderr *os.File) error
 	GetPrompt() string // TODO: Should also return an error?
 	Cancel()
-	Complete([][]rune, int, int) (string, editline.Completions)
+	// Complete returns what could go at the cursor, it may have to wait for a
+	// running command.
+	Complete(req CompletionReq) (CompletionResult, error)
 	Dir() string
 	Wait()
+	// State returns the cached state after the last command, it doesn't talk to the shell.
+	State() State
+	// RefreshState asks the shell for its state and updates the cache.
+	RefreshState() (State, error)
+}
+
+// Supervised is implemented by shells that restart their interpreter when it dies.
+type Supervised interface {
+	// Diagnostics returns what the interpreter itself printed, e.g. on startup or when crashing.
+	Diagnostics() string
+	SetOnDiagnostics(fn func())
+	SetOnRestart(fn func(cause error))
+}
+
+// Redirectable is implemented by commands that can read their stdin from
+// somewhere else than the terminal, e.g. the output of an earlier command.
+type Redirectable interface {
+	// SetInput makes the command read r instead, it must be called before Run.
+	// r is closed once the command stops reading, if it's an io.Closer.
+	SetInput(r io.Reader)
 }
 
 type Command interface {
@@ -48,4 +139,15 @@ type Command interface {
 	State() CommandState
 	SetState(CommandState)
 	Resize(size *pty.Winsize) error
+	// ExitCode returns the exit status of a stopped command, or -1 while the
+	// command is still running or when it never got a status (e.g. protocol errors).
+	ExitCode() int
+	// Err returns why a stopped command failed: an *ExitError for a non-zero
+	// status, any other error when the shell couldn't run it at all.
+	Err() error
+	// Signal sends sig to whatever the command is running in the foreground.
+	// A queued command is dropped instead, a stopped one returns ErrNotRunning.
+	Signal(sig os.Signal) error
+	Interrupt() error
+	Kill() error
 }
@@ -0,0 +1,134 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Stream is one of the outputs of a command.
+type Stream int
+
+const (
+	Stdout Stream = iota
+	Stderr
+)
+
+// Teeable is implemented by commands whose output can go to more places while
+// they run, e.g. a file or another command.
+type Teeable interface {
+	// Subscribe writes what the command prints on stream from now on to w as well,
+	// until the command is done or stop is called. stop returns the first error of w.
+	Subscribe(stream Stream, w io.Writer) (stop func() error)
+}
+
+// subscriberQueue is how many writes a subscriber can fall behind.
+const subscriberQueue = 64
+
+// Subscribers pass what's written to them on to writers which come and go.
+// Each has its own queue, so a slow one only holds up Write once its queue is
+// full. Then the command waits for it instead of losing output.
+// The zero value has no subscribers.
+type Subscribers struct {
+	mu sync.Mutex
+	// Replaced, not changed, so Write can go through it without the lock
+	subs   []*subscriber
+	closed bool
+}
+
+type subscriber struct {
+	w     io.Writer
+	queue chan []byte
+	stop  chan struct{}
+	end   sync.Once
+	done  chan struct{}
+	err   error
+}
+
+// Subscribe passes everything written from now on to w as well, see Teeable.
+func (s *Subscribers) Subscribe(w io.Writer) (stop func() error) {
+	sub := &subscriber{
+		w:     w,
+		queue: make(chan []byte, subscriberQueue),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return func() error { return nil }
+	}
+	s.subs = append(slices.Clone(s.subs), sub)
+	s.mu.Unlock()
+
+	go sub.run()
+	return func() error {
+		s.mu.Lock()
+		s.subs = slices.DeleteFunc(slices.Clone(s.subs), func(o *subscriber) bool { return o == sub })
+		s.mu.Unlock()
+		sub.end.Do(func() { close(sub.stop) })
+		<-sub.done
+		return sub.err
+	}
+}
+
+// Write queues p for all subscribers. It waits while one of them is full.
+func (s *Subscribers) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	subs := s.subs
+	s.mu.Unlock()
+	if len(subs) == 0 {
+		return len(p), nil
+	}
+	// The caller reuses p
+	b := bytes.Clone(p)
+	for _, sub := range subs {
+		select {
+		case sub.queue <- b:
+		case <-sub.stop:
+		}
+	}
+	return len(p), nil
+}
+
+// Close waits until the subscribers wrote what's queued and drops them.
+// Later subscribers get nothing.
+func (s *Subscribers) Close() {
+	s.mu.Lock()
+	subs := s.subs
+	s.subs, s.closed = nil, true
+	s.mu.Unlock()
+	for _, sub := range subs {
+		sub.end.Do(func() { close(sub.stop) })
+		<-sub.done
+	}
+}
+
+func (sub *subscriber) run() {
+	defer close(sub.done)
+	for {
+		select {
+		case b := <-sub.queue:
+			sub.write(b)
+		case <-sub.stop:
+			// What's queued already still goes out
+			for {
+				select {
+				case b := <-sub.queue:
+					sub.write(b)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write gives up on w after the first error, but keeps emptying the queue
+// so Write doesn't wait for it.
+func (sub *subscriber) write(b []byte) {
+	if sub.err == nil {
+		_, sub.err = sub.w.Write(b)
+	}
+}
@@ -0,0 +1,91 @@
+package shell
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// safeBuilder can be read while a subscriber writes to it.
+type safeBuilder struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *safeBuilder) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *safeBuilder) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestSubscribers(t *testing.T) {
+	var subs Subscribers
+	subs.Write([]byte("before "))
+
+	var all, some safeBuilder
+	subs.Subscribe(&all)
+	stop := subs.Subscribe(&some)
+	buf := []byte("one ")
+	subs.Write(buf)
+	copy(buf, "xxx ")
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	subs.Write([]byte("two"))
+	subs.Close()
+
+	if got := all.String(); got != "one two" {
+		t.Errorf("got %q", got)
+	}
+	if got := some.String(); got != "one " {
+		t.Errorf("stopped subscribers get nothing more, got %q", got)
+	}
+	// Too late
+	var late safeBuilder
+	if err := subs.Subscribe(&late)(); err != nil || late.String() != "" {
+		t.Errorf("got %q, %v", late.String(), err)
+	}
+}
+
+func TestSubscribersBackPressure(t *testing.T) {
+	var subs Subscribers
+	r, w := io.Pipe()
+	stop := subs.Subscribe(w)
+
+	written := make(chan struct{})
+	go func() {
+		for range subscriberQueue + 2 {
+			subs.Write([]byte("x"))
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("a full subscriber should hold up Write")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b := make([]byte, 1)
+	for range subscriberQueue + 2 {
+		r.Read(b)
+	}
+	<-written
+
+	// A broken subscriber doesn't hold up anything
+	r.CloseWithError(io.ErrClosedPipe)
+	for range subscriberQueue * 2 {
+		subs.Write([]byte("x"))
+	}
+	if err := stop(); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("got %v, wanted the error of the writer", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	tea "charm.land/bubbletea/v2"
+
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/Melkor333/oils-readline/tiling"
+)
+
+// ShellInfo describes one of the model's shells for the widgets.
+type ShellInfo struct {
+	ID      uint64
+	Name    string
+	Shell   shell.Shell
+	Focused bool
+}
+
+// ShellsMsg is broadcast whenever shells are added, removed or focused.
+type ShellsMsg struct{ Shells []ShellInfo }
+
+// NewShellMsg asks the model to start another shell.
+// Interpreter is "osh" or "ysh", Dir the initial working directory (optional).
+type NewShellMsg struct {
+	Interpreter string
+	Dir         string
+}
+
+// FocusShellMsg makes the shell with ID the one commands are sent to.
+type FocusShellMsg struct{ ID uint64 }
+
+// CloseShellMsg stops the shell with ID and removes its widgets.
+type CloseShellMsg struct{ ID uint64 }
+
+type shellCreatedMsg struct {
+	shell shell.Shell
+	kind  string
+	err   error
+	// Runs in the new shell, see newShellFor
+	then func(shell.Shell) tea.Msg
+}
+
+// shellBinding ties a widget to one shell.
+// Widgets without a shell follow all of them.
+type shellBinding struct {
+	shell shell.Shell
+	name  string
+	// Only label the shell if there's more than one
+	others bool
+}
+
+func (b *shellBinding) BoundShell() shell.Shell { return b.shell }
+
+// follows reports whether messages about s are meant for this widget.
+func (b *shellBinding) follows(s shell.Shell) bool {
+	return b.shell == nil || s == nil || b.shell == s
+}
+
+// Title shows on the border which shell the widget belongs to.
+func (b *shellBinding) Title() string {
+	if !b.others {
+		return ""
+	}
+	return b.name
+}
+
+func (b *shellBinding) updateShells(msg ShellsMsg) {
+	b.others = len(msg.Shells) > 1
+	for _, info := range msg.Shells {
+		if info.Shell == b.shell {
+			b.name = info.Name
+		}
+	}
+}
+
+type shellBound interface {
+	BoundShell() shell.Shell
+}
+
+// newTrackedShell asks s what it is, if it knows.
+func newTrackedShell(s shell.Shell, id uint64) trackedShell {
+	t := trackedShell{Shell: s, id: id}
+	if i, ok := s.(interface{ Interpreter() string }); ok {
+		t.kind = i.Interpreter()
+	}
+	return t
+}
+
+func (t trackedShell) name() string {
+	if t.kind == "" {
+		return fmt.Sprintf("#%d", t.id)
+	}
+	return fmt.Sprintf("#%d %s", t.id, t.kind)
+}
+
+func (m *model) shellIndex(id uint64) int {
+	for i, s := range m.shells {
+		if s.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// shellID returns the id of s, false if it's not one of ours.
+func (m *model) shellID(s shell.Shell) (uint64, bool) {
+	for _, t := range m.shells {
+		if t.Shell == s {
+			return t.id, true
+		}
+	}
+	return 0, false
+}
+
+func (m *model) focusedShell() shell.Shell {
+	if m.shellFocus < 0 || m.shellFocus >= len(m.shells) {
+		return nil
+	}
+	return m.shells[m.shellFocus].Shell
+}
+
+func (m *model) shellsMsg() ShellsMsg {
+	var msg ShellsMsg
+	for i, s := range m.shells {
+		msg.Shells = append(msg.Shells, ShellInfo{ID: s.id, Name: s.name(), Shell: s.Shell, Focused: i == m.shellFocus})
+	}
+	return msg
+}
+
+func (m *model) broadcastShells() tea.Cmd {
+	msg := m.shellsMsg()
+	return func() tea.Msg { return msg }
+}
+
+// newShellCmd starts a shell outside of the update loop, it takes a moment.
+func (m *model) newShellCmd(kind, dir string) tea.Cmd {
+	newShell := m.newShell
+	return func() tea.Msg {
+		if newShell == nil {
+			return shellCreatedMsg{kind: kind, err: errors.New("no way to start shells")}
+		}
+		s, err := newShell(kind, dir)
+		return shellCreatedMsg{shell: s, kind: kind, err: err}
+	}
+}
+
+// newShellFor starts a shell like the focused one for then, e.g. to run a
+// command while the others are busy. then gets nil if it didn't start.
+func (m *model) newShellFor(then func(shell.Shell) tea.Msg) tea.Cmd {
+	like := m.likeFocused()
+	create := m.newShellCmd(like.Interpreter, like.Dir)
+	return func() tea.Msg {
+		msg := create().(shellCreatedMsg)
+		msg.then = then
+		return msg
+	}
+}
+
+// likeFocused opens a new shell of the same kind in the focused shell's directory.
+func (m *model) likeFocused() NewShellMsg {
+	msg := NewShellMsg{Interpreter: "ysh"}
+	if m.shellFocus < len(m.shells) {
+		s := m.shells[m.shellFocus]
+		if s.kind != "" {
+			msg.Interpreter = s.kind
+		}
+		msg.Dir = s.Dir()
+	}
+	return msg
+}
+
+// shellCreated adds the shell with its own prompt and terminal and focuses it.
+func (m *model) shellCreated(msg shellCreatedMsg) tea.Cmd {
+	if msg.err != nil {
+		log.Printf("Can't start %s: %v", msg.kind, msg.err)
+		if msg.then != nil {
+			msg.then(nil)
+		}
+		return nil
+	}
+	wait := m.AddShell(msg.shell)
+	m.shellFocus = len(m.shells) - 1
+	cmds := []tea.Cmd{
+		wait,
+		AddWidget(newBasicPrompt(msg.shell, m.history)),
+		AddWidget(newTerminal(msg.shell)),
+		m.broadcastShells(),
+	}
+	if msg.then != nil {
+		cmds = append(cmds, func() tea.Msg { return msg.then(msg.shell) })
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *model) focusShell(id uint64) tea.Cmd {
+	i := m.shellIndex(id)
+	if i < 0 {
+		return nil
+	}
+	m.shellFocus = i
+	cmds := []tea.Cmd{m.broadcastShells()}
+	// Focus the shell's prompt
+	for _, w := range m.widgets {
+		if _, ok := w.Model.(*basicPrompt); !ok {
+			continue
+		}
+		if b, ok := w.Model.(shellBound); ok && b.BoundShell() == m.shells[i].Shell {
+			cmds = append(cmds, func() tea.Msg { return tiling.RequestFocusMsg{Model: w} })
+			break
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *model) closeShell(id uint64) tea.Cmd {
+	i := m.shellIndex(id)
+	if i < 0 {
+		return nil
+	}
+	// removeShellMsg follows once it stopped
+	s := m.shells[i].Shell
+	return func() tea.Msg { s.Cancel(); return nil }
+}
+
+// removeShell forgets a stopped shell and removes the widgets bound to it.
+func (m *model) removeShell(s shell.Shell) tea.Cmd {
+	i := slices.IndexFunc(m.shells, func(t trackedShell) bool { return t.Shell == s })
+	if i < 0 {
+		return nil
+	}
+	m.shells = slices.Delete(m.shells, i, i+1)
+	if m.shellFocus >= i && m.shellFocus > 0 {
+		m.shellFocus--
+	}
+	var cmds []tea.Cmd
+	for _, w := range slices.Clone(m.widgets) {
+		if b, ok := w.Model.(shellBound); ok && b.BoundShell() == s {
+			cmds = append(cmds, m.RemoveChild(w))
+		}
+	}
+	cmds = append(cmds, m.broadcastShells())
+	return tea.Batch(cmds...)
+}
+
+// cycleShell focuses the next (or with -1 the previous) shell.
+func (m *model) cycleShell(step int) tea.Cmd {
+	if len(m.shells) < 2 {
+		return nil
+	}
+	i := (m.shellFocus + step + len(m.shells)) % len(m.shells)
+	return m.focusShell(m.shells[i].id)
+}
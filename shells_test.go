@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/Melkor333/oils-readline/widget"
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/assert"
+)
+
+// namedShell knows what it is and remembers its commands.
+type namedShell struct {
+	MockShell
+	kind     string
+	dir      string
+	commands []string
+	// What ran without a Command
+	runs []string
+}
+
+func (s *namedShell) Interpreter() string { return s.kind }
+func (s *namedShell) Dir() string         { return s.dir }
+func (s *namedShell) Command(cmd string, size *pty.Winsize) (shell.Command, error) {
+	s.commands = append(s.commands, cmd)
+	return newFakeCmd(cmd, ""), nil
+}
+func (s *namedShell) Run(cmd string, ptmx, tty, stderr *os.File) error {
+	s.runs = append(s.runs, cmd)
+	ptmx.Close()
+	tty.Close()
+	stderr.Close()
+	return nil
+}
+
+func newShellModel() (*model, *namedShell) {
+	first := &namedShell{kind: "ysh", dir: "/src"}
+	m := NewModel([]shell.Shell{first}, nil)
+	m.newShell = func(kind, dir string) (shell.Shell, error) {
+		return &namedShell{kind: kind, dir: dir}, nil
+	}
+	return m, first
+}
+
+// update runs msg and the command it returns, which must not be a batch
+func update(t *testing.T, m *model, msg tea.Msg) tea.Msg {
+	t.Helper()
+	_, cmd := m.Update(msg)
+	if cmd == nil {
+		return nil
+	}
+	return cmd()
+}
+
+func TestModelNewShell(t *testing.T) {
+	m, _ := newShellModel()
+
+	created := update(t, m, NewShellMsg{Interpreter: "osh", Dir: "/tmp"})
+	assert.IsType(t, shellCreatedMsg{}, created)
+	m.Update(created)
+
+	if assert.Len(t, m.shells, 2) {
+		assert.Equal(t, 1, m.shellFocus, "the new shell is focused")
+		assert.Equal(t, "/tmp", m.shells[1].Dir())
+	}
+	var names []string
+	for _, info := range m.shellsMsg().Shells {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{"#0 ysh", "#1 osh"}, names)
+
+	assert.Equal(t, NewShellMsg{Interpreter: "osh", Dir: "/tmp"}, m.likeFocused())
+}
+
+func TestModelCommandGoesToItsShell(t *testing.T) {
+	m, first := newShellModel()
+	m.Update(update(t, m, NewShellMsg{Interpreter: "osh"}))
+	second := m.shells[1].Shell.(*namedShell)
+
+	m.Update(CommandEnteredMsg{Text: "echo focused"})
+	m.Update(CommandEnteredMsg{Text: "echo first", Shell: first})
+	assert.Equal(t, []string{"echo first"}, first.commands)
+	assert.Equal(t, []string{"echo focused"}, second.commands)
+
+	m.Update(FocusShellMsg{ID: 0})
+	assert.Equal(t, 0, m.shellFocus)
+	m.Update(tea.KeyPressMsg{Code: 'n', Mod: tea.ModAlt})
+	assert.Equal(t, 1, m.shellFocus, "alt+n cycles through the shells")
+}
+
+func TestModelRemoveShell(t *testing.T) {
+	m, first := newShellModel()
+	m.Update(update(t, m, NewShellMsg{Interpreter: "osh"}))
+	second := m.shells[1].Shell
+
+	m.addWidget(&widget.Widget{Model: newStdoutViewer(first)})
+	m.addWidget(&widget.Widget{Model: newStdoutViewer(second)})
+	m.addWidget(&widget.Widget{Model: newStdoutViewer(nil)})
+
+	m.Update(removeShellMsg{second})
+	assert.Len(t, m.shells, 1)
+	if id, ok := m.shellID(first); assert.True(t, ok) {
+		assert.Equal(t, uint64(0), id)
+	}
+	_, ok := m.shellID(second)
+	assert.False(t, ok, "it's not ours anymore")
+	assert.Equal(t, 0, m.shellFocus)
+	assert.Len(t, m.widgets, 2, "only the widgets of the closed shell are removed")
+	for _, w := range m.widgets {
+		assert.NotEqual(t, second, w.Model.(shellBound).BoundShell())
+	}
+
+	m.Update(removeShellMsg{first})
+	assert.Empty(t, m.shells)
+	// Nothing left to run it, but it mustn't crash
+	m.Update(CommandEnteredMsg{Text: "echo lost"})
+}
+
+func TestShellBinding(t *testing.T) {
+	first, second := &namedShell{kind: "ysh"}, &namedShell{kind: "osh"}
+	h := newStdoutViewer(first)
+	h.Update(tea.WindowSizeMsg{Width: 40, Height: 10})
+
+	h.Update(shell.CommandMsg{Cmd: &fakeCommand{commandLine: "other"}, Shell: second})
+	assert.Nil(t, h.command, "commands of other shells are ignored")
+	h.Update(shell.CommandMsg{Cmd: &fakeCommand{commandLine: "mine"}, Shell: first})
+	assert.NotNil(t, h.command)
+
+	shells := ShellsMsg{Shells: []ShellInfo{{Name: "#0 ysh", Shell: first}}}
+	h.Update(shells)
+	assert.Empty(t, h.Title(), "no need for a title with a single shell")
+	shells.Shells = append(shells.Shells, ShellInfo{Name: "#1 osh", Shell: second})
+	h.Update(shells)
+	assert.Equal(t, "#0 ysh", h.Title())
+
+	bp := newBasicPrompt(&promptShell{prompt: "~ $ "}, nil)
+	bp.Update(ShellsMsg{Shells: []ShellInfo{{Name: "#0 ysh", Shell: bp.shell}, {Name: "#1 osh", Shell: second}}})
+	assert.Contains(t, bp.prompt, "[#0 ysh] ~ $ ")
+}
+
+func TestShellSelector(t *testing.T) {
+	first, second := &namedShell{kind: "ysh", dir: "/src"}, &namedShell{kind: "osh", dir: "/tmp"}
+	ss := newShellSelector(ShellsMsg{Shells: []ShellInfo{
+		{ID: 0, Name: "#0 ysh", Shell: first},
+		{ID: 3, Name: "#3 osh", Shell: second, Focused: true},
+	}})
+	ss.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	assert.Equal(t, 1, ss.cursor, "starts at the focused shell")
+	assert.Contains(t, ss.View().Content, "#3 osh  /tmp")
+
+	ss.Update(tea.KeyPressMsg{Code: 'k', Text: "k"})
+	sel, _ := ss.selected()
+	assert.Equal(t, uint64(0), sel.ID)
+	assert.Equal(t, NewShellMsg{Interpreter: "osh", Dir: "/src"}, ss.newShell("osh"), "new shells open where the selected one is")
+
+	_, cmd := ss.Update(tea.KeyPressMsg{Code: tea.KeyEscape})
+	assert.Equal(t, CloseSelectorMsg{}, cmd())
+}
@@ -8,6 +8,7 @@ import (
 	"charm.land/lipgloss/v2"
 
 	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/x/ansi"
 	"github.com/creack/pty"
 	"github.com/muesli/reflow/wrap"
 
@@ -17,6 +18,7 @@ import (
 )
 
 type StdoutViewer struct {
+	shellBinding
 	command         shell.Command
 	view            viewport.Model
 	targetIndex     int
@@ -24,6 +26,7 @@ type StdoutViewer struct {
 	showStderr      bool
 	interactiveMode bool
 	exitMenuSelect  menuSelection
+	search          outputSearch
 	Width           int
 	Height          int
 }
@@ -32,6 +35,22 @@ func (h *StdoutViewer) commandRunning() bool {
 	return h.command != nil && (h.command.State() == shell.Queued || h.command.State() == shell.Started)
 }
 
+// commandFailed reports whether c stopped with a non-zero status or didn't run at all.
+func commandFailed(c shell.Command) bool {
+	return c != nil && c.State() == shell.Stopped && c.Err() != nil
+}
+
+// exitStatus renders why a failed command stopped, or "" if it didn't fail.
+func exitStatus(c shell.Command) string {
+	if !commandFailed(c) {
+		return ""
+	}
+	if code := c.ExitCode(); code >= 0 {
+		return highlightColor.Render(fmt.Sprintf("✘ %d", code))
+	}
+	return highlightColor.Render("✘ " + c.Err().Error())
+}
+
 var (
 	activeColor    = lipgloss.NewStyle().Foreground(lipgloss.Color("10")) // bright green
 	inactiveColor  = lipgloss.NewStyle().Foreground(lipgloss.Color("22")) // dark green
@@ -47,12 +66,13 @@ const (
 	menuSelectCancel
 )
 
-func newStdoutViewer() *StdoutViewer {
-	return &StdoutViewer{targetIndex: -1, currentIndex: -1, exitMenuSelect: menuSelectHidden}
+// newStdoutViewer shows the commands of s, or of all shells if s is nil.
+func newStdoutViewer(s shell.Shell) *StdoutViewer {
+	return &StdoutViewer{shellBinding: shellBinding{shell: s}, targetIndex: -1, currentIndex: -1, exitMenuSelect: menuSelectHidden, search: newOutputSearch()}
 }
 
-func newStderrViewer() *StdoutViewer {
-	return &StdoutViewer{targetIndex: -1, currentIndex: -1, showStderr: true, exitMenuSelect: menuSelectHidden}
+func newStderrViewer(s shell.Shell) *StdoutViewer {
+	return &StdoutViewer{shellBinding: shellBinding{shell: s}, targetIndex: -1, currentIndex: -1, showStderr: true, exitMenuSelect: menuSelectHidden, search: newOutputSearch()}
 }
 
 func (h *StdoutViewer) Init() tea.Cmd {
@@ -66,6 +86,16 @@ func (h *StdoutViewer) WriteStdin(b []byte) (int, error) {
 	return h.command.Stdin().Write(b)
 }
 
+// sendSignal delivers a signal (e.g. Command.Interrupt) outside of the update loop.
+func sendSignal(send func() error) tea.Cmd {
+	return func() tea.Msg {
+		if err := send(); err != nil {
+			log.Printf("Can't signal command: %v", err)
+		}
+		return nil
+	}
+}
+
 func (h *StdoutViewer) IsInteractive() bool {
 	return h.interactiveMode
 }
@@ -79,6 +109,14 @@ func (h *StdoutViewer) requestHistoryEntry(index int) tea.Cmd {
 func (h *StdoutViewer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyPressMsg:
+		if h.search.active {
+			done, cmd := h.search.key(msg)
+			h.updateContent()
+			if done {
+				return h, ReleaseCapture()
+			}
+			return h, cmd
+		}
 		if h.interactiveMode {
 			switch msg.String() {
 			case "enter":
@@ -90,10 +128,10 @@ func (h *StdoutViewer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 				case menuSelectSendctrlc:
 					h.exitMenuSelect = menuSelectHidden
-					return h, func() tea.Msg {
-						h.WriteStdin([]byte{'\x03'})
-						return nil
+					if h.command == nil {
+						return h, nil
 					}
+					return h, sendSignal(h.command.Interrupt)
 				case menuSelectExit:
 					h.interactiveMode = false
 					h.exitMenuSelect = menuSelectHidden
@@ -148,6 +186,29 @@ func (h *StdoutViewer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				return h, RequestCapture()
 			}
+		case "r", "R", "i":
+			return h, rerunKeys(msg.String(), h.command, h.shell)
+		case "p":
+			return h, pipeKey(h.command, h.showStderr, h.shell)
+		case "t", "T":
+			return h, teeKeys(msg.String(), h.command, h.showStderr)
+		case "/":
+			// Keys like esc must not go elsewhere while typing
+			return h, tea.Batch(RequestCapture(), h.search.start())
+		case "n":
+			h.view.HighlightNext()
+			return h, nil
+		case "N":
+			h.view.HighlightPrevious()
+			return h, nil
+		case "x":
+			if h.commandRunning() {
+				return h, sendSignal(h.command.Interrupt)
+			}
+		case "X":
+			if h.commandRunning() {
+				return h, sendSignal(h.command.Kill)
+			}
 		case "h":
 			if h.targetIndex >= 0 {
 				h.targetIndex -= 1
@@ -176,7 +237,14 @@ func (h *StdoutViewer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			h.view, cmd = h.view.Update(msg)
 			return h, cmd
 		}
+	case ShellsMsg:
+		h.updateShells(msg)
+		return h, nil
+
 	case shell.CommandMsg:
+		if !h.follows(msg.Shell) {
+			return h, nil
+		}
 		h.interactiveMode = false
 		h.exitMenuSelect = menuSelectHidden
 		if h.targetIndex < 0 {
@@ -192,6 +260,9 @@ func (h *StdoutViewer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return h, ReleaseCapture()
 
 	case shell.CommandDoneMsg:
+		if !h.follows(msg.Shell) {
+			return h, nil
+		}
 		if h.interactiveMode {
 			h.interactiveMode = false
 			h.exitMenuSelect = menuSelectHidden
@@ -274,7 +345,7 @@ func (h *StdoutViewer) View() tea.View {
 	}
 
 	cmdLine := h.command.CommandLine()
-	if h.showStderr {
+	if h.showStderr || commandFailed(h.command) {
 		cmdLine = highlightColor.Render(cmdLine)
 	}
 
@@ -282,14 +353,25 @@ func (h *StdoutViewer) View() tea.View {
 		cmdLine = activeColor.Render("● ") + cmdLine
 	}
 
+	if status := exitStatus(h.command); status != "" {
+		cmdLine = cmdLine + " " + status
+	}
+
 	if h.interactiveMode {
 		cmdLine = cmdLine + " " + highlightColor.Render("[interactive]")
 	}
 
+	if status := h.search.status(); status != "" {
+		cmdLine = cmdLine + " " + status
+	}
+
 	sticky := inactiveColor
 	if h.targetIndex != h.currentIndex || h.targetIndex < 0 {
 		sticky = activeColor
 	}
+	if commandFailed(h.command) {
+		sticky = highlightColor
+	}
 	if h.currentIndex >= 0 {
 		i := sticky.Render(fmt.Sprintf("[%d]", h.currentIndex))
 		return tea.NewView(fmt.Sprintf("%v %s\n%s", i, cmdLine, h.view.View()))
@@ -305,6 +387,15 @@ func (h *StdoutViewer) updateContent() {
 	if h.showStderr {
 		output = h.command.Stderr()
 	}
-	output = wrap.String(output, h.Width)
-	h.view.SetContent(output)
+	if h.search.pattern == nil {
+		h.view.SetContent(wrap.String(output, h.Width))
+		h.search.highlight(&h.view, "", "")
+		return
+	}
+	// The viewport finds the highlights in the text without escape sequences.
+	// Only newlines are added by wrapping, so the matches can be moved to them.
+	output = ansi.Strip(output)
+	wrapped := ansi.Hardwrap(output, h.Width, true)
+	h.view.SetContent(wrapped)
+	h.search.highlight(&h.view, output, wrapped)
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"io"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
 
 	tea "charm.land/bubbletea/v2"
@@ -16,21 +18,38 @@ type fakeCommand struct {
 	stdout      string
 	stderr      string
 	state       shell.CommandState
+	exitCode    int
+	err         error
+	signals     []os.Signal
+	input       io.Reader
+	subs        [2]shell.Subscribers
 }
 
-func (f *fakeCommand) Run()                          {}
-func (f *fakeCommand) Resize(_ *pty.Winsize) error   { return nil }
-func (f *fakeCommand) CommandLine() string           { return f.commandLine }
-func (f *fakeCommand) Wait()                         {}
-func (f *fakeCommand) Stdin() io.Writer              { return io.Discard }
-func (f *fakeCommand) Stdout() string                { return f.stdout }
-func (f *fakeCommand) Stderr() string                { return f.stderr }
-func (f *fakeCommand) SetStdout(stdout io.Reader)    {}
-func (f *fakeCommand) SetStdin(stdin io.Writer)      {}
+func (f *fakeCommand) Run()                        {}
+func (f *fakeCommand) Resize(_ *pty.Winsize) error { return nil }
+func (f *fakeCommand) CommandLine() string         { return f.commandLine }
+func (f *fakeCommand) Wait()                       {}
+func (f *fakeCommand) Stdin() io.Writer            { return io.Discard }
+func (f *fakeCommand) Stdout() string              { return f.stdout }
+func (f *fakeCommand) Stderr() string              { return f.stderr }
+func (f *fakeCommand) SetStdout(stdout io.Reader)  {}
+func (f *fakeCommand) SetStdin(stdin io.Writer)    {}
+func (f *fakeCommand) SetInput(r io.Reader)        { f.input = r }
+func (f *fakeCommand) Subscribe(stream shell.Stream, w io.Writer) func() error {
+	return f.subs[stream].Subscribe(w)
+}
 func (f *fakeCommand) SetOnStdout(fn func())         {}
 func (f *fakeCommand) SetOnStderr(fn func())         {}
 func (f *fakeCommand) State() shell.CommandState     { return f.state }
 func (f *fakeCommand) SetState(s shell.CommandState) { f.state = s }
+func (f *fakeCommand) ExitCode() int                 { return f.exitCode }
+func (f *fakeCommand) Err() error                    { return f.err }
+func (f *fakeCommand) Signal(sig os.Signal) error {
+	f.signals = append(f.signals, sig)
+	return nil
+}
+func (f *fakeCommand) Interrupt() error { return f.Signal(syscall.SIGINT) }
+func (f *fakeCommand) Kill() error      { return f.Signal(syscall.SIGKILL) }
 
 func newFakeCmd(cmdLine, output string) shell.Command {
 	return &fakeCommand{commandLine: cmdLine, stdout: output}
@@ -43,7 +62,7 @@ func updateStdoutViewer(t *testing.T, h tea.Model, msg tea.Msg) *StdoutViewer {
 }
 
 func TestStdoutViewerShowsLastCommand(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd1 := newFakeCmd("echo hello", "hello\n")
 	cmd2 := newFakeCmd("echo world", "world\n")
@@ -67,7 +86,7 @@ func TestStdoutViewerShowsLastCommand(t *testing.T) {
 }
 
 func TestStdoutViewerStdoutUpdatesContent(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd := newFakeCmd("ls", "")
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -84,7 +103,7 @@ func TestStdoutViewerStdoutUpdatesContent(t *testing.T) {
 }
 
 func TestStdoutViewerReplacesPreviousCommand(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd1 := newFakeCmd("echo first", "first\n")
 	cmd2 := newFakeCmd("echo second", "second\n")
@@ -106,7 +125,7 @@ func TestStdoutViewerReplacesPreviousCommand(t *testing.T) {
 }
 
 func TestStdoutViewerStdoutForOlderCommandIgnored(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd1 := newFakeCmd("echo old", "old\n")
 	cmd2 := newFakeCmd("echo new", "new\n")
@@ -121,13 +140,13 @@ func TestStdoutViewerStdoutForOlderCommandIgnored(t *testing.T) {
 }
 
 func TestStdoutViewerViewEmpty(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 	v := h.View()
 	assert.Equal(t, "", v.Content)
 }
 
 func TestStdoutViewerCommandAlwaysVisible(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	var longOutput strings.Builder
 	for range 50 {
@@ -149,7 +168,7 @@ func TestStdoutViewerCommandAlwaysVisible(t *testing.T) {
 }
 
 func TestStdoutViewerScrollingWithJK(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	var longOutput strings.Builder
 	for i := range 50 {
@@ -175,7 +194,7 @@ func TestStdoutViewerScrollingWithJK(t *testing.T) {
 }
 
 func TestStdoutViewerWindowSizeUpdate(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd := newFakeCmd("echo hi", "hi\n")
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -187,7 +206,7 @@ func TestStdoutViewerWindowSizeUpdate(t *testing.T) {
 }
 
 func TestStdoutViewerMultipleStdoutUpdates(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd := newFakeCmd("stream-cmd", "")
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -205,7 +224,7 @@ func TestStdoutViewerMultipleStdoutUpdates(t *testing.T) {
 }
 
 func TestStdoutViewerToggleStderr(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd := &fakeCommand{commandLine: "my-cmd", stdout: "out\n", stderr: "err\n"}
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -231,7 +250,7 @@ func TestStdoutViewerToggleStderr(t *testing.T) {
 }
 
 func TestStdoutViewerStderrRedCommandLine(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 
 	cmd := &fakeCommand{commandLine: "fail-cmd", stdout: "ok\n", stderr: "oops\n"}
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -247,7 +266,7 @@ func TestStdoutViewerStderrRedCommandLine(t *testing.T) {
 }
 
 func TestStdoutViewerStderrMsgUpdatesContent(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 	cmd := &fakeCommand{commandLine: "cmd", stdout: "", stderr: ""}
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
 	h = updateStdoutViewer(t, h, shell.CommandMsg{Cmd: cmd})
@@ -261,7 +280,7 @@ func TestStdoutViewerStderrMsgUpdatesContent(t *testing.T) {
 }
 
 func TestStdoutViewerRunningState(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 	cmd := newFakeCmd("echo hi", "hi\n")
 
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -284,7 +303,7 @@ func TestStdoutViewerRunningState(t *testing.T) {
 }
 
 func TestStdoutViewerRunningIndicatorInView(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 	cmd := newFakeCmd("echo hi", "hi\n")
 
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -302,7 +321,7 @@ func TestStdoutViewerRunningIndicatorInView(t *testing.T) {
 }
 
 func TestStdoutViewerInteractiveModeRequiresRunning(t *testing.T) {
-	h := newStdoutViewer()
+	h := newStdoutViewer(nil)
 	cmd := newFakeCmd("sleep 1", "")
 
 	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -326,3 +345,122 @@ func TestStdoutViewerInteractiveModeRequiresRunning(t *testing.T) {
 	assert.False(t, h.interactiveMode, "should NOT enter interactive mode when not running")
 	assert.Nil(t, cmd2, "should NOT return RequestCapture when not running")
 }
+
+func TestStdoutViewerSignalKeys(t *testing.T) {
+	h := newStdoutViewer(nil)
+	cmd := &fakeCommand{commandLine: "sleep 10"}
+
+	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
+	h = updateStdoutViewer(t, h, shell.CommandMsg{Cmd: cmd})
+
+	// Not running — nothing to signal
+	_, c := h.Update(tea.KeyPressMsg{Code: 'x', Text: "x"})
+	assert.Nil(t, c, "x should do nothing if the command isn't running")
+
+	cmd.SetState(shell.Started)
+	_, c = h.Update(tea.KeyPressMsg{Code: 'x', Text: "x"})
+	assert.NotNil(t, c)
+	c()
+	_, c = h.Update(tea.KeyPressMsg{Code: 'x', ShiftedCode: 'X', Mod: tea.ModShift, Text: "X"})
+	assert.NotNil(t, c)
+	c()
+	assert.Equal(t, []os.Signal{syscall.SIGINT, syscall.SIGKILL}, cmd.signals)
+	assert.False(t, h.interactiveMode, "signaling shouldn't enter interactive mode")
+}
+
+func TestStdoutViewerFailedCommand(t *testing.T) {
+	h := newStdoutViewer(nil)
+	cmd := &fakeCommand{commandLine: "false", state: shell.Stopped, exitCode: 1, err: &shell.ExitError{Status: 1}}
+
+	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
+	h = updateStdoutViewer(t, h, shell.CommandMsg{Cmd: cmd})
+
+	fullView := h.View().Content
+	assert.True(t, commandFailed(h.command))
+	assert.Contains(t, fullView, "false")
+	assert.Contains(t, fullView, "✘ 1", "exit status should be shown for failed commands")
+
+	ok := &fakeCommand{commandLine: "true", state: shell.Stopped}
+	h = updateStdoutViewer(t, h, shell.CommandMsg{Cmd: ok})
+	assert.False(t, commandFailed(h.command))
+	assert.NotContains(t, h.View().Content, "✘")
+}
+
+func TestStdoutViewerRunningCommandNotFailed(t *testing.T) {
+	cmd := &fakeCommand{commandLine: "sleep 1", state: shell.Started, err: &shell.ExitError{Status: 1}}
+	assert.False(t, commandFailed(cmd), "only stopped commands can fail")
+	assert.Equal(t, "", exitStatus(cmd))
+}
+
+func TestStdoutViewerSearch(t *testing.T) {
+	h := newStdoutViewer(nil)
+	cmd := &fakeCommand{commandLine: "log", stdout: "foo 1\nbar\nfoo 2\n", stderr: "oops\n", state: shell.Started}
+	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
+	h = updateStdoutViewer(t, h, shell.CommandMsg{Cmd: cmd})
+
+	_, c := h.Update(tea.KeyPressMsg{Code: '/', Text: "/"})
+	assert.Contains(t, batch(c), requestCaptureMsg{}, "typing the pattern needs all keys")
+	typeText(h, "fo+")
+	assert.Contains(t, h.View().Content, "2 matches")
+	_, c = h.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	assert.Equal(t, releaseCaptureMsg{}, c())
+	assert.Contains(t, h.View().Content, "/fo+ 2 matches")
+
+	// Output of the running command is searched too
+	cmd.stdout += "foo 3\n"
+	h = updateStdoutViewer(t, h, shell.StdoutMsg{Cmd: cmd})
+	assert.Contains(t, h.View().Content, "/fo+ 3 matches")
+
+	h = updateStdoutViewer(t, h, tea.KeyPressMsg{Code: 'e'})
+	assert.Contains(t, h.View().Content, "/fo+ 0 matches")
+	h = updateStdoutViewer(t, h, tea.KeyPressMsg{Code: 'e'})
+
+	// esc goes back to the previous pattern
+	h.Update(tea.KeyPressMsg{Code: '/', Text: "/"})
+	typeText(h, "(")
+	assert.Contains(t, h.View().Content, "missing closing )")
+	h.Update(tea.KeyPressMsg{Code: tea.KeyEscape})
+	assert.Contains(t, h.View().Content, "/fo+ 3 matches")
+	assert.False(t, h.search.active)
+}
+
+func TestStdoutViewerSearchWrapped(t *testing.T) {
+	h := newStdoutViewer(nil)
+	// needle is split over the first two rows
+	cmd := &fakeCommand{commandLine: "log", stdout: "0123456789012345nee dle\nneedle\n"}
+	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 20, Height: 10})
+	h = updateStdoutViewer(t, h, shell.CommandMsg{Cmd: cmd})
+
+	h.Update(tea.KeyPressMsg{Code: '/', Text: "/"})
+	typeText(h, "nee ?dle")
+	h.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	assert.Contains(t, h.View().Content, "/nee ?dle 2 matches")
+	assert.Equal(t, "0123456789012345nee \ndle\nneedle\n", h.view.GetContent(), "wrapping only adds newlines")
+
+	assert.Equal(t, [][]int{{16, 24}, {25, 31}}, h.search.find(cmd.stdout, h.view.GetContent()))
+}
+
+func TestStdoutViewerSearchNavigation(t *testing.T) {
+	h := newStdoutViewer(nil)
+	var out strings.Builder
+	for i := range 30 {
+		if i == 10 || i == 20 {
+			out.WriteString("match\n")
+		} else {
+			out.WriteString("line\n")
+		}
+	}
+	cmd := &fakeCommand{commandLine: "gen", stdout: out.String()}
+	h = updateStdoutViewer(t, h, tea.WindowSizeMsg{Width: 80, Height: 5})
+	h = updateStdoutViewer(t, h, shell.CommandMsg{Cmd: cmd})
+
+	h.Update(tea.KeyPressMsg{Code: '/', Text: "/"})
+	typeText(h, "match")
+	h.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	first := h.view.YOffset()
+	assert.True(t, first > 6 && first <= 10, "scrolled to the first match, got %d", first)
+	h = updateStdoutViewer(t, h, tea.KeyPressMsg{Code: 'n', Text: "n"})
+	assert.True(t, h.view.YOffset() > 16, "scrolled to the second match, got %d", h.view.YOffset())
+	h = updateStdoutViewer(t, h, tea.KeyPressMsg{Code: 'N', Text: "N"})
+	assert.Equal(t, first, h.view.YOffset())
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"charm.land/bubbles/v2/textinput"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+
+	"github.com/Melkor333/oils-readline/history"
+	"github.com/Melkor333/oils-readline/shell"
+)
+
+var errTeeTarget = errors.New("expected > FILE, >> FILE or | COMMAND")
+
+// OpenTeeMsg asks where else the output of Cmd should go, see TeeMsg.
+type OpenTeeMsg struct {
+	Cmd    shell.Command
+	Stderr bool
+}
+
+// TeeMsg sends what the running Cmd prints from now on to Target as well.
+// Target is `> file`, `>> file` to append, or `| command line`, which runs in
+// a new shell since the one of Cmd is busy.
+type TeeMsg struct {
+	Cmd    shell.Command
+	Stderr bool
+	Target string
+}
+
+// UnteeMsg stops sending the output of Cmd elsewhere.
+type UnteeMsg struct{ Cmd shell.Command }
+
+// teeKeys handles the keys of viewers showing a command: t tees what they
+// show, T stops that again.
+func teeKeys(key string, c shell.Command, stderr bool) tea.Cmd {
+	if c == nil {
+		return nil
+	}
+	var msg tea.Msg
+	switch key {
+	case "t":
+		msg = OpenTeeMsg{Cmd: c, Stderr: stderr}
+	case "T":
+		msg = UnteeMsg{Cmd: c}
+	default:
+		return nil
+	}
+	return func() tea.Msg { return msg }
+}
+
+// parseTee splits the Target of a TeeMsg.
+func parseTee(target string) (path string, flag int, command string, err error) {
+	target = strings.TrimSpace(target)
+	switch {
+	case strings.HasPrefix(target, "|"):
+		command = strings.TrimSpace(target[1:])
+	case strings.HasPrefix(target, ">>"):
+		path, flag = strings.TrimSpace(target[2:]), os.O_APPEND
+	case strings.HasPrefix(target, ">"):
+		path, flag = strings.TrimSpace(target[1:]), os.O_TRUNC
+	}
+	if path == "" && command == "" {
+		return "", 0, "", fmt.Errorf("%w: %q", errTeeTarget, target)
+	}
+	return path, flag, command, nil
+}
+
+// lfWriter turns the \r\n of a pty back into \n.
+type lfWriter struct {
+	w io.Writer
+	// The last write ended in \r
+	cr bool
+}
+
+func (l *lfWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+1)
+	if l.cr && (len(p) == 0 || p[0] != '\n') {
+		out = append(out, '\r')
+	}
+	l.cr = false
+	for i, b := range p {
+		if b == '\r' {
+			if i == len(p)-1 {
+				l.cr = true
+				continue
+			}
+			if p[i+1] == '\n' {
+				continue
+			}
+		}
+		out = append(out, b)
+	}
+	_, err := l.w.Write(out)
+	return len(p), err
+}
+
+func (m *model) tee(msg TeeMsg) tea.Cmd {
+	t, ok := history.Unwrap(msg.Cmd).(shell.Teeable)
+	if !ok || msg.Cmd.State() == shell.Stopped {
+		log.Printf("Can't tee %q, it's not running", msg.Cmd.CommandLine())
+		return nil
+	}
+	path, flag, command, err := parseTee(msg.Target)
+	if err != nil {
+		log.Printf("Can't tee %q: %v", msg.Cmd.CommandLine(), err)
+		return nil
+	}
+	stream := shell.Stdout
+	if msg.Stderr {
+		stream = shell.Stderr
+	}
+	sink := func(w io.Writer) io.Writer {
+		if stream == shell.Stdout {
+			return &lfWriter{w: w}
+		}
+		return w
+	}
+	c := msg.Cmd
+	// The files are closed once the command is done
+	wait := func() tea.Msg {
+		c.Wait()
+		return UnteeMsg{Cmd: c}
+	}
+
+	if command != "" {
+		r, w := io.Pipe()
+		stop := t.Subscribe(stream, sink(w))
+		m.tees[c] = append(m.tees[c], func() error {
+			err := stop()
+			w.Close()
+			return err
+		})
+		return tea.Batch(wait, m.newShellFor(func(s shell.Shell) tea.Msg {
+			if s == nil {
+				// Don't hold up c
+				r.Close()
+				return nil
+			}
+			return CommandEnteredMsg{Text: command, Shell: s, PipeFrom: c, PipeStderr: msg.Stderr, Input: r}
+		}))
+	}
+
+	// Like a redirect in its shell
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.history.EntryOf(c).Cwd, path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		log.Printf("Can't tee %q: %v", c.CommandLine(), err)
+		return nil
+	}
+	stop := t.Subscribe(stream, sink(f))
+	m.tees[c] = append(m.tees[c], func() error {
+		err := stop()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	return wait
+}
+
+// untee stops all tees of c.
+func (m *model) untee(c shell.Command) tea.Cmd {
+	stops := m.tees[c]
+	delete(m.tees, c)
+	if len(stops) == 0 {
+		return nil
+	}
+	// They wait for what's queued
+	return func() tea.Msg {
+		for _, stop := range stops {
+			if err := stop(); err != nil {
+				log.Printf("Tee of %q failed: %v", c.CommandLine(), err)
+			}
+		}
+		return nil
+	}
+}
+
+// TeeDialog asks where the output of a command should go as well.
+type TeeDialog struct {
+	cmd    shell.Command
+	stderr bool
+	input  textinput.Model
+	err    error
+	width  int
+	height int
+}
+
+func newTeeDialog(msg OpenTeeMsg) *TeeDialog {
+	d := &TeeDialog{cmd: msg.Cmd, stderr: msg.Stderr, input: textinput.New()}
+	d.input.Placeholder = "> file, >> file or | command"
+	return d
+}
+
+func (d *TeeDialog) Init() tea.Cmd {
+	return d.input.Focus()
+}
+
+func (d *TeeDialog) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyPressMsg:
+		switch msg.String() {
+		case "enter":
+			if _, _, _, d.err = parseTee(d.input.Value()); d.err != nil {
+				return d, nil
+			}
+			return d, closeWith(TeeMsg{Cmd: d.cmd, Stderr: d.stderr, Target: d.input.Value()})
+		case "esc", "ctrl+c", "ctrl+g":
+			return d, func() tea.Msg { return CloseSelectorMsg{} }
+		}
+	case tea.WindowSizeMsg:
+		d.width = msg.Width
+		d.height = msg.Height
+		d.input.SetWidth(max(10, msg.Width/2))
+		return d, nil
+	}
+	var cmd tea.Cmd
+	d.input, cmd = d.input.Update(msg)
+	return d, cmd
+}
+
+func (d *TeeDialog) View() tea.View {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+
+	stream := "stdout"
+	if d.stderr {
+		stream = "stderr"
+	}
+	title := titleStyle.Render(fmt.Sprintf("Tee the %s of %s", stream, d.cmd.CommandLine()))
+	status := ""
+	if d.err != nil {
+		status = highlightColor.Render(d.err.Error())
+	}
+	help := helpStyle.Render("enter: start  esc: cancel  T in the viewer stops it")
+	content := lipgloss.JoinVertical(lipgloss.Left, title, d.input.View(), status, help)
+
+	dialog := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color("12")).
+		Padding(1, 2).
+		Render(content)
+
+	centered := lipgloss.Place(d.width, d.height, lipgloss.Center, lipgloss.Center, dialog)
+	return tea.NewView(centered)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/Melkor333/oils-readline/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLfWriter(t *testing.T) {
+	var b strings.Builder
+	w := &lfWriter{w: &b}
+	for _, chunk := range []string{"a\r", "\nb\r\n", "c\rd\r", "e"} {
+		w.Write([]byte(chunk))
+	}
+	assert.Equal(t, "a\nb\nc\rd\re", b.String())
+}
+
+func TestParseTee(t *testing.T) {
+	path, flag, command, err := parseTee(" >> out.log")
+	assert.Equal(t, "out.log", path)
+	assert.Equal(t, os.O_APPEND, flag)
+	assert.Empty(t, command)
+	assert.NoError(t, err)
+
+	_, _, command, err = parseTee("| grep -v x")
+	assert.Equal(t, "grep -v x", command)
+	assert.NoError(t, err)
+
+	for _, target := range []string{"out.log", ">", "|  "} {
+		_, _, _, err = parseTee(target)
+		assert.ErrorIs(t, err, errTeeTarget, target)
+	}
+}
+
+func runningCmd(t *testing.T, m *model, line string) *fakeCommand {
+	t.Helper()
+	m.Update(CommandEnteredMsg{Text: line})
+	c, _ := m.history.Last()
+	c.(*fakeCommand).state = shell.Started
+	return c.(*fakeCommand)
+}
+
+func TestTeeToFile(t *testing.T) {
+	m, _ := newShellModel()
+	c := runningCmd(t, m, "tail -f log")
+	c.subs[shell.Stdout].Write([]byte("before\r\n"))
+
+	path := filepath.Join(t.TempDir(), "out.log")
+	wait := update(t, m, TeeMsg{Cmd: c, Target: "> " + path})
+	assert.Equal(t, UnteeMsg{Cmd: c}, wait, "stops once the command is done")
+	c.subs[shell.Stdout].Write([]byte("one\r\ntwo\r\n"))
+	update(t, m, UnteeMsg{Cmd: c})
+	c.subs[shell.Stdout].Write([]byte("after\r\n"))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(b))
+	assert.Empty(t, m.tees)
+
+	// Stopped commands can't be teed
+	c.state = shell.Stopped
+	assert.Nil(t, update(t, m, TeeMsg{Cmd: c, Target: ">> " + path}))
+}
+
+func TestTeeToCommand(t *testing.T) {
+	m, first := newShellModel()
+	c := runningCmd(t, m, "tail -f log")
+
+	_, cmd := m.Update(TeeMsg{Cmd: c, Stderr: true, Target: "| grep error"})
+	msgs := batch(cmd)
+	assert.Contains(t, msgs, UnteeMsg{Cmd: c})
+	var created tea.Msg
+	for _, msg := range msgs {
+		if _, ok := msg.(shellCreatedMsg); ok {
+			created = msg
+		}
+	}
+	if !assert.NotNil(t, created, "runs in a new shell, the first one is busy") {
+		return
+	}
+	// The rest of its batch waits for the new shell to end
+	m.Update(created)
+	second := m.shells[1].Shell.(*namedShell)
+	entered := created.(shellCreatedMsg).then(second)
+	if assert.IsType(t, CommandEnteredMsg{}, entered) {
+		assert.Same(t, c, entered.(CommandEnteredMsg).PipeFrom)
+	}
+	m.Update(entered)
+
+	assert.Equal(t, []string{"grep error"}, second.commands)
+	assert.Equal(t, []string{"tail -f log"}, first.commands)
+	grep, _ := m.history.Last()
+	i, _ := m.history.Source(grep)
+	assert.Equal(t, 0, i)
+
+	go c.subs[shell.Stderr].Write([]byte("an error\r\n"))
+	line, err := bufio.NewReader(grep.(*fakeCommand).input).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "an error\r\n", line, "stderr isn't a pty")
+
+	// grep sees the end once the tee stops
+	update(t, m, UnteeMsg{Cmd: c})
+	_, err = grep.(*fakeCommand).input.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestTeeDialog(t *testing.T) {
+	c := newFakeCmd("make", "")
+	for _, v := range []tea.Model{newStdoutViewer(nil), newTerminal(nil)} {
+		v.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+		v.Update(shell.CommandMsg{Cmd: c})
+		_, cmd := v.Update(tea.KeyPressMsg{Code: 't', Text: "t"})
+		assert.Equal(t, OpenTeeMsg{Cmd: c}, cmd())
+	}
+
+	d := newTeeDialog(OpenTeeMsg{Cmd: c, Stderr: true})
+	d.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	d.Init()
+	typeText(d, "out.log")
+	_, cmd := d.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	assert.Nil(t, cmd)
+	assert.Contains(t, d.View().Content, "expected > FILE")
+
+	d.input.SetValue("> out.log")
+	_, cmd = d.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	assert.Equal(t, []tea.Msg{TeeMsg{Cmd: c, Stderr: true, Target: "> out.log"}, CloseSelectorMsg{}}, sequence(t, cmd))
+}
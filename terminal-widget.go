@@ -16,6 +16,7 @@ import (
 )
 
 type Terminal struct {
+	shellBinding
 	command         shell.Command
 	term            vt.Terminal
 	position        int
@@ -31,8 +32,9 @@ func (h *Terminal) commandRunning() bool {
 	return h.command != nil && (h.command.State() == shell.Queued || h.command.State() == shell.Started)
 }
 
-func newTerminal() *Terminal {
-	return &Terminal{targetIndex: -1, currentIndex: -1, exitMenuSelect: menuSelectHidden, term: vt.NewSafeEmulator(10, 10)}
+// newTerminal shows the commands of s, or of all shells if s is nil.
+func newTerminal(s shell.Shell) *Terminal {
+	return &Terminal{shellBinding: shellBinding{shell: s}, targetIndex: -1, currentIndex: -1, exitMenuSelect: menuSelectHidden, term: vt.NewSafeEmulator(10, 10)}
 }
 
 func (h *Terminal) Init() tea.Cmd {
@@ -74,10 +76,10 @@ func (h *Terminal) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 				case menuSelectSendctrlc:
 					h.exitMenuSelect = menuSelectHidden
-					return h, func() tea.Msg {
-						h.WriteStdin([]byte{'\x03'})
-						return nil
+					if h.command == nil {
+						return h, nil
 					}
+					return h, sendSignal(h.command.Interrupt)
 				case menuSelectExit:
 					h.interactiveMode = false
 					h.exitMenuSelect = menuSelectHidden
@@ -132,6 +134,20 @@ func (h *Terminal) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				return h, RequestCapture()
 			}
+		case "r", "R", "i":
+			return h, rerunKeys(msg.String(), h.command, h.shell)
+		case "p":
+			return h, pipeKey(h.command, false, h.shell)
+		case "t", "T":
+			return h, teeKeys(msg.String(), h.command, false)
+		case "x":
+			if h.commandRunning() {
+				return h, sendSignal(h.command.Interrupt)
+			}
+		case "X":
+			if h.commandRunning() {
+				return h, sendSignal(h.command.Kill)
+			}
 		case "h":
 			if h.targetIndex >= 0 {
 				h.targetIndex -= 1
@@ -152,8 +168,12 @@ func (h *Terminal) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return h, nil
 		}
+	case ShellsMsg:
+		h.updateShells(msg)
+		return h, nil
+
 	case shell.CommandMsg:
-		if h.targetIndex < 0 {
+		if h.targetIndex < 0 && h.follows(msg.Shell) {
 			h.interactiveMode = false
 			h.position = 0
 			h.exitMenuSelect = menuSelectHidden
@@ -171,6 +191,9 @@ func (h *Terminal) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return h, ReleaseCapture()
 
 	case shell.CommandDoneMsg:
+		if !h.follows(msg.Shell) {
+			return h, nil
+		}
 		if h.interactiveMode {
 			h.interactiveMode = false
 			h.exitMenuSelect = menuSelectHidden
@@ -183,17 +206,12 @@ func (h *Terminal) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if h.targetIndex > msg.Total {
 			h.targetIndex = msg.Total
 			if msg.Total == msg.Index+1 {
-				h.currentIndex = msg.Index
-				h.command = msg.Cmd
-				h.flushOutput()
-				h.updateContent()
+				h.show(msg.Cmd, msg.Index)
 			}
 			return h, nil
 		}
 		if h.targetIndex == msg.Index || h.targetIndex < 0 {
-			h.currentIndex = msg.Index
-			h.command = msg.Cmd
-			h.updateContent()
+			h.show(msg.Cmd, msg.Index)
 		}
 		log.Printf("Current: %v; Target %v", h.currentIndex, h.targetIndex)
 		return h, nil
@@ -214,6 +232,9 @@ func (h *Terminal) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case shell.StdoutMsg:
 		log.Print("Stdout output received:")
+		if h.command == nil {
+			return h, nil
+		}
 		if h.currentIndex < 0 && h.command == msg.Cmd {
 			h.updateContent()
 		}
@@ -251,11 +272,18 @@ func (h *Terminal) View() tea.View {
 	}
 
 	cmdLine := h.command.CommandLine()
+	if commandFailed(h.command) {
+		cmdLine = highlightColor.Render(cmdLine)
+	}
 
 	if h.commandRunning() {
 		cmdLine = activeColor.Render("● ") + cmdLine
 	}
 
+	if status := exitStatus(h.command); status != "" {
+		cmdLine = cmdLine + " " + status
+	}
+
 	if h.interactiveMode {
 		cmdLine = cmdLine + " " + highlightColor.Render("[interactive]")
 	}
@@ -264,6 +292,9 @@ func (h *Terminal) View() tea.View {
 	if h.targetIndex != h.currentIndex || h.targetIndex < 0 {
 		sticky = activeColor
 	}
+	if commandFailed(h.command) {
+		sticky = highlightColor
+	}
 	if h.currentIndex >= 0 {
 		i := sticky.Render(fmt.Sprintf("[%d]", h.currentIndex))
 		return tea.NewView(fmt.Sprintf("%v %s\n%s", i, cmdLine, h.term.String()))
@@ -271,6 +302,18 @@ func (h *Terminal) View() tea.View {
 	return tea.NewView(cmdLine + "\n" + h.term.Render())
 }
 
+// show switches to the command at index of the history, replaying its output
+// from the start.
+func (h *Terminal) show(c shell.Command, index int) {
+	h.currentIndex = index
+	if c != h.command {
+		h.command = c
+		h.position = 0
+		h.flushOutput()
+	}
+	h.updateContent()
+}
+
 func (h *Terminal) updateContent() {
 	if h.command == nil {
 		return
@@ -20,13 +20,13 @@ func updateTerminal(t *testing.T, h tea.Model, msg tea.Msg) *Terminal {
 // ---------------------------------------------------------------------------
 
 func TestTerminalViewEmpty(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	v := h.View()
 	assert.Equal(t, "", v.Content)
 }
 
 func TestTerminalShowsLastCommand(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	cmd1 := newFakeCmd("echo hello", "hello\n")
 	cmd2 := newFakeCmd("echo world", "world\n")
@@ -50,7 +50,7 @@ func TestTerminalShowsLastCommand(t *testing.T) {
 }
 
 func TestTerminalStdoutUpdatesContent(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	cmd := newFakeCmd("ls", "")
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -66,7 +66,7 @@ func TestTerminalStdoutUpdatesContent(t *testing.T) {
 }
 
 func TestTerminalReplacesPreviousCommand(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	cmd1 := newFakeCmd("echo first", "first\n")
 	cmd2 := newFakeCmd("echo second", "second\n")
@@ -88,7 +88,7 @@ func TestTerminalReplacesPreviousCommand(t *testing.T) {
 }
 
 func TestTerminalStdoutForOlderCommandIgnored(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	cmd1 := newFakeCmd("echo old", "old\n")
 	cmd2 := newFakeCmd("echo new", "new\n")
@@ -103,7 +103,7 @@ func TestTerminalStdoutForOlderCommandIgnored(t *testing.T) {
 }
 
 func TestTerminalMultipleStdoutUpdates(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	cmd := newFakeCmd("stream-cmd", "")
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -121,7 +121,7 @@ func TestTerminalMultipleStdoutUpdates(t *testing.T) {
 }
 
 func TestTerminalWindowSizeUpdate(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	cmd := newFakeCmd("echo hi", "hi\n")
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -133,7 +133,7 @@ func TestTerminalWindowSizeUpdate(t *testing.T) {
 }
 
 func TestTerminalRunningState(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("echo hi", "hi\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -156,7 +156,7 @@ func TestTerminalRunningState(t *testing.T) {
 }
 
 func TestTerminalRunningIndicatorInView(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("echo hi", "hi\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -174,7 +174,7 @@ func TestTerminalRunningIndicatorInView(t *testing.T) {
 }
 
 func TestTerminalInteractiveModeRequiresRunning(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("sleep 1", "")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -199,7 +199,7 @@ func TestTerminalInteractiveModeRequiresRunning(t *testing.T) {
 }
 
 func TestTerminalCommandDoneReleasesCapture(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("sleep 1", "")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -223,7 +223,7 @@ func TestTerminalCommandDoneReleasesCapture(t *testing.T) {
 // ---------------------------------------------------------------------------
 
 func TestTerminalHandlesANSISequences(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("cmd", "\033[31mred text\033[0m\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -242,7 +242,7 @@ func TestTerminalHandlesANSISequences(t *testing.T) {
 }
 
 func TestTerminalHandlesANSICursorMovement(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
 
@@ -261,7 +261,7 @@ func TestTerminalHandlesANSICursorMovement(t *testing.T) {
 }
 
 func TestTerminalHandlesOSCSequences(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("cmd", "\033]0;window title\007\033]2;icon title\007visible text\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -276,7 +276,7 @@ func TestTerminalHandlesOSCSequences(t *testing.T) {
 }
 
 func TestTerminalHandlesHyperlinkOSC(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("cmd", "\033]8;;https://example.com\007link text\033]8;;\007\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -293,7 +293,7 @@ func TestTerminalHandlesHyperlinkOSC(t *testing.T) {
 }
 
 func TestTerminalHandlesMixedPlainAndANSIContent(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	mixed := "plain line\n" +
 		"\033[31mred text\033[0m\n" +
 		"\033]0;title\007" +
@@ -319,7 +319,7 @@ func TestTerminalHandlesMixedPlainAndANSIContent(t *testing.T) {
 // ---------------------------------------------------------------------------
 
 func TestTerminalCommandLineWithIndex(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("my-cmd", "output\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -333,7 +333,7 @@ func TestTerminalCommandLineWithIndex(t *testing.T) {
 }
 
 func TestTerminalHistoryNavigation(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("current cmd", "current output\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -389,12 +389,28 @@ func TestTerminalHistoryNavigation(t *testing.T) {
 	assert.Equal(t, 1, h.targetIndex, "'h' in sticky mode should decrement targetIndex")
 }
 
+func TestTerminalHistoryReplaysOutput(t *testing.T) {
+	h := newTerminal(nil)
+	cmd := newFakeCmd("current cmd", "a much longer current output\n")
+
+	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
+	h = updateTerminal(t, h, shell.CommandMsg{Cmd: cmd})
+	h = updateTerminal(t, h, shell.StdoutMsg{Cmd: cmd})
+
+	// e.g. a saved output from an earlier session
+	prevCmd := newFakeCmd("make", "ok\n")
+	h = updateTerminal(t, h, history.HistoryEntryMsg{Cmd: prevCmd, Index: 1, Total: 10})
+	view := h.View().Content
+	assert.Contains(t, view, "ok")
+	assert.NotContains(t, view, "current output")
+}
+
 // ---------------------------------------------------------------------------
 // 4. Exit menu tests
 // ---------------------------------------------------------------------------
 
 func TestTerminalExitMenuNavigation(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("cat", "")
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
 	h = updateTerminal(t, h, shell.CommandMsg{Cmd: cmd})
@@ -440,7 +456,7 @@ func TestTerminalExitMenuNavigation(t *testing.T) {
 }
 
 func TestTerminalExitMenuEnterAction(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("cat", "")
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
 	h = updateTerminal(t, h, shell.CommandMsg{Cmd: cmd})
@@ -456,7 +472,7 @@ func TestTerminalExitMenuEnterAction(t *testing.T) {
 	assert.NotNil(t, cmd1, "enter in interactive mode (hidden menu) should return a command")
 
 	// --- menuSelectSendctrlc ---
-	// Enter sends 0x03 to stdin and resets menu selection.
+	// Enter interrupts the command and resets menu selection.
 	h.exitMenuSelect = menuSelectSendctrlc
 	result2, cmd2 := h.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
 	h = result2.(*Terminal)
@@ -493,7 +509,7 @@ func TestTerminalExitMenuEnterAction(t *testing.T) {
 // ---------------------------------------------------------------------------
 
 func TestTerminalWriteStdinError(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 
 	_, err := h.WriteStdin([]byte("input"))
 	assert.Error(t, err)
@@ -501,7 +517,7 @@ func TestTerminalWriteStdinError(t *testing.T) {
 }
 
 func TestTerminalNewCommandResetsPosition(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
 
 	cmd1 := newFakeCmd("cmd1", "initial output")
@@ -516,7 +532,7 @@ func TestTerminalNewCommandResetsPosition(t *testing.T) {
 }
 
 func TestTerminalStdoutMsgUpdatesTermContent(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("cmd", "stdout content\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -529,7 +545,7 @@ func TestTerminalStdoutMsgUpdatesTermContent(t *testing.T) {
 }
 
 func TestTerminalViewIncludesTerminalContent(t *testing.T) {
-	h := newTerminal()
+	h := newTerminal(nil)
 	cmd := newFakeCmd("my-cmd", "line1\nline2\n")
 
 	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
@@ -541,3 +557,17 @@ func TestTerminalViewIncludesTerminalContent(t *testing.T) {
 	assert.Contains(t, view, "line1", "view should include terminal output")
 	assert.Contains(t, view, "line2", "view should include terminal output")
 }
+
+func TestTerminalFailedCommand(t *testing.T) {
+	h := newTerminal(nil)
+	cmd := &fakeCommand{commandLine: "make", stdout: "building\n", state: shell.Stopped, exitCode: 2, err: &shell.ExitError{Status: 2}}
+
+	h = updateTerminal(t, h, tea.WindowSizeMsg{Width: 80, Height: 24})
+	h = updateTerminal(t, h, shell.CommandMsg{Cmd: cmd})
+	h.currentIndex = 4
+
+	view := h.View().Content
+	assert.Contains(t, view, "[4]")
+	assert.Contains(t, view, "make")
+	assert.Contains(t, view, "✘ 2")
+}
@@ -146,7 +146,7 @@ func (l *Layout) BorderStyle(s lipgloss.Border) *Layout {
 	return l
 }
 
-// FocusFocusMsg is sent by a widget to request focus on a specific model.
+// RequestFocusMsg is sent to focus a specific model.
 type RequestFocusMsg struct {
 	Model tea.Model
 }
@@ -237,6 +237,13 @@ func (l *Layout) Dispatch(msg tea.Msg) (tea.Msg, tea.Cmd) {
 		return nil, l.focusPrev()
 	case RequestFocusMainMsg:
 		return nil, l.focusFirst()
+	case RequestFocusMsg:
+		for _, leaf := range l.tree.leafs() {
+			if leaf.model == msg.Model {
+				return nil, l.focus(leaf)
+			}
+		}
+		return nil, nil
 	case tea.BlurMsg:
 		return nil, l.blurMsg()
 	case tea.KeyPressMsg:
@@ -1,9 +1,11 @@
 package tiling
 
 import (
+	"strings"
 	"testing"
 
 	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/x/ansi"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -66,3 +68,29 @@ func TestPosition(t *testing.T) {
 		})
 	}
 }
+
+type titled struct {
+	M
+	title string
+}
+
+func (t titled) Update(tea.Msg) (tea.Model, tea.Cmd) { return t, nil }
+func (t titled) Title() string                       { return t.title }
+
+func TestTitles(t *testing.T) {
+	l, _ := New().Size(40, 9).Split(SplitVerticalWithMain).AddChildren(0,
+		titled{M{"main"}, "full"},
+		titled{M{"top"}, "first"},
+		titled{M{"bottom"}, "second"},
+		M{"untitled"},
+	)
+	lines := strings.Split(ansi.Strip(renderLayer(l.RenderLayer())), "\n")
+
+	// The main pane spans the whole height, there's no border for its title
+	assert.NotContains(t, strings.Join(lines, "\n"), "full")
+	// Right column: 3 panes of height 3 with borders at lines 3 and 6
+	assert.Contains(t, lines[3], " first ", "top pane's title goes on its bottom border")
+	assert.True(t, strings.HasSuffix(strings.TrimRight(lines[3], " "), "first ─"), "and is right aligned: %q", lines[3])
+	assert.Contains(t, lines[3], "─ second", "other titles go on their top border: %q", lines[3])
+	assert.NotContains(t, lines[6], "untitled")
+}
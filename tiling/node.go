@@ -84,6 +84,23 @@ func (n *node) contains(m tea.Model) bool {
 	return false
 }
 
+// leafs returns all nodes below n without children.
+func (n *node) leafs() []*node {
+	var leafs []*node
+	middles := []*node{n}
+	for c := 0; c < len(middles); c++ {
+		middle := middles[c]
+		for _, child := range middle.children {
+			if len(child.children) > 0 {
+				middles = append(middles, child)
+			} else {
+				leafs = append(leafs, child)
+			}
+		}
+	}
+	return leafs
+}
+
 func (n *node) insertSorted(child *node) {
 	prio := child.priority
 	i := 0
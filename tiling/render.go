@@ -47,9 +47,46 @@ func (n *node) Render() *lipgloss.Layer {
 func (l *Layout) RenderLayer() *lipgloss.Layer {
 	content := l.tree.Render()
 	content.AddLayers(l.calculateBorders())
+	content.AddLayers(l.titles()...)
 	return content
 }
 
+// Titled is implemented by models which want their title on the border.
+type Titled interface {
+	Title() string
+}
+
+// titles puts the title of each leaf on its top border.
+// Leafs at the top edge don't have one, their title goes right-aligned on the
+// bottom border (so it doesn't collide with the title of the leaf below).
+func (l *Layout) titles() []*lipgloss.Layer {
+	root := l.tree.rectangle
+	var layers []*lipgloss.Layer
+	for _, leaf := range l.tree.leafs() {
+		t, ok := leaf.model.(Titled)
+		if !ok || t.Title() == "" {
+			continue
+		}
+		r := leaf.rectangle
+		if r.width < 3 {
+			continue
+		}
+		color := l.inactiveColor
+		if leaf == l.focussed {
+			color = l.activeColor
+		}
+		title := lipgloss.NewStyle().Foreground(color).MaxWidth(r.width - 2).Render(" " + t.Title() + " ")
+		switch {
+		case r.y > root.y:
+			layers = append(layers, lipgloss.NewLayer(title).X(r.x+1).Y(r.y-1).Z(2))
+		case r.y+r.height < root.y+root.height:
+			x := r.x + r.width - lipgloss.Width(title) - 1
+			layers = append(layers, lipgloss.NewLayer(title).X(x).Y(r.y+r.height).Z(2))
+		}
+	}
+	return layers
+}
+
 func (l *Layout) calculateBorders() *lipgloss.Layer {
 	rNode := l.tree
 	root := l.tree.rectangle
@@ -63,24 +100,10 @@ func (l *Layout) calculateBorders() *lipgloss.Layer {
 		return lipgloss.NewLayer(lipgloss.NewStyle().Width(root.width).Height(root.height).Render("")).X(root.x).Y(root.y)
 	}
 
-	// Get a list of all leaf nodes. We only need to calculate leaf nodes.
-	// Or do we? :D
-	var leafs []*node
-	middles := []*node{rNode}
-	for c := 0; c < len(middles); c++ {
-		middle := middles[c]
-		for _, child := range middle.children {
-			if len(child.children) > 0 {
-				middles = append(middles, child)
-			} else {
-				leafs = append(leafs, child)
-			}
-		}
-	}
-
 	// Calculate the edges for each leaf node
+	// We only need to calculate leaf nodes. Or do we? :D
 	bitMask := make([]int, (root.width)*(root.height))
-	for _, c := range leafs {
+	for _, c := range rNode.leafs() {
 		child := c.rectangle
 
 		// calculate the rectangle for the border
@@ -1,40 +1,32 @@
 package main
 
 import (
-	"context"
+	"bytes"
 	_ "embed"
-	// TODO: use ysh, not bash
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
 	tree_sitter_ysh "github.com/danyspin97/tree-sitter-ysh/bindings/go"
 	tree_sitter "github.com/tree-sitter/go-tree-sitter"
-	//tree_sitter_ysh "github.com/tree-sitter/tree-sitter-go/bindings/go"
-	highlight "go.gopad.dev/go-tree-sitter-highlight"
-	"log"
-	"strings"
 )
 
-// We need at least 2 additional `.scm` files:
-
-// https://github.com/nvim-treesitter/nvim-treesitter/blob/42fc28ba918343ebfd5565147a42a26580579482/queries/ecma/highlights.scm
+// The highlights of the grammar we use:
+// https://github.com/danyspin97/tree-sitter-ysh/blob/main/queries/highlights.scm
+// It has to match its version, or the query doesn't compile.
 //
 //go:embed assets/highlights.scm
 var highlights []byte
 
-// https://github.com/nvim-treesitter/nvim-treesitter/blob/42fc28ba918343ebfd5565147a42a26580579482/queries/ecma/locals.scm
-//
-// /go:embed assets/locals.scm
-var locals = []byte{}
-
-// No injections for now!
-// Would be: https://github.com/nvim-treesitter/nvim-treesitter/blob/42fc28ba918343ebfd5565147a42a26580579482/queries/ecma/injections.scm
-// But this means we'd maintain a language stack with
-var injections = []byte{}
-
 // the colors for each type
 // better approach would be reading a theme:
 // https://tree-sitter.github.io/tree-sitter/cli/init-config.html#theme
+// Captures like function.call fall back to function.
 var colorMap = map[string]string{
 	"black":                 "\033[0m",
 	"attribute":             "\033[0;31m",
+	"boolean":               "\033[0;33m",
 	"comment":               "\033[0;32m",
 	"constant":              "\033[0;33m",
 	"constant.builtin":      "\033[0;33m",
@@ -59,123 +51,269 @@ var colorMap = map[string]string{
 	"variable":              "\033[0;33m",
 	"variable.builtin":      "\033[0;33m",
 	"variable.parameter":    "\033[0;34m",
+	// What doesn't parse, from ERROR and MISSING nodes
+	"error": "\033[4;31m",
 }
 
+// colorOf looks up a capture, or what it's a special case of.
+func colorOf(capture string) string {
+	for {
+		if c, ok := colorMap[capture]; ok {
+			return c
+		}
+		i := strings.LastIndexByte(capture, '.')
+		if i < 0 {
+			return ""
+		}
+		capture = capture[:i]
+	}
+}
+
+// The language and query are the same for all highlighters and expensive to set up
+var yshQuery = sync.OnceValues(func() (*tree_sitter.Query, error) {
+	language := tree_sitter.NewLanguage(tree_sitter_ysh.Language())
+	query, err := tree_sitter.NewQuery(language, string(highlights))
+	if err != nil {
+		// A *QueryError, but a nil one isn't a nil error
+		return nil, fmt.Errorf("highlights.scm: %v", err)
+	}
+	return query, nil
+})
+
+// Highlighter colors ysh code. Each Parse reuses the tree of the last one, so
+// highlighting while typing only parses what changed.
+// Not safe for concurrent use.
 type Highlighter struct {
 	parser           *tree_sitter.Parser
-	language         *tree_sitter.Language
 	query            *tree_sitter.Query
 	queryCursor      *tree_sitter.QueryCursor
 	existingCaptures []string
-	tree             *tree_sitter.Tree
-	events           *[]highlight.Event
-	Highlighter      *highlight.Highlighter
-	cfg              *highlight.Configuration
+	// Of each pattern, see #set! priority
+	priorities []int
+	tree       *tree_sitter.Tree
+	// The code of tree
+	source []byte
 }
 
 func (h *Highlighter) Close() {
 	h.parser.Close()
-	h.query.Close()
 	h.queryCursor.Close()
-	h.tree.Close()
+	if h.tree != nil {
+		h.tree.Close()
+	}
 }
 
-func NewHighlighter() *Highlighter {
-	var h Highlighter
-
-	// The types we want to highlight
-	// Order is relevant
-	captureNames := []string{
-		"attribute",
-		"comment",
-		"constant",
-		"constructor",
-		"function",
-		"keyword",
-		"module",
-		"number",
-		"operator",
-		"property",
-		"property.builtin",
-		"punctuation",
-		"string",
-		"tag",
-		"type",
-		"variable",
-	}
-
-	// set up the language and parser
-	h.language = tree_sitter.NewLanguage(tree_sitter_ysh.Language())
-	h.parser = tree_sitter.NewParser()
-	h.parser.SetLanguage(h.language)
-
-	// Parse and create the query
-	h.query, _ = tree_sitter.NewQuery(h.language, string(highlights))
-	h.queryCursor = tree_sitter.NewQueryCursor()
-
-	// Get a list of all captures
-	// More efficient would be to make the colormap based on int -> color instead of string -> color
-	h.existingCaptures = h.query.CaptureNames()
-
-	// Set up the highlighter and its config
-	var err error
-	h.cfg, err = highlight.NewConfiguration(h.language, "ysh", highlights, injections, locals)
+func NewHighlighter() (*Highlighter, error) {
+	query, err := yshQuery()
 	if err != nil {
-		log.Fatal(err)
-	}
-	// What objects we care about
-	h.cfg.Configure(captureNames)
-	// Create the highlighter and
-	h.Highlighter = highlight.New()
-	// Start with an empty tree
-	h.tree = h.parser.Parse([]byte("echo hello world"), nil)
-	return &h
+		return nil, err
+	}
+	h := Highlighter{
+		parser:      tree_sitter.NewParser(),
+		query:       query,
+		queryCursor: tree_sitter.NewQueryCursor(),
+		// More efficient would be to make the colormap based on int -> color instead of string -> color
+		existingCaptures: query.CaptureNames(),
+	}
+	h.parser.SetLanguage(tree_sitter.NewLanguage(tree_sitter_ysh.Language()))
+
+	// Like in neovim, higher priorities win and the default is 100
+	h.priorities = make([]int, query.PatternCount())
+	for i := range h.priorities {
+		h.priorities[i] = 100
+		for _, p := range query.PropertySettings(uint(i)) {
+			if p.Key != "priority" || p.Value == nil {
+				continue
+			}
+			if prio, err := strconv.Atoi(*p.Value); err == nil {
+				h.priorities[i] = prio
+			}
+		}
+	}
+	return &h, nil
 }
 
-func (h *Highlighter) Highlight(_code string) string {
-	if len(_code) < 3 {
-		return _code
+// Parse makes code the one to highlight. The old tree is edited to match, so
+// the parser can reuse what didn't change.
+func (h *Highlighter) Parse(code string) {
+	source := []byte(code)
+	if h.tree != nil {
+		if bytes.Equal(source, h.source) {
+			return
+		}
+		h.tree.Edit(inputEdit(h.source, source))
 	}
-	code := []byte(_code)
-	events := h.Highlighter.Highlight(context.Background(), *h.cfg, code, func(name string) *highlight.Configuration {
-		return nil
-	})
+	tree := h.parser.Parse(source, h.tree)
+	if h.tree != nil {
+		h.tree.Close()
+	}
+	h.tree, h.source = tree, source
+}
+
+// inputEdit describes the change from old to new as a single replacement
+// between their common prefix and suffix.
+func inputEdit(old, new []byte) *tree_sitter.InputEdit {
+	start := 0
+	for start < len(old) && start < len(new) && old[start] == new[start] {
+		start++
+	}
+	oldEnd, newEnd := len(old), len(new)
+	for oldEnd > start && newEnd > start && old[oldEnd-1] == new[newEnd-1] {
+		oldEnd--
+		newEnd--
+	}
+	return &tree_sitter.InputEdit{
+		StartByte:      uint(start),
+		OldEndByte:     uint(oldEnd),
+		NewEndByte:     uint(newEnd),
+		StartPosition:  pointAt(old, start),
+		OldEndPosition: pointAt(old, oldEnd),
+		NewEndPosition: pointAt(new, newEnd),
+	}
+}
+
+// pointAt is the row and byte column of offset i in code.
+func pointAt(code []byte, i int) tree_sitter.Point {
+	row := bytes.Count(code[:i], []byte("\n"))
+	column := i - (bytes.LastIndexByte(code[:i], '\n') + 1)
+	return tree_sitter.Point{Row: uint(row), Column: uint(column)}
+}
+
+// Colors returns the ANSI color of each byte of the code given to Parse, "" if
+// it has none.
+func (h *Highlighter) Colors() []string {
+	colors := make([]string, len(h.source))
+	if h.tree == nil {
+		return colors
+	}
+	root := h.tree.RootNode()
+
+	// Later patterns are more specific, they win unless their priority is lower
+	priority := make([]int, len(h.source))
+	captures := h.queryCursor.Captures(h.query, root, h.source)
+	for match, i := captures.Next(); match != nil; match, i = captures.Next() {
+		capture := match.Captures[i]
+		color := colorOf(h.existingCaptures[capture.Index])
+		prio := h.priorities[match.PatternIndex]
+		for b := capture.Node.StartByte(); b < capture.Node.EndByte() && b < uint(len(colors)); b++ {
+			if prio >= priority[b] {
+				colors[b], priority[b] = color, prio
+			}
+		}
+	}
+
+	if root.HasError() {
+		markErrors(root, colors)
+	}
+	return colors
+}
+
+// markErrors colors what doesn't parse below n. MISSING nodes are empty, so the
+// byte before them is marked instead.
+func markErrors(n *tree_sitter.Node, colors []string) {
+	if n.IsError() || n.IsMissing() {
+		start, end := n.StartByte(), n.EndByte()
+		if n.IsMissing() && start > 0 {
+			start--
+		}
+		for b := start; b < end && b < uint(len(colors)); b++ {
+			colors[b] = colorMap["error"]
+		}
+		return
+	}
+	for i := range n.ChildCount() {
+		if child := n.Child(i); child.HasError() {
+			markErrors(child, colors)
+		}
+	}
+}
+
+// Highlight returns code with ANSI colors.
+func (h *Highlighter) Highlight(code string) string {
+	h.Parse(code)
+	colors := h.Colors()
 	// The final string containing all highlights
 	var s strings.Builder
-	// Current highlighting type
-	var t string
-	for event, err := range events {
-		if err != nil {
-			log.Fatal(err)
+	for i := 0; i < len(code); {
+		// Current highlighting type
+		t := colors[i]
+		j := i + 1
+		for j < len(code) && colors[j] == t {
+			j++
+		}
+		if t != "" {
+			s.WriteString(t)
 		}
+		s.WriteString(code[i:j])
+		if t != "" {
+			s.WriteString(colorMap["black"])
+		}
+		i = j
+	}
+	return s.String()
+}
+
+// Incomplete tells if the parsed code needs more lines to run, like a block or
+// quote that isn't closed yet. Code with a mistake isn't, running it shows it.
+func (h *Highlighter) Incomplete() bool {
+	if h.tree == nil || !h.tree.RootNode().HasError() {
+		return false
+	}
+	end := uint(len(bytes.TrimRight(h.source, " \t\n")))
+	return missingAt(h.tree.RootNode(), end) || unclosed(string(h.source))
+}
+
+// missingAt tells if the parser expected something more at end, e.g. a }.
+func missingAt(n *tree_sitter.Node, end uint) bool {
+	if n.IsMissing() {
+		return n.StartByte() >= end
+	}
+	for i := range n.ChildCount() {
+		if child := n.Child(i); child.HasError() && missingAt(child, end) {
+			return true
+		}
+	}
+	return false
+}
 
-		switch e := event.(type) {
-
-		//case highlight.EventLayerStart:
-		// Only required if language injections exists
-		// In which case we need to add the new language to the stack
-		// and make sure to use the same language down below
-
-		//case highlight.EventLayerEnd:
-		// Only required if language injections exists
-		// In which case we need to remove the language from the stack
-
-		case highlight.EventCaptureStart:
-			//log.Printf("New highlight capture %v, %v", e.Highlight, h.existingCaptures[e.Highlight])
-			t = h.existingCaptures[e.Highlight]
-		case highlight.EventCaptureEnd:
-			//log.Printf("Capture end")
-		case highlight.EventSource:
-			//log.Printf("Highlight range %d-%d", e.StartByte, e.EndByte)
-			if t != "" {
-				s.WriteString(colorMap[t])
+// unclosed tells if code ends in an open bracket or quote, or with something
+// to continue on the next line. The grammar recovers from those with ERROR
+// nodes, which don't tell them apart from a closing bracket too many.
+func unclosed(code string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
 			}
-			s.Write(code[e.StartByte:e.EndByte])
-			if t != "" {
-				s.WriteString(colorMap["black"])
+		case c == '\\':
+			if i == len(code)-1 {
+				return true
+			}
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || strings.IndexByte(" \t\n;", code[i-1]) >= 0):
+			for i < len(code)-1 && code[i+1] != '\n' {
+				i++
+			}
+		case strings.IndexByte("({[", c) >= 0:
+			depth++
+		case strings.IndexByte(")}]", c) >= 0:
+			depth--
+			if depth < 0 {
+				return false
 			}
-			t = ""
 		}
 	}
-	return s.String()
+	if quote != 0 || depth > 0 {
+		return true
+	}
+	code = strings.TrimRight(code, " \t\n")
+	return strings.HasSuffix(code, "|") || strings.HasSuffix(code, "&&")
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHighlighter(t *testing.T) *Highlighter {
+	t.Helper()
+	h, err := NewHighlighter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestHighlighterColors(t *testing.T) {
+	h := newTestHighlighter(t)
+	code := "echo 'hi' | grep x # done"
+	h.Parse(code)
+	colors := h.Colors()
+	at := func(s string) string { return colors[strings.Index(code, s)] }
+
+	assert.Equal(t, colorMap["function.builtin"], at("echo"), "wins over function.call, which has a lower priority")
+	assert.Equal(t, colorMap["string"], at("'hi'"))
+	assert.Equal(t, colorMap["function"], at("grep"), "function.call falls back to function")
+	assert.Equal(t, colorMap["comment"], at("# done"))
+	assert.Empty(t, at(" |"))
+
+	assert.Contains(t, h.Highlight("echo 'hi'"), colorMap["string"]+"'hi'"+colorMap["black"])
+}
+
+func TestHighlighterErrors(t *testing.T) {
+	h := newTestHighlighter(t)
+	code := "if (x) { echo"
+	h.Parse(code)
+	colors := h.Colors()
+	// The } is missing
+	assert.Equal(t, colorMap["error"], colors[len(code)-1])
+	assert.NotEqual(t, colorMap["error"], colors[0])
+
+	h.Parse("if (x) { echo }")
+	assert.NotContains(t, h.Colors(), colorMap["error"])
+}
+
+func TestHighlighterIncremental(t *testing.T) {
+	h := newTestHighlighter(t)
+	// Typing, deleting and replacing in the middle
+	for _, code := range []string{
+		"e", "ech", "echo $x", "echo $x | grep", "var x = 1", "var x = 12 + f(y)",
+		"var x = f(y)", "for x in (a) {\n  echo $x\n}", "for x in (a) {\n  echo \"$x\n}", "",
+	} {
+		h.Parse(code)
+		fresh := newTestHighlighter(t)
+		fresh.Parse(code)
+		assert.Equal(t, fresh.tree.RootNode().ToSexp(), h.tree.RootNode().ToSexp(), code)
+		assert.Equal(t, fresh.Colors(), h.Colors(), code)
+	}
+}
+
+func TestHighlighterIncomplete(t *testing.T) {
+	h := newTestHighlighter(t)
+	for code, want := range map[string]bool{
+		"echo hi":                      false,
+		"":                             false,
+		"proc foo {":                   true,
+		"proc foo {\n  echo hi":        true,
+		"proc foo {\n  echo hi\n}":     false,
+		"var x = [1,":                  true,
+		"echo $(ls":                    true,
+		"echo 'abc":                    true,
+		"echo '''\nabc":                true,
+		"echo '''\nabc\n'''":           false,
+		"echo \"a \\\" b":              true,
+		"echo a |":                     true,
+		"echo a &&":                    true,
+		"echo a \\":                    true,
+		"echo a &":                     false,
+		"if (x) {\n  echo # {":         true,
+		"echo )":                       false,
+		"}":                            false,
+		"echo a |\n":                   true,
+		"proc f {\n  echo )\n}\n(x) {": false,
+	} {
+		h.Parse(code)
+		assert.Equal(t, want, h.Incomplete(), code)
+	}
+}
@@ -50,6 +50,14 @@ func WrapChildCmd(cmd tea.Cmd, w *Widget) tea.Cmd {
 	}
 }
 
+// Title forwards the title of models that have one (see tiling.Titled).
+func (w *Widget) Title() string {
+	if t, ok := w.Model.(interface{ Title() string }); ok {
+		return t.Title()
+	}
+	return ""
+}
+
 func (w *Widget) Init() tea.Cmd {
 	return WrapChildCmd(w.Model.Init(), w)
 }